@@ -1,20 +1,32 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/gorilla/csrf"
 	gorillamux "github.com/gorilla/mux"
 	"github.com/hazcod/shade/config"
 	"github.com/hazcod/shade/pkg/auth"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/service/enroll"
 	"github.com/hazcod/shade/pkg/service/health"
+	"github.com/hazcod/shade/pkg/service/hibp"
 	"github.com/hazcod/shade/pkg/service/login"
+	"github.com/hazcod/shade/pkg/service/middleware"
 	"github.com/hazcod/shade/pkg/service/password"
+	"github.com/hazcod/shade/pkg/service/policy"
 	"github.com/hazcod/shade/pkg/service/web"
 	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/tlsconfig"
 	"github.com/sirupsen/logrus"
-	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 // LoginData represents the login information captured by the extension
@@ -27,8 +39,41 @@ func main() {
 
 	cfgPath := flag.String("config", "", "path to config file")
 	logLevel := flag.String("log", "", "log level")
+	rekey := flag.Bool("rekey-fingerprints", false, "rewrite stored password hashes with the newest fingerprint key and exit")
+	importDataset := flag.String("import-hibp-dataset", "", "download or update the offline HIBP dataset in this directory and exit")
+	importConcurrency := flag.Int("import-hibp-concurrency", 32, "number of parallel downloads when importing the HIBP dataset")
+	generatePolicyKey := flag.Bool("generate-policy-key", false, "print a new policy signing key and its public key and exit")
 	flag.Parse()
 
+	if *generatePolicyKey {
+		key, err := policy.GenerateKey()
+		if err != nil {
+			logger.WithError(err).Fatal("error generating policy signing key")
+		}
+
+		signer, err := policy.NewSigner(key)
+		if err != nil {
+			logger.WithError(err).Fatal("error loading policy signing key")
+		}
+
+		fmt.Printf("signing_key: %s\npublic_key: %s\n", key, signer.PublicKey())
+		return
+	}
+
+	// the dataset is usually downloaded on a machine with internet access, which needs no config
+	if *importDataset != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		if err := hibp.ImportDataset(ctx, logger,
+			hibp.NewClient(logger, hibp.ClientOptions{MaxConcurrency: *importConcurrency}), *importDataset, *importConcurrency); err != nil {
+			logger.WithError(err).Fatal("error importing HIBP dataset")
+		}
+
+		logger.WithField("path", *importDataset).Info("imported HIBP dataset")
+		return
+	}
+
 	cfg, err := config.LoadConfig(*cfgPath)
 	if err != nil {
 		logger.WithError(err).Fatal("error loading config")
@@ -54,12 +99,83 @@ func main() {
 	// ---
 
 	// Create storage
+	keyring, err := fingerprint.NewKeyring(cfg.Fingerprint.Keys)
+	if err != nil {
+		logger.WithError(err).Fatal("error loading fingerprint keys")
+	}
+
 	storageDriver, err := storage.GetDriver(logger, cfg.Storage.Type, cfg.Storage.Properties)
 	if err != nil {
 		logger.WithError(err).Fatal("error loading storage driver")
 	}
+
+	if *rekey {
+		changed, err := storageDriver.RekeyPasswordHashes(context.Background(), keyring.RekeyFingerprint, keyring.RekeyBreachHash)
+		if closeErr := storageDriver.Close(); closeErr != nil {
+			logger.WithError(closeErr).Error("failed to close storage driver")
+		}
+		if err != nil {
+			logger.WithError(err).Fatal("error rekeying password hashes")
+		}
+
+		logger.WithField("key_id", keyring.ActiveKeyID()).WithField("changed", changed).Info("rekeyed password hashes")
+		return
+	}
 	logger.WithField("driver", cfg.Storage.Type).Info("registered storage driver")
 
+	// keep stored domains in line with the public suffix list compiled into this build
+	normalized, err := storageDriver.NormalizeDomains(context.Background(), domainname.Normalize)
+	if err != nil {
+		logger.WithError(err).Fatal("error normalizing stored domains")
+	}
+	if normalized > 0 {
+		logger.WithField("changed", normalized).Info("normalized stored domains")
+	}
+
+	var policySigner *policy.Signer
+	var policyKey string
+	if cfg.Policy.SigningKey != "" {
+		if policySigner, err = policy.NewSigner(cfg.Policy.SigningKey); err != nil {
+			logger.WithError(err).Fatal("error loading policy signing key")
+		}
+		policyKey = policySigner.PublicKey()
+		logger.WithField("public_key", policyKey).Info("loaded policy signing key")
+	} else {
+		logger.Warn("no policy signing key configured, extensions pinning a policy key will not apply policies")
+	}
+
+	appCatalog, err := catalog.Load(cfg.Catalog.Path)
+	if err != nil {
+		logger.WithError(err).Fatal("error loading SaaS catalog")
+	}
+	logger.WithField("apps", appCatalog.Len()).Info("loaded SaaS catalog")
+
+	// Shared by all dashboard requests so discovered domains are not grouped by app on each of them
+	appCache := web.NewAppCache(logger, storageDriver, appCatalog)
+
+	breachSource, err := hibp.GetSource(logger, cfg.HIBP.Source, cfg.HIBP.Dataset, hibp.ClientOptions{
+		BaseURL:        cfg.HIBP.API.BaseURL,
+		Timeout:        cfg.HIBP.API.Timeout,
+		Padding:        !cfg.HIBP.API.DisablePadding,
+		MaxConcurrency: cfg.HIBP.API.MaxConcurrency,
+		MaxRetries:     cfg.HIBP.API.MaxRetries,
+		RetryBackoff:   cfg.HIBP.API.RetryBackoff,
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("error loading HIBP source")
+	}
+
+	// Shared by all requests so lookups are cached across them, and across replicas through the storage driver
+	hibpCache := hibp.NewCache(logger, storageDriver, cfg.HIBP.Cache.TTL, cfg.HIBP.Cache.MaxEntries)
+	hibpService := hibp.NewService(logger, breachSource, hibpCache)
+
+	hibpQueue := hibp.NewQueue(logger, hibpService, storageDriver, cfg.HIBP.Queue.Workers, cfg.HIBP.Queue.Size)
+	hibpQueue.Start()
+
+	hibpScheduler := hibp.NewScheduler(logger, hibpService, storageDriver, keyring,
+		cfg.HIBP.RecheckInterval, cfg.HIBP.RecheckConcurrency)
+	hibpScheduler.Start()
+
 	// Create auth provider
 	authProperties := make(map[string]interface{})
 	for k, v := range cfg.Auth.Properties {
@@ -138,14 +254,34 @@ func main() {
 	protected.PathPrefix("/dashboard/").Handler(authProvider.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case "/dashboard/":
-			web.GetDashboard(logger, storageDriver).ServeHTTP(w, r)
+			web.GetDashboard(logger, storageDriver, appCache).ServeHTTP(w, r)
 		case "/dashboard/saas":
-			web.GetSaasPage(logger, storageDriver).ServeHTTP(w, r)
+			web.GetSaasPage(logger, appCache).ServeHTTP(w, r)
+		case "/dashboard/saas/review":
+			web.ReviewApp(logger, storageDriver, appCache).ServeHTTP(w, r)
 		case "/dashboard/security":
 			web.GetSecurityPage(logger, storageDriver).ServeHTTP(w, r)
 		case "/dashboard/endpoints":
 			web.GetUsersPage(logger, storageDriver).ServeHTTP(w, r)
+		case "/dashboard/endpoints/revoke":
+			web.RevokeDevice(logger, storageDriver).ServeHTTP(w, r)
+		case "/dashboard/endpoints/reset":
+			web.ResetDevice(logger, storageDriver).ServeHTTP(w, r)
+		case "/dashboard/enforcement":
+			web.GetEnforcementPage(logger, storageDriver).ServeHTTP(w, r)
+		case "/dashboard/policy":
+			web.GetPolicyPage(logger, storageDriver, policyKey).ServeHTTP(w, r)
+		case "/dashboard/policy/update":
+			web.UpdatePolicy(logger, storageDriver).ServeHTTP(w, r)
 		default:
+			if strings.HasPrefix(r.URL.Path, "/dashboard/saas/") {
+				web.GetSaasDetailPage(logger, storageDriver, appCatalog).ServeHTTP(w, r)
+				return
+			}
+			if strings.HasPrefix(r.URL.Path, "/dashboard/users/") {
+				web.GetUserDetailPage(logger, storageDriver, appCatalog).ServeHTTP(w, r)
+				return
+			}
 			http.NotFound(w, r)
 		}
 	})))
@@ -154,24 +290,94 @@ func main() {
 	protected.PathPrefix("/static/").Handler(authProvider.Middleware(web.GetStaticFile(logger)))
 
 	// API endpoints to be used by the extension
-	mux.PathPrefix("/api/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	extension := mux.PathPrefix("/api/").Subrouter()
+	if cfg.HTTP.TLS.ClientCA != "" {
+		extension.Use(middleware.RequireClientCertificate(logger))
+	}
+
+	// Enrollment is authenticated with the bootstrap token instead of a device credential
+	extension.Path("/enroll").Handler(enroll.HandleEnroll(logger, storageDriver, keyring))
+
+	api := extension.PathPrefix("/").Subrouter()
+	api.Use(middleware.RequireDeviceCredential(logger, storageDriver))
+	api.Use(middleware.VerifySignature(logger, storageDriver, keyring, cfg.API.RequireSignatures, cfg.API.MaxClockSkew))
+	api.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case "/api/health":
-			health.HandleHealthCheck(logger, storageDriver).ServeHTTP(w, r)
+			health.HandleHealthCheck(logger).ServeHTTP(w, r)
 		case "/api/creds/register":
-			login.HandleLoginData(logger, storageDriver).ServeHTTP(w, r)
+			login.HandleLoginData(logger, storageDriver, hibpQueue, keyring, appCatalog).ServeHTTP(w, r)
+		case "/api/creds/verdicts":
+			login.HandleVerdicts(logger, storageDriver).ServeHTTP(w, r)
 		case "/api/password/domaincheck":
-			password.CheckDuplicatePassword(logger, storageDriver).ServeHTTP(w, r)
+			password.CheckDuplicatePassword(logger, storageDriver, keyring).ServeHTTP(w, r)
+		case "/api/password/compromised":
+			password.CheckCompromisedPasswords(logger, storageDriver).ServeHTTP(w, r)
+		case "/api/policy":
+			policy.GetPolicy(logger, storageDriver, policySigner).ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
 	}))
 
 	// Start server
-	addr := fmt.Sprintf("%s:%d", cfg.HTTP.Interface, cfg.HTTP.Port)
-	logger.WithField("listener", addr).WithField("dev_mode", devMode).
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.HTTP.Interface, cfg.HTTP.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.HTTP.Timeouts.ReadHeader,
+		ReadTimeout:       cfg.HTTP.Timeouts.Read,
+		WriteTimeout:      cfg.HTTP.Timeouts.Write,
+		IdleTimeout:       cfg.HTTP.Timeouts.Idle,
+	}
+
+	useTLS := cfg.HTTP.TLS.Certificate != ""
+	if useTLS {
+		server.TLSConfig, err = tlsconfig.NewServerConfig(logger,
+			cfg.HTTP.TLS.Certificate, cfg.HTTP.TLS.Key, cfg.HTTP.TLS.MinVersion, cfg.HTTP.TLS.ClientCA)
+		if err != nil {
+			logger.WithError(err).Fatal("error loading TLS configuration")
+		}
+	}
+
+	logger.WithField("listener", server.Addr).WithField("dev_mode", devMode).
+		WithField("tls", useTLS).WithField("mtls", cfg.HTTP.TLS.ClientCA != "").
 		Info("started server")
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		if useTLS {
+			// the certificate is served by the TLS config so it can be reloaded
+			serverErrors <- server.ListenAndServeTLS("", "")
+		} else {
+			serverErrors <- server.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErrors:
+		logger.WithError(err).Fatal("server failed to start")
+	case sig := <-signals:
+		logger.WithField("signal", sig.String()).Info("shutting down server")
 	}
+
+	// stop accepting connections and let in-flight requests finish
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.Timeouts.Shutdown)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("failed to drain in-flight requests")
+	}
+
+	hibpQueue.Close()
+	hibpScheduler.Close()
+	hibpService.Close()
+
+	if err := storageDriver.Close(); err != nil {
+		logger.WithError(err).Error("failed to close storage driver")
+	}
+
+	logger.Info("server stopped")
 }
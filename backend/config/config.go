@@ -5,11 +5,22 @@ import (
 	"github.com/asaskevich/govalidator"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 const (
 	defaultPort     = 8080
 	defaultLogLevel = "info"
+
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 15 * time.Second
+	// long enough for dashboard pages over a large store
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+
+	defaultHIBPRecheckInterval    = 8 * time.Hour
+	defaultHIBPRecheckConcurrency = 4
 )
 
 type Config struct {
@@ -21,12 +32,77 @@ type Config struct {
 		Port      uint16 `yaml:"port" env:"HTTP_PORT"`
 		Interface string `yaml:"interface" env:"HTTP_INTERFACE"`
 		Origin    string `yaml:"origin" env:"HTTP_ORIGIN"`
-		TLS       struct {
+		Timeouts  struct {
+			ReadHeader time.Duration `yaml:"read_header" env:"HTTP_TIMEOUTS_READ_HEADER"`
+			Read       time.Duration `yaml:"read" env:"HTTP_TIMEOUTS_READ"`
+			Write      time.Duration `yaml:"write" env:"HTTP_TIMEOUTS_WRITE"`
+			Idle       time.Duration `yaml:"idle" env:"HTTP_TIMEOUTS_IDLE"`
+			// Shutdown is how long in-flight requests may take to finish when stopping
+			Shutdown time.Duration `yaml:"shutdown" env:"HTTP_TIMEOUTS_SHUTDOWN"`
+		} `yaml:"timeouts"`
+		TLS struct {
 			Certificate string `yaml:"certificate" env:"HTTP_TLS_CERTIFICATE"`
 			Key         string `yaml:"key" env:"HTTP_TLS_KEY"`
+			// MinVersion is one of 1.0, 1.1, 1.2 or 1.3
+			MinVersion string `yaml:"min_version" env:"HTTP_TLS_MIN_VERSION"`
+			// ClientCA enables mutual TLS for the /api/ routes
+			ClientCA string `yaml:"client_ca" env:"HTTP_TLS_CLIENT_CA"`
 		} `yaml:"tls"`
 	} `yaml:"http"`
 
+	API struct {
+		// RequireSignatures rejects extension requests that are not HMAC signed
+		RequireSignatures bool          `yaml:"require_signatures" env:"API_REQUIRE_SIGNATURES"`
+		MaxClockSkew      time.Duration `yaml:"max_clock_skew" env:"API_MAX_CLOCK_SKEW"`
+	} `yaml:"api"`
+
+	HIBP struct {
+		// Source is api to query the HIBP API, or dataset to use an offline Pwned Passwords dataset
+		Source  string `yaml:"source" env:"HIBP_SOURCE"`
+		Dataset string `yaml:"dataset" env:"HIBP_DATASET"`
+		API     struct {
+			// BaseURL allows pointing at an internal proxy or a local test server
+			BaseURL string        `yaml:"base_url" env:"HIBP_API_BASE_URL"`
+			Timeout time.Duration `yaml:"timeout" env:"HIBP_API_TIMEOUT"`
+			// DisablePadding stops asking for padded responses, which hide the response size
+			DisablePadding bool `yaml:"disable_padding" env:"HIBP_API_DISABLE_PADDING"`
+			MaxConcurrency int  `yaml:"max_concurrency" env:"HIBP_API_MAX_CONCURRENCY"`
+			// MaxRetries of 0 disables retries, the client default is used when unset
+			MaxRetries *int `yaml:"max_retries" env:"HIBP_API_MAX_RETRIES"`
+			// RetryBackoff is the wait before the first retry, doubled for every next one
+			RetryBackoff time.Duration `yaml:"retry_backoff" env:"HIBP_API_RETRY_BACKOFF"`
+		} `yaml:"api"`
+		// Queue bounds the background checks of submitted passwords
+		Queue struct {
+			Workers int `yaml:"workers" env:"HIBP_QUEUE_WORKERS"`
+			Size    int `yaml:"size" env:"HIBP_QUEUE_SIZE"`
+		} `yaml:"queue"`
+		Cache struct {
+			// TTL is how long a range response is reused, keep it below the recheck interval
+			TTL time.Duration `yaml:"ttl" env:"HIBP_CACHE_TTL"`
+			// MaxEntries limits the cached ranges, both in memory and in storage
+			MaxEntries int `yaml:"max_entries" env:"HIBP_CACHE_MAX_ENTRIES"`
+		} `yaml:"cache"`
+		// RecheckInterval is how often every stored password is checked against HIBP again
+		RecheckInterval    time.Duration `yaml:"recheck_interval" env:"HIBP_RECHECK_INTERVAL"`
+		RecheckConcurrency int           `yaml:"recheck_concurrency" env:"HIBP_RECHECK_CONCURRENCY"`
+	} `yaml:"hibp"`
+
+	Catalog struct {
+		// Path is a catalog file overriding and extending the built-in SaaS catalog
+		Path string `yaml:"path" env:"CATALOG_PATH"`
+	} `yaml:"catalog"`
+
+	Policy struct {
+		// SigningKey is the base64 Ed25519 seed policies are signed with, create one with -generate-policy-key
+		SigningKey string `yaml:"signing_key" env:"POLICY_SIGNING_KEY"`
+	} `yaml:"policy"`
+
+	Fingerprint struct {
+		// Keys are the peppers by key ID, applied in key ID order. The last one is used for new values.
+		Keys map[string]string `yaml:"keys" env:"FINGERPRINT_KEYS"`
+	} `yaml:"fingerprint"`
+
 	Storage struct {
 		Type       string            `yaml:"type" env:"STORAGE_TYPE"`
 		Properties map[string]string `yaml:"properties" env:"STORAGE_PROPERTIES"`
@@ -53,10 +129,29 @@ func LoadConfig(cfgPath string) (*Config, error) {
 		}
 	}
 
+	if err := applyEnv(&cfg); err != nil {
+		return nil, fmt.Errorf("error applying environment: %w", err)
+	}
+
 	if cfg.HTTP.Port == 0 {
 		cfg.HTTP.Port = defaultPort
 	}
 
+	setDefaultDuration(&cfg.HTTP.Timeouts.ReadHeader, defaultReadHeaderTimeout)
+	setDefaultDuration(&cfg.HTTP.Timeouts.Read, defaultReadTimeout)
+	setDefaultDuration(&cfg.HTTP.Timeouts.Write, defaultWriteTimeout)
+	setDefaultDuration(&cfg.HTTP.Timeouts.Idle, defaultIdleTimeout)
+	setDefaultDuration(&cfg.HTTP.Timeouts.Shutdown, defaultShutdownTimeout)
+
+	setDefaultDuration(&cfg.HIBP.RecheckInterval, defaultHIBPRecheckInterval)
+	if cfg.HIBP.RecheckInterval < 0 {
+		return nil, fmt.Errorf("hibp recheck_interval must be positive")
+	}
+
+	if cfg.HIBP.RecheckConcurrency <= 0 {
+		cfg.HIBP.RecheckConcurrency = defaultHIBPRecheckConcurrency
+	}
+
 	if cfg.Log.Level == "" {
 		cfg.Log.Level = defaultLogLevel
 	}
@@ -65,6 +160,26 @@ func LoadConfig(cfgPath string) (*Config, error) {
 		return nil, fmt.Errorf("auth secret is required")
 	}
 
+	if (cfg.HTTP.TLS.Certificate == "") != (cfg.HTTP.TLS.Key == "") {
+		return nil, fmt.Errorf("tls certificate and key must be set together")
+	}
+
+	if cfg.HTTP.TLS.ClientCA != "" && cfg.HTTP.TLS.Certificate == "" {
+		return nil, fmt.Errorf("tls client_ca requires a tls certificate and key")
+	}
+
+	if cfg.HIBP.API.BaseURL != "" && !govalidator.IsURL(cfg.HIBP.API.BaseURL) {
+		return nil, fmt.Errorf("hibp api base_url is not a valid URL")
+	}
+
+	if cfg.HIBP.Source == "dataset" && cfg.HIBP.Dataset == "" {
+		return nil, fmt.Errorf("hibp dataset source requires a dataset path")
+	}
+
+	if len(cfg.Fingerprint.Keys) == 0 {
+		return nil, fmt.Errorf("fingerprint keys are required")
+	}
+
 	if cfg.HTTP.Origin == "" {
 		httpPrefix := "http"
 		if cfg.HTTP.TLS.Key != "" {
@@ -80,3 +195,9 @@ func LoadConfig(cfgPath string) (*Config, error) {
 
 	return &cfg, nil
 }
+
+func setDefaultDuration(duration *time.Duration, defaultDuration time.Duration) {
+	if *duration == 0 {
+		*duration = defaultDuration
+	}
+}
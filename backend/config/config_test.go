@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// requiredConfig holds the settings LoadConfig refuses to start without
+const requiredConfig = `
+auth:
+  secret: secret
+fingerprint:
+  keys:
+    "2025_01": 0123456789abcdef0123456789abcdef
+`
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadConfigRecheckInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", config: requiredConfig, want: defaultHIBPRecheckInterval},
+		{name: "set", config: requiredConfig + "hibp:\n  recheck_interval: 30m\n", want: 30 * time.Minute},
+		{name: "negative", config: requiredConfig + "hibp:\n  recheck_interval: -1h\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.config))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.HIBP.RecheckInterval != tt.want {
+				t.Errorf("got recheck interval %s, want %s", cfg.HIBP.RecheckInterval, tt.want)
+			}
+		})
+	}
+}
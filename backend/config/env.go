@@ -0,0 +1,245 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envTag        = "env"
+	envFileSuffix = "_FILE"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overlays the environment variables named in the env tags on top of the config.
+// Every variable has a _FILE variant that reads the value from a file, e.g. for mounted secrets.
+// Map fields take a JSON object or comma separated key=value pairs and are merged with the existing map,
+// single keys can be set by appending the uppercased key, e.g. STORAGE_PROPERTIES_TOKEN.
+func applyEnv(cfg *Config) error {
+	return applyEnvToStruct(reflect.ValueOf(cfg).Elem())
+}
+
+func applyEnvToStruct(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		name := v.Type().Field(i).Tag.Get(envTag)
+
+		if name == "" {
+			if field.Kind() == reflect.Struct {
+				if err := applyEnvToStruct(field); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if field.Kind() == reflect.Map {
+			if err := applyEnvToMap(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, found, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// lookupEnv returns the value of the variable or the contents of the file named by its _FILE variant
+func lookupEnv(name string) (string, bool, error) {
+	value, found := os.LookupEnv(name)
+	path, fileFound := os.LookupEnv(name + envFileSuffix)
+
+	if found && fileFound {
+		return "", false, fmt.Errorf("both %s and %s%s are set", name, name, envFileSuffix)
+	}
+
+	if !fileFound {
+		return value, found, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s%s: %w", name, envFileSuffix, err)
+	}
+
+	// files written by editors or secret tooling usually end with a newline
+	return strings.TrimRight(string(contents), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Pointer:
+		// optional values, where the zero value differs from unset
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+func applyEnvToMap(field reflect.Value, name string) error {
+	entries := make(map[string]interface{})
+
+	value, found, err := lookupEnv(name)
+	if err != nil {
+		return err
+	}
+	if found {
+		if entries, err = parseMap(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	// variables for a single key take precedence, e.g. STORAGE_PROPERTIES_TOKEN_FILE
+	keys, err := mapKeysFromEnv(name)
+	if err != nil {
+		return err
+	}
+	for key, keyValue := range keys {
+		entries[key] = keyValue
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if field.IsNil() {
+		field.Set(reflect.MakeMap(field.Type()))
+	}
+
+	for key, entry := range entries {
+		if err := setMapEntry(field, key, entry); err != nil {
+			return fmt.Errorf("invalid value for %s key %s: %w", name, key, err)
+		}
+	}
+
+	return nil
+}
+
+// mapKeysFromEnv finds the variables setting a single key of a map, keys are lowercased
+func mapKeysFromEnv(name string) (map[string]interface{}, error) {
+	prefix := name + "_"
+	keys := make(map[string]interface{})
+
+	for _, env := range os.Environ() {
+		variable, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(variable, prefix) || variable == name+envFileSuffix {
+			continue
+		}
+
+		keyVariable := strings.TrimSuffix(variable, envFileSuffix)
+		key := strings.ToLower(strings.TrimPrefix(keyVariable, prefix))
+		if key == "" {
+			continue
+		}
+
+		value, _, err := lookupEnv(keyVariable)
+		if err != nil {
+			return nil, err
+		}
+		keys[key] = value
+	}
+
+	return keys, nil
+}
+
+// parseMap parses a JSON object or comma separated key=value pairs
+func parseMap(value string) (map[string]interface{}, error) {
+	entries := make(map[string]interface{})
+
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		key, entry, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		entries[strings.TrimSpace(key)] = strings.TrimSpace(entry)
+	}
+
+	return entries, nil
+}
+
+// setMapEntry stores a value in a map of strings or a map of interfaces.
+// Maps of strings only accept scalar values, which are formatted as they would be in YAML.
+func setMapEntry(field reflect.Value, key string, value interface{}) error {
+	switch field.Type().Elem().Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			field.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(v))
+		case float64, bool:
+			field.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(fmt.Sprint(v)))
+		default:
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+	case reflect.Interface:
+		field.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(&value).Elem())
+	default:
+		return fmt.Errorf("unsupported map type %s", field.Type())
+	}
+
+	return nil
+}
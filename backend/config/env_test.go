@@ -0,0 +1,196 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		// initial is the config as read from YAML
+		initial func(cfg *Config)
+		env     map[string]string
+		// files are written to disk and their paths set in the named variables
+		files   map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr bool
+	}{
+		{
+			name: "scalars",
+			env: map[string]string{
+				"LOG_LEVEL":              "debug",
+				"HTTP_PORT":              "9090",
+				"API_REQUIRE_SIGNATURES": "true",
+				"HIBP_RECHECK_INTERVAL":  "90m",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Log.Level != "debug" || cfg.HTTP.Port != 9090 || !cfg.API.RequireSignatures || cfg.HIBP.RecheckInterval != 90*time.Minute {
+					t.Errorf("got %+v %+v %+v %s", cfg.Log, cfg.HTTP.Port, cfg.API, cfg.HIBP.RecheckInterval)
+				}
+			},
+		},
+		{
+			name:    "environment overrides the file",
+			initial: func(cfg *Config) { cfg.Log.Level = "info"; cfg.Auth.Secret = "yaml" },
+			env:     map[string]string{"LOG_LEVEL": "warn"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Log.Level != "warn" || cfg.Auth.Secret != "yaml" {
+					t.Errorf("got level %q secret %q", cfg.Log.Level, cfg.Auth.Secret)
+				}
+			},
+		},
+		{
+			// zero disables retries, which must differ from unset
+			name: "optional zero",
+			env:  map[string]string{"HIBP_API_MAX_RETRIES": "0"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HIBP.API.MaxRetries == nil || *cfg.HIBP.API.MaxRetries != 0 {
+					t.Errorf("got max retries %v, want 0", cfg.HIBP.API.MaxRetries)
+				}
+			},
+		},
+		{
+			name: "unset optional",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HIBP.API.MaxRetries != nil {
+					t.Errorf("got max retries %d, want unset", *cfg.HIBP.API.MaxRetries)
+				}
+			},
+		},
+		{
+			name:  "file variant",
+			files: map[string]string{"AUTH_SECRET_FILE": "s3cret\r\n"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Auth.Secret != "s3cret" {
+					t.Errorf("got secret %q, want s3cret", cfg.Auth.Secret)
+				}
+			},
+		},
+		{
+			name:    "value and file variant",
+			env:     map[string]string{"AUTH_SECRET": "s3cret"},
+			files:   map[string]string{"AUTH_SECRET_FILE": "s3cret"},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"AUTH_SECRET_FILE": filepath.Join(t.TempDir(), "missing")},
+			wantErr: true,
+		},
+		{
+			name:    "map pairs merged",
+			initial: func(cfg *Config) { cfg.Storage.Properties = map[string]string{"path": "/data", "token": "yaml"} },
+			env:     map[string]string{"STORAGE_PROPERTIES": " token = env , mode=rw,"},
+			check: func(t *testing.T, cfg *Config) {
+				want := map[string]string{"path": "/data", "token": "env", "mode": "rw"}
+				if !reflect.DeepEqual(cfg.Storage.Properties, want) {
+					t.Errorf("got properties %v, want %v", cfg.Storage.Properties, want)
+				}
+			},
+		},
+		{
+			name: "map single keys",
+			env: map[string]string{
+				"STORAGE_PROPERTIES":       "token=env,mode=rw",
+				"STORAGE_PROPERTIES_TOKEN": "key",
+			},
+			files: map[string]string{"STORAGE_PROPERTIES_DSN_FILE": "postgres://db\n"},
+			check: func(t *testing.T, cfg *Config) {
+				want := map[string]string{"token": "key", "mode": "rw", "dsn": "postgres://db"}
+				if !reflect.DeepEqual(cfg.Storage.Properties, want) {
+					t.Errorf("got properties %v, want %v", cfg.Storage.Properties, want)
+				}
+			},
+		},
+		{
+			name:  "map file variant",
+			files: map[string]string{"FINGERPRINT_KEYS_FILE": `{"2025_01": "pepper", "2025_02": 2}`},
+			check: func(t *testing.T, cfg *Config) {
+				want := map[string]string{"2025_01": "pepper", "2025_02": "2"}
+				if !reflect.DeepEqual(cfg.Fingerprint.Keys, want) {
+					t.Errorf("got keys %v, want %v", cfg.Fingerprint.Keys, want)
+				}
+			},
+		},
+		{
+			name: "map of interfaces",
+			env:  map[string]string{"AUTH_PROPERTIES": `{"issuer": "idp", "scopes": ["openid"]}`},
+			check: func(t *testing.T, cfg *Config) {
+				want := map[string]interface{}{"issuer": "idp", "scopes": []interface{}{"openid"}}
+				if !reflect.DeepEqual(cfg.Auth.Properties, want) {
+					t.Errorf("got properties %v, want %v", cfg.Auth.Properties, want)
+				}
+			},
+		},
+		{
+			name:    "nested value in map of strings",
+			env:     map[string]string{"STORAGE_PROPERTIES": `{"token": {"nested": true}}`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pair",
+			env:     map[string]string{"STORAGE_PROPERTIES": "token"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			env:     map[string]string{"STORAGE_PROPERTIES": `{"token":`},
+			wantErr: true,
+		},
+		{
+			name:    "port out of range",
+			env:     map[string]string{"HTTP_PORT": "70000"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration",
+			env:     map[string]string{"HIBP_RECHECK_INTERVAL": "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid bool",
+			env:     map[string]string{"API_REQUIRE_SIGNATURES": "maybe"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			for name, contents := range tt.files {
+				path := filepath.Join(t.TempDir(), name)
+				if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+				t.Setenv(name, path)
+			}
+
+			cfg := Config{}
+			if tt.initial != nil {
+				tt.initial(&cfg)
+			}
+
+			err := applyEnv(&cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && tt.check != nil {
+				tt.check(t, &cfg)
+			}
+		})
+	}
+}
+
+func TestSetFieldUnsupported(t *testing.T) {
+	var values []string
+
+	if err := setField(reflect.ValueOf(&values).Elem(), "a"); err == nil {
+		t.Error("expected an error for an unsupported field type")
+	}
+}
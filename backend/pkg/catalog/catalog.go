@@ -0,0 +1,185 @@
+package catalog
+
+import (
+	_ "embed"
+	"fmt"
+	"github.com/hazcod/shade/pkg/domainname"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strings"
+)
+
+//go:embed catalog.yml
+var builtinCatalog []byte
+
+// App is a SaaS application and the domains it is served from
+type App struct {
+	Name     string   `yaml:"name"`
+	Vendor   string   `yaml:"vendor"`
+	Category string   `yaml:"category"`
+	SSO      bool     `yaml:"sso"`
+	Domains  []string `yaml:"domains"`
+}
+
+// Entry is a group of discovered domains belonging to the same app
+type Entry struct {
+	App
+	// Known is false for domains missing from the catalog, their App only carries the domain as name
+	Known bool
+	// Discovered are the domains the app was seen on, sorted
+	Discovered []string
+}
+
+// Key identifies the app for settings kept per app, such as reviews.
+// Known apps are identified by name, others by the registrable domain they were discovered on.
+func (e Entry) Key() string {
+	if e.Known || len(e.Discovered) == 0 {
+		return e.Name
+	}
+
+	return domainname.Normalize(e.Discovered[0]).Domain
+}
+
+type catalogFile struct {
+	Apps []App `yaml:"apps"`
+}
+
+// Catalog maps domains to the SaaS application they belong to
+type Catalog struct {
+	apps     []App
+	byDomain map[string]int
+}
+
+// Load reads the built-in catalog and applies the catalog file at overridePath, if set.
+// Apps of the override replace built-in apps with the same name, their domains take precedence.
+func Load(overridePath string) (*Catalog, error) {
+	var builtin catalogFile
+	if err := yaml.Unmarshal(builtinCatalog, &builtin); err != nil {
+		return nil, fmt.Errorf("failed to parse built-in catalog: %w", err)
+	}
+
+	apps := builtin.Apps
+
+	if overridePath != "" {
+		overrideBytes, err := os.ReadFile(overridePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog: %w", err)
+		}
+
+		var override catalogFile
+		if err := yaml.Unmarshal(overrideBytes, &override); err != nil {
+			return nil, fmt.Errorf("failed to parse catalog %s: %w", overridePath, err)
+		}
+
+		apps = merge(apps, override.Apps)
+	}
+
+	c := &Catalog{byDomain: make(map[string]int)}
+
+	for _, app := range apps {
+		if app.Name == "" {
+			return nil, fmt.Errorf("catalog app without a name")
+		}
+
+		c.apps = append(c.apps, app)
+
+		for _, domain := range app.Domains {
+			domain = strings.ToLower(domain)
+			// the first app listing a domain wins, override apps come first
+			if _, exists := c.byDomain[domain]; !exists {
+				c.byDomain[domain] = len(c.apps) - 1
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// merge puts the override apps in front of the built-in apps they do not replace
+func merge(builtin, override []App) []App {
+	replaced := make(map[string]struct{}, len(override))
+	for _, app := range override {
+		replaced[strings.ToLower(app.Name)] = struct{}{}
+	}
+
+	apps := append(make([]App, 0, len(builtin)+len(override)), override...)
+	for _, app := range builtin {
+		if _, ok := replaced[strings.ToLower(app.Name)]; !ok {
+			apps = append(apps, app)
+		}
+	}
+
+	return apps
+}
+
+// Len returns the number of apps in the catalog
+func (c *Catalog) Len() int {
+	return len(c.apps)
+}
+
+// Lookup returns the app a domain or host belongs to, matching the most specific catalog domain
+func (c *Catalog) Lookup(domain string) (App, bool) {
+	domain = strings.ToLower(domain)
+
+	for {
+		if i, ok := c.byDomain[domain]; ok {
+			return c.apps[i], true
+		}
+
+		_, parent, found := strings.Cut(domain, ".")
+		if !found || !strings.Contains(parent, ".") {
+			return App{}, false
+		}
+		domain = parent
+	}
+}
+
+// Key identifies the app a domain or host belongs to like Entry.Key does for discovered domains
+func (c *Catalog) Key(domain string) string {
+	if app, known := c.Lookup(domain); known {
+		return app.Name
+	}
+
+	return domainname.Normalize(domain).Domain
+}
+
+// Group groups discovered domains by app, sorted on app name.
+// Domains missing from the catalog each get an entry of their own.
+func (c *Catalog) Group(domains []string) []Entry {
+	type entryKey struct {
+		name  string
+		known bool
+	}
+
+	entries := make([]Entry, 0)
+	byName := make(map[entryKey]int)
+
+	for _, domain := range domains {
+		app, known := c.Lookup(domain)
+		if !known {
+			app = App{Name: domain, Domains: []string{domain}}
+		}
+
+		key := entryKey{app.Name, known}
+
+		i, exists := byName[key]
+		if !exists {
+			i = len(entries)
+			byName[key] = i
+			entries = append(entries, Entry{App: app, Known: known})
+		}
+
+		entries[i].Discovered = append(entries[i].Discovered, domain)
+	}
+
+	for _, entry := range entries {
+		sort.Strings(entry.Discovered)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+
+	return entries
+}
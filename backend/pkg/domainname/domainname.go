@@ -0,0 +1,69 @@
+package domainname
+
+import (
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// genericLabels are subdomains that name a part of an app rather than a customer tenant
+var genericLabels = map[string]struct{}{
+	"www": {}, "app": {}, "apps": {}, "web": {}, "my": {}, "portal": {}, "dashboard": {}, "console": {},
+	"login": {}, "signin": {}, "auth": {}, "sso": {}, "id": {}, "account": {}, "accounts": {}, "secure": {},
+	"admin": {}, "api": {}, "mail": {},
+}
+
+// Name is a host split along the Public Suffix List
+type Name struct {
+	// Host is the host as visited, e.g. acme.slack.com
+	Host string
+	// Domain is the registrable domain (eTLD+1), e.g. slack.com
+	Domain string
+	// Tenant is the customer specific subdomain if any, e.g. acme
+	Tenant string
+}
+
+// Normalize splits a host into its registrable domain and tenant. The host may also be an origin such as
+// https://acme.slack.com:443, as sent by the extension, of which only the hostname is kept.
+// Hosts without a registrable domain, such as IP addresses and localhost, are kept as they are.
+func Normalize(host string) Name {
+	host = strings.ToLower(strings.TrimSpace(host))
+
+	if strings.Contains(host, "://") {
+		if parsed, err := url.Parse(host); err == nil {
+			host = parsed.Host
+		}
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	host = strings.Trim(strings.TrimSuffix(host, "."), "[]")
+	name := Name{Host: host, Domain: host}
+
+	if net.ParseIP(host) != nil {
+		return name
+	}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return name
+	}
+	name.Domain = registrable
+
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(host, registrable), ".")
+	if subdomain == "" {
+		return name
+	}
+
+	for _, label := range strings.Split(subdomain, ".") {
+		if _, generic := genericLabels[label]; !generic {
+			name.Tenant = label
+			break
+		}
+	}
+
+	return name
+}
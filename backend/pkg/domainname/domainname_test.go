@@ -0,0 +1,85 @@
+package domainname
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want Name
+	}{
+		{
+			name: "registrable domain",
+			host: "slack.com",
+			want: Name{Host: "slack.com", Domain: "slack.com"},
+		},
+		{
+			name: "tenant subdomain",
+			host: "acme.slack.com",
+			want: Name{Host: "acme.slack.com", Domain: "slack.com", Tenant: "acme"},
+		},
+		{
+			name: "generic subdomain",
+			host: "app.slack.com",
+			want: Name{Host: "app.slack.com", Domain: "slack.com"},
+		},
+		{
+			name: "tenant below a generic subdomain",
+			host: "login.acme.okta.com",
+			want: Name{Host: "login.acme.okta.com", Domain: "okta.com", Tenant: "acme"},
+		},
+		{
+			name: "multi-label public suffix",
+			host: "www.example.co.uk",
+			want: Name{Host: "www.example.co.uk", Domain: "example.co.uk"},
+		},
+		{
+			name: "private public suffix",
+			host: "acme.github.io",
+			want: Name{Host: "acme.github.io", Domain: "acme.github.io"},
+		},
+		{
+			name: "origin with port",
+			host: "https://Acme.Slack.com:443",
+			want: Name{Host: "acme.slack.com", Domain: "slack.com", Tenant: "acme"},
+		},
+		{
+			name: "host with port",
+			host: "acme.slack.com:8443",
+			want: Name{Host: "acme.slack.com", Domain: "slack.com", Tenant: "acme"},
+		},
+		{
+			name: "trailing dot and whitespace",
+			host: " slack.com. ",
+			want: Name{Host: "slack.com", Domain: "slack.com"},
+		},
+		{
+			name: "IPv4 address",
+			host: "http://10.0.0.1:8080",
+			want: Name{Host: "10.0.0.1", Domain: "10.0.0.1"},
+		},
+		{
+			name: "IPv6 address",
+			host: "https://[::1]:8443",
+			want: Name{Host: "::1", Domain: "::1"},
+		},
+		{
+			name: "localhost",
+			host: "localhost",
+			want: Name{Host: "localhost", Domain: "localhost"},
+		},
+		{
+			name: "public suffix only",
+			host: "co.uk",
+			want: Name{Host: "co.uk", Domain: "co.uk"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.host); got != tt.want {
+				t.Errorf("Normalize(%q) = %+v, want %+v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
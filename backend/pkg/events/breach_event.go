@@ -0,0 +1,11 @@
+package events
+
+import "time"
+
+// BreachEvent is raised when a password that was clean shows up in a breach
+type BreachEvent struct {
+	Timestamp           time.Time
+	Hash                string
+	BreachCount         int
+	PreviousBreachCount int
+}
@@ -0,0 +1,22 @@
+package events
+
+import (
+	"github.com/hazcod/shade/pkg/models"
+	"time"
+)
+
+// EnforcementEvent records whether the extension was told to allow, warn about or block a login
+type EnforcementEvent struct {
+	Timestamp time.Time
+	User      string
+	Domain    string
+	Host      string
+	// App is the catalog name of the app, or the registrable domain for apps missing from the catalog
+	App      string
+	DeviceID string
+	Action   models.EnforcementAction
+	// Reason explains which rule matched, for administrators
+	Reason string
+	// Message is what the user was shown
+	Message string
+}
@@ -9,11 +9,19 @@ const (
 type LoginEvent struct {
 	Timestamp time.Time
 	User      string
-	Domain    string
-	Hash      string
-	DeviceID  string
-	IP        string
-	Hostname  string
-	HasMFA    bool
-	MFAType   string
+	// Domain is the registrable domain (eTLD+1) of Host
+	Domain string
+	// Host is the host the login happened on
+	Host string
+	// Tenant is the customer specific subdomain of Host, empty for shared hosts
+	Tenant string
+	// Hash is the keyed fingerprint of the password hash sent by the extension
+	Hash string
+	// BreachHash is the encrypted SHA-1 used for HIBP lookups, empty for legacy extensions
+	BreachHash string
+	DeviceID   string
+	IP         string
+	Hostname   string
+	HasMFA     bool
+	MFAType    string
 }
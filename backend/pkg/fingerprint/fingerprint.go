@@ -0,0 +1,217 @@
+package fingerprint
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// separates the key ID from the value
+	keySeparator = ":"
+
+	minSecretLength = 32
+
+	// derives the breach hash encryption key from a pepper, so the pepper itself is never used twice
+	encryptionKeyLabel = "shade breach hash encryption"
+)
+
+// Keyring turns password hashes sent by the extension into values that are useless without the server-side peppers.
+//
+// Fingerprints are HMAC-SHA256 chains over the peppers in key ID order: the oldest key is applied first and
+// every newer key wraps the previous result. This allows rotating to a new pepper by wrapping the stored
+// fingerprints, without needing the original hashes. Stored fingerprints are prefixed with the newest key
+// ID applied, so values from before a rotation can be recognised.
+//
+// Breach hashes have to be looked up again later, so they are encrypted with the newest pepper instead.
+type Keyring struct {
+	ids     []string
+	secrets map[string][]byte
+}
+
+// NewKeyring creates a keyring from peppers by key ID. Key IDs are applied in lexical order,
+// so they should sort in the order the keys were introduced, e.g. 2025_01.
+func NewKeyring(keys map[string]string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one fingerprint key is required")
+	}
+
+	k := &Keyring{secrets: make(map[string][]byte)}
+
+	for id, secret := range keys {
+		if id == "" || strings.Contains(id, keySeparator) {
+			return nil, fmt.Errorf("invalid fingerprint key ID %q", id)
+		}
+
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("fingerprint key %s must be at least %d characters", id, minSecretLength)
+		}
+
+		k.ids = append(k.ids, id)
+		k.secrets[id] = []byte(secret)
+	}
+
+	sort.Strings(k.ids)
+
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the newest key, which new values are stored with
+func (k *Keyring) ActiveKeyID() string {
+	return k.ids[len(k.ids)-1]
+}
+
+// Fingerprint returns the keyed fingerprint of a password hash as sent by the extension
+func (k *Keyring) Fingerprint(hash string) string {
+	return k.wrap(strings.ToLower(hash), 0)
+}
+
+// wrap applies the keys starting at index start to value
+func (k *Keyring) wrap(value string, start int) string {
+	for _, id := range k.ids[start:] {
+		mac := hmac.New(sha256.New, k.secrets[id])
+		mac.Write([]byte(value))
+		value = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return k.ActiveKeyID() + keySeparator + value
+}
+
+// RekeyFingerprint brings a stored fingerprint up to date with the active key.
+// Values without a key ID are unkeyed hashes stored by older versions and are fingerprinted from scratch.
+func (k *Keyring) RekeyFingerprint(fingerprint string) (string, error) {
+	id, value, keyed := strings.Cut(fingerprint, keySeparator)
+	if !keyed {
+		return k.Fingerprint(fingerprint), nil
+	}
+
+	index := sort.SearchStrings(k.ids, id)
+	if index == len(k.ids) || k.ids[index] != id {
+		return "", fmt.Errorf("unknown fingerprint key %s", id)
+	}
+
+	if index == len(k.ids)-1 {
+		return fingerprint, nil
+	}
+
+	return k.wrap(value, index+1), nil
+}
+
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	secret, ok := k.secrets[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown fingerprint key %s", id)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encryptionKeyLabel))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a value with the active key
+func (k *Keyring) seal(value string) (string, error) {
+	id := k.ActiveKeyID()
+
+	aead, err := k.aead(id)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(id))
+
+	return id + keySeparator + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value encrypted by seal with the key ID it is prefixed with
+func (k *Keyring) open(id, encoded string) (string, error) {
+	aead, err := k.aead(id)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// EncryptBreachHash encrypts a breach hash with the active key
+func (k *Keyring) EncryptBreachHash(breachHash string) (string, error) {
+	return k.seal(breachHash)
+}
+
+// DecryptBreachHash decrypts a stored breach hash.
+// Values without a key ID were stored unencrypted by older versions and are returned as is.
+func (k *Keyring) DecryptBreachHash(stored string) (string, error) {
+	id, encoded, keyed := strings.Cut(stored, keySeparator)
+	if !keyed {
+		return stored, nil
+	}
+
+	breachHash, err := k.open(id, encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt breach hash: %w", err)
+	}
+
+	return breachHash, nil
+}
+
+// EncryptSigningKey encrypts the request signing key of a device with the active key
+func (k *Keyring) EncryptSigningKey(signingKey string) (string, error) {
+	return k.seal(signingKey)
+}
+
+// DecryptSigningKey decrypts a stored request signing key
+func (k *Keyring) DecryptSigningKey(stored string) (string, error) {
+	id, encoded, keyed := strings.Cut(stored, keySeparator)
+	if !keyed {
+		return "", errors.New("signing key is not encrypted")
+	}
+
+	signingKey, err := k.open(id, encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt signing key: %w", err)
+	}
+
+	return signingKey, nil
+}
+
+// RekeyBreachHash re-encrypts a stored breach hash with the active key if needed
+func (k *Keyring) RekeyBreachHash(stored string) (string, error) {
+	if id, _, keyed := strings.Cut(stored, keySeparator); keyed && id == k.ActiveKeyID() {
+		return stored, nil
+	}
+
+	breachHash, err := k.DecryptBreachHash(stored)
+	if err != nil {
+		return "", err
+	}
+
+	return k.EncryptBreachHash(breachHash)
+}
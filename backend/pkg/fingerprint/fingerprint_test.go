@@ -0,0 +1,265 @@
+package fingerprint
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+const (
+	oldSecret = "0123456789abcdef0123456789abcdef"
+	newSecret = "fedcba9876543210fedcba9876543210"
+)
+
+func newTestKeyring(t *testing.T, keys map[string]string) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return keyring
+}
+
+// rotated returns a keyring before and after rotating to a new key
+func rotated(t *testing.T) (*Keyring, *Keyring) {
+	t.Helper()
+
+	return newTestKeyring(t, map[string]string{"2025_01": oldSecret}),
+		newTestKeyring(t, map[string]string{"2025_01": oldSecret, "2025_06": newSecret})
+}
+
+func hmacHex(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		wantErr bool
+	}{
+		{name: "valid", keys: map[string]string{"2025_01": oldSecret}},
+		{name: "no keys", keys: map[string]string{}, wantErr: true},
+		{name: "empty key ID", keys: map[string]string{"": oldSecret}, wantErr: true},
+		{name: "key ID with separator", keys: map[string]string{"2025:01": oldSecret}, wantErr: true},
+		{name: "short secret", keys: map[string]string{"2025_01": "too short"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	old, current := rotated(t)
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		hash    string
+		want    string
+	}{
+		{
+			name:    "single key",
+			keyring: old,
+			hash:    "abc123",
+			want:    "2025_01:" + hmacHex(oldSecret, "abc123"),
+		},
+		{
+			name:    "hash is case insensitive",
+			keyring: old,
+			hash:    "ABC123",
+			want:    "2025_01:" + hmacHex(oldSecret, "abc123"),
+		},
+		{
+			name:    "newer keys wrap older ones",
+			keyring: current,
+			hash:    "abc123",
+			want:    "2025_06:" + hmacHex(newSecret, hmacHex(oldSecret, "abc123")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.keyring.Fingerprint(tt.hash); got != tt.want {
+				t.Errorf("got fingerprint %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRekeyFingerprint(t *testing.T) {
+	old, current := rotated(t)
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "fingerprint of the previous key",
+			fingerprint: old.Fingerprint("abc123"),
+			want:        current.Fingerprint("abc123"),
+		},
+		{
+			name:        "fingerprint of the active key",
+			fingerprint: current.Fingerprint("abc123"),
+			want:        current.Fingerprint("abc123"),
+		},
+		{
+			name:        "unkeyed hash of older versions",
+			fingerprint: "abc123",
+			want:        current.Fingerprint("abc123"),
+		},
+		{
+			name:        "unknown key",
+			fingerprint: "2024_01:" + hmacHex(oldSecret, "abc123"),
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := current.RekeyFingerprint(tt.fingerprint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got fingerprint %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachHash(t *testing.T) {
+	old, current := rotated(t)
+
+	sealedOld, err := old.EncryptBreachHash("ABCDE12345")
+	if err != nil {
+		t.Fatalf("failed to encrypt breach hash: %v", err)
+	}
+
+	sealed, err := current.EncryptBreachHash("ABCDE12345")
+	if err != nil {
+		t.Fatalf("failed to encrypt breach hash: %v", err)
+	}
+
+	if again, _ := current.EncryptBreachHash("ABCDE12345"); again == sealed {
+		t.Error("encrypting twice returned the same value, nonces are reused")
+	}
+
+	id, encoded, _ := strings.Cut(sealed, keySeparator)
+
+	tampered, _ := base64.RawStdEncoding.DecodeString(encoded)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{name: "active key", stored: sealed, want: "ABCDE12345"},
+		{name: "previous key", stored: sealedOld, want: "ABCDE12345"},
+		{name: "unencrypted value of older versions", stored: "ABCDE12345", want: "ABCDE12345"},
+		{name: "unknown key", stored: "2024_01:" + encoded, wantErr: true},
+		// the key ID is authenticated, so a value cannot be moved to another key
+		{name: "other key ID", stored: "2025_01:" + encoded, wantErr: true},
+		{name: "tampered", stored: id + keySeparator + base64.RawStdEncoding.EncodeToString(tampered), wantErr: true},
+		{name: "truncated", stored: id + keySeparator + encoded[:8], wantErr: true},
+		{name: "malformed", stored: id + keySeparator + "!!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := current.DecryptBreachHash(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got breach hash %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRekeyBreachHash(t *testing.T) {
+	old, current := rotated(t)
+
+	sealedOld, _ := old.EncryptBreachHash("ABCDE12345")
+	sealed, _ := current.EncryptBreachHash("ABCDE12345")
+
+	tests := []struct {
+		name      string
+		stored    string
+		unchanged bool
+	}{
+		{name: "previous key", stored: sealedOld},
+		{name: "unencrypted value of older versions", stored: "ABCDE12345"},
+		{name: "active key", stored: sealed, unchanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rekeyed, err := current.RekeyBreachHash(tt.stored)
+			if err != nil {
+				t.Fatalf("failed to rekey breach hash: %v", err)
+			}
+
+			if (rekeyed == tt.stored) != tt.unchanged {
+				t.Errorf("rekeyed %s to %s", tt.stored, rekeyed)
+			}
+			if !strings.HasPrefix(rekeyed, current.ActiveKeyID()+keySeparator) {
+				t.Errorf("rekeyed value %s is not encrypted with the active key", rekeyed)
+			}
+			if breachHash, err := current.DecryptBreachHash(rekeyed); err != nil || breachHash != "ABCDE12345" {
+				t.Errorf("rekeyed value decrypts to %s: %v", breachHash, err)
+			}
+		})
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	_, current := rotated(t)
+
+	sealed, err := current.EncryptSigningKey("signing-key")
+	if err != nil {
+		t.Fatalf("failed to encrypt signing key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		stored  string
+		want    string
+		wantErr bool
+	}{
+		{name: "encrypted", stored: sealed, want: "signing-key"},
+		// unlike breach hashes, signing keys were never stored unencrypted
+		{name: "unencrypted", stored: "signing-key", wantErr: true},
+		{name: "unknown key", stored: "2024_01:" + strings.SplitN(sealed, keySeparator, 2)[1], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := current.DecryptSigningKey(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got signing key %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// DomainSummary aggregates the logins on a domain or host
+type DomainSummary struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Logins    int
+	Users     int
+	Devices   int
+	// UsersWithMFA logged in using MFA at least once
+	UsersWithMFA int
+	// ReusingUsers use a current password of the domain on another domain as well
+	ReusingUsers int
+	// BreachedUsers have a current password on the domain that was found in a breach
+	BreachedUsers int
+}
+
+// MFACoverage returns the percentage of users that logged in using MFA
+func (s DomainSummary) MFACoverage() int {
+	if s.Users == 0 {
+		return 0
+	}
+
+	return s.UsersWithMFA * 100 / s.Users
+}
+
+// DomainUser is a user that logged in on a domain or host.
+// Their current passwords are the last ones submitted for every host of the domain.
+type DomainUser struct {
+	Username  string
+	Logins    int
+	Devices   int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	HasMFA    bool
+	// ReusesPassword is true when a current password is used on another domain as well
+	ReusesPassword bool
+	// BreachCount is the highest breach count of the current passwords, zero when none is known to be breached
+	BreachCount int
+}
+
+// DomainDevice is a device that was used to log in on a domain or host
+type DomainDevice struct {
+	DeviceID string
+	Username string
+	Hostname string
+	IP       string
+	Logins   int
+	LastSeen time.Time
+}
+
+// TimelineDay counts the logins on a domain or host during a day, in UTC
+type TimelineDay struct {
+	Day    time.Time
+	Logins int
+	Users  int
+}
@@ -0,0 +1,26 @@
+package models
+
+// EnforcementAction is what the extension does with a login, as decided by the backend
+type EnforcementAction string
+
+const (
+	// EnforcementAllow lets the login through without a message
+	EnforcementAllow EnforcementAction = "allow"
+	// EnforcementWarn shows the user a message but lets the login through
+	EnforcementWarn EnforcementAction = "warn"
+	// EnforcementBlock navigates away from the app after showing the user a message
+	EnforcementBlock EnforcementAction = "block"
+)
+
+// EnforcementActions lists the actions that are recorded, every decision is kept so the log shows why a login was let through
+var EnforcementActions = []EnforcementAction{EnforcementAllow, EnforcementWarn, EnforcementBlock}
+
+// ParseEnforcementAction parses a recorded action from user input, unknown values return false
+func ParseEnforcementAction(action string) (EnforcementAction, bool) {
+	switch EnforcementAction(action) {
+	case EnforcementAllow, EnforcementWarn, EnforcementBlock:
+		return EnforcementAction(action), true
+	}
+
+	return "", false
+}
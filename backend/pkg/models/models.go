@@ -1,11 +1,26 @@
 package models
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrDeviceRevoked is returned when a revoked device tries to enroll again
+var ErrDeviceRevoked = errors.New("device has been revoked")
+
+// ErrDeviceEnrolled is returned when a device that holds a credential tries to enroll again
+var ErrDeviceEnrolled = errors.New("device is already enrolled")
+
 type EnrolledUser struct {
 	Username string
 	ID       string
 	Hostname string
 	IP       string
 	LastSeen string
+	// Enrolled is true when the device holds a device credential
+	Enrolled bool
+	// Revoked is true when an admin revoked the device
+	Revoked bool
 }
 
 type DashboardStats struct {
@@ -14,6 +29,51 @@ type DashboardStats struct {
 	DuplicatePasswords   int
 	CompromisedPasswords int
 	UsersWithoutMFA      int
+	BlockedLogins        int
+}
+
+// HIBPResult is the last known breach count of a password hash
+type HIBPResult struct {
+	BreachCount int
+	CheckedAt   time.Time
+}
+
+// HIBPRange is a cached range response of SUFFIX:COUNT lines for a 5 character hash prefix
+type HIBPRange struct {
+	Body      string
+	FetchedAt time.Time
+}
+
+// HIBPVerdict is a breached password waiting to be reported to the device that submitted it
+type HIBPVerdict struct {
+	DeviceID    string
+	Domain      string
+	Username    string
+	BreachCount int
+	CheckedAt   time.Time
+}
+
+// CompromisedAccount is an account whose current password was found in a breach
+type CompromisedAccount struct {
+	Domain      string
+	Username    string
+	BreachCount int
+	CheckedAt   time.Time
+}
+
+// PasswordHash links the fingerprint of a password to the SHA-1 used for breach lookups
+type PasswordHash struct {
+	Hash string
+	// BreachHash is encrypted with the fingerprint key
+	BreachHash string
+}
+
+// StalePasswordHash is a password due for a HIBP check, with the result of its last check
+type StalePasswordHash struct {
+	PasswordHash
+	// Checked is false for passwords that were never checked, BreachCount is the last known count otherwise
+	Checked     bool
+	BreachCount int
 }
 
 type DuplicatePasswordEntry struct {
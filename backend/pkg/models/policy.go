@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Policy is the behavior the backend distributes to every browser extension
+type Policy struct {
+	// Version is assigned by the storage driver, every stored policy gets the next one
+	Version int
+	// Disabled stops every extension from capturing logins
+	Disabled bool
+	// UsernameFilters restrict captured logins to usernames containing one of them
+	UsernameFilters []string
+	// IgnoredDomains are never reported, including their subdomains
+	IgnoredDomains []string
+	// ProhibitedDomains are warned about on login, including their subdomains
+	ProhibitedDomains []string
+	// BlockedDomains are blocked on login, including their subdomains
+	BlockedDomains []string
+	Messages       PolicyMessages
+	UpdatedBy      string
+	UpdatedAt      time.Time
+}
+
+// PolicyMessages override the warnings the extension shows, empty messages keep the built-in text
+type PolicyMessages struct {
+	Prohibited string
+	Blocked    string
+	Breach     string
+	Reuse      string
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size used when a query does not specify one
+	DefaultLimit = 50
+	// MaxLimit is the largest page size a query may request
+	MaxLimit = 500
+)
+
+// Sort fields understood by the storage drivers
+const (
+	SortDomain   = "domain"
+	SortUsername = "username"
+	SortHostname = "hostname"
+	SortLastSeen = "last_seen"
+)
+
+// DomainLevel selects whether domain queries group on the registrable domain or the exact host
+type DomainLevel string
+
+const (
+	// DomainLevelRegistrable groups hosts on their registrable domain, e.g. slack.com
+	DomainLevelRegistrable DomainLevel = ""
+	// DomainLevelHost keeps every host apart, e.g. acme.slack.com
+	DomainLevelHost DomainLevel = "host"
+)
+
+// ParseDomainLevel parses a level from user input, unknown values fall back to DomainLevelRegistrable
+func ParseDomainLevel(level string) DomainLevel {
+	if DomainLevel(level) == DomainLevelHost {
+		return DomainLevelHost
+	}
+
+	return DomainLevelRegistrable
+}
+
+// Column returns the login_events column holding the domain at this level
+func (l DomainLevel) Column() string {
+	if l == DomainLevelHost {
+		return "host"
+	}
+
+	return "domain"
+}
+
+// Query describes filtering, sorting and paging for list queries on the storage driver
+type Query struct {
+	// Search restricts results to entries containing this text, case-insensitive
+	Search string
+	// Sort is the field to sort on, each query falls back to its natural key when empty
+	Sort string
+	// Descending reverses the sort order
+	Descending bool
+	// Limit is the maximum number of items returned, DefaultLimit when zero
+	Limit int
+	// Cursor is the NextCursor of a previous page
+	Cursor string
+	// Level is the domain level used by queries returning domains
+	Level DomainLevel
+}
+
+// Page is a single page of results
+type Page[T any] struct {
+	Items []T
+	// NextCursor is empty when there are no more results
+	NextCursor string
+}
+
+// PageLimit returns the effective page size of the query
+func (q Query) PageLimit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+
+	return q.Limit
+}
+
+// After decodes the cursor into the sort key of the last item of the previous page, nil without a cursor
+func (q Query) After() ([]string, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var key []string
+	if err := json.Unmarshal(decoded, &key); err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid cursor: %s", q.Cursor)
+	}
+
+	return key, nil
+}
+
+// SortDirection returns the SQL keyword for the sort order
+func (q Query) SortDirection() string {
+	if q.Descending {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+// encodeCursor returns the cursor of the page following the item with this sort key
+func encodeCursor(key []string) string {
+	encoded, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// NewPage builds a page from the items fetched after the cursor of the query.
+// Drivers fetch one item more than the page limit so we know whether another page follows,
+// key returns the sort key of the item at index i, ending with a value unique to the item.
+func NewPage[T any](items []T, limit int, key func(i int) []string) Page[T] {
+	page := Page[T]{Items: items}
+
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(key(limit - 1))
+	}
+
+	if page.Items == nil {
+		page.Items = make([]T, 0)
+	}
+
+	return page
+}
+
+// compareKeys compares two sort keys value by value
+func compareKeys(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// Paginate sorts items on their key, in the order of the query, and returns the page after its cursor.
+// Keys compare as text, so their values must be formatted to sort alike, e.g. timestamps of a fixed width.
+func Paginate[T any](items []T, q Query, key func(i int) []string) (Page[T], error) {
+	after, err := q.After()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	keys := make([][]string, len(items))
+	indexes := make([]int, len(items))
+	for i := range items {
+		keys[i] = key(i)
+		indexes[i] = i
+	}
+
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+
+	sort.Slice(indexes, func(i, j int) bool {
+		return compareKeys(keys[indexes[i]], keys[indexes[j]])*direction < 0
+	})
+
+	// the items after the cursor, and one more to know whether another page follows
+	page := make([]int, 0, q.PageLimit()+1)
+	for _, i := range indexes {
+		if after != nil && compareKeys(keys[i], after)*direction <= 0 {
+			continue
+		}
+
+		if page = append(page, i); len(page) > q.PageLimit() {
+			break
+		}
+	}
+
+	pageItems := make([]T, len(page))
+	for i, index := range page {
+		pageItems[i] = items[index]
+	}
+
+	return NewPage(pageItems, q.PageLimit(), func(i int) []string { return keys[page[i]] }), nil
+}
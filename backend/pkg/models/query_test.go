@@ -0,0 +1,188 @@
+package models
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+)
+
+type item struct {
+	name string
+	id   string
+}
+
+var items = []item{
+	{"carol", "3"},
+	{"alice", "1"},
+	{"bob", "4"},
+	{"alice", "2"},
+	{"dave", "5"},
+}
+
+func itemKey(i int) []string {
+	return []string{items[i].name, items[i].id}
+}
+
+// walk returns the ids of every page of the query, following the cursors
+func walk(t *testing.T, query Query) ([]string, int) {
+	t.Helper()
+
+	ids := make([]string, 0)
+	for pages := 1; ; pages++ {
+		if pages > len(items)+1 {
+			t.Fatal("paging does not end")
+		}
+
+		page, err := Paginate(items, query, itemKey)
+		if err != nil {
+			t.Fatalf("failed to paginate: %v", err)
+		}
+
+		if len(page.Items) > query.PageLimit() {
+			t.Fatalf("page holds %d items, limit is %d", len(page.Items), query.PageLimit())
+		}
+
+		for _, item := range page.Items {
+			ids = append(ids, item.id)
+		}
+
+		if page.NextCursor == "" {
+			return ids, pages
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     Query
+		want      []string
+		wantPages int
+	}{
+		{
+			name:      "default limit",
+			query:     Query{},
+			want:      []string{"1", "2", "4", "3", "5"},
+			wantPages: 1,
+		},
+		{
+			name:      "single items",
+			query:     Query{Limit: 1},
+			want:      []string{"1", "2", "4", "3", "5"},
+			wantPages: 5,
+		},
+		{
+			// the page ends between the two items of alice, which only the id tells apart
+			name:      "page ends on a tie",
+			query:     Query{Limit: 1, Descending: true},
+			want:      []string{"5", "3", "4", "2", "1"},
+			wantPages: 5,
+		},
+		{
+			name:      "last page is full",
+			query:     Query{Limit: 5},
+			want:      []string{"1", "2", "4", "3", "5"},
+			wantPages: 1,
+		},
+		{
+			name:      "partial last page",
+			query:     Query{Limit: 2, Descending: true},
+			want:      []string{"5", "3", "4", "2", "1"},
+			wantPages: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, pages := walk(t, tt.query)
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("got ids %v, want %v", ids, tt.want)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("got %d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestPaginateCursor(t *testing.T) {
+	// a cursor is a position rather than an item, so it stays valid when its item is removed
+	page, err := Paginate(items, Query{Cursor: encodeCursor([]string{"alice", "9"})}, itemKey)
+	if err != nil {
+		t.Fatalf("failed to paginate: %v", err)
+	}
+	if len(page.Items) != 3 || page.Items[0].name != "bob" {
+		t.Errorf("got page %v, want the items after alice", page.Items)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("alice"))},
+		{"empty key", encodeCursor([]string{})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Paginate(items, Query{Cursor: tt.cursor}, itemKey); err == nil {
+				t.Error("invalid cursor accepted")
+			}
+		})
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []string
+		limit      int
+		wantItems  int
+		wantCursor bool
+	}{
+		{name: "no items", items: nil, limit: 2},
+		{name: "fewer than the limit", items: []string{"a"}, limit: 2, wantItems: 1},
+		{name: "exactly the limit", items: []string{"a", "b"}, limit: 2, wantItems: 2},
+		{name: "one more than the limit", items: []string{"a", "b", "c"}, limit: 2, wantItems: 2, wantCursor: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := NewPage(tt.items, tt.limit, func(i int) []string { return []string{tt.items[i]} })
+
+			if page.Items == nil || len(page.Items) != tt.wantItems {
+				t.Errorf("got items %v, want %d", page.Items, tt.wantItems)
+			}
+			if (page.NextCursor != "") != tt.wantCursor {
+				t.Fatalf("got cursor %q, want cursor %v", page.NextCursor, tt.wantCursor)
+			}
+
+			if tt.wantCursor {
+				after, err := Query{Cursor: page.NextCursor}.After()
+				if err != nil || !slices.Equal(after, []string{tt.items[tt.limit-1]}) {
+					t.Errorf("cursor decodes to %v, want the key of the last item: %v", after, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, DefaultLimit},
+		{-1, DefaultLimit},
+		{10, 10},
+		{MaxLimit + 1, MaxLimit},
+	}
+
+	for _, tt := range tests {
+		if got := (Query{Limit: tt.limit}).PageLimit(); got != tt.want {
+			t.Errorf("PageLimit() with limit %d = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// AppStatus is the outcome of reviewing a discovered SaaS application
+type AppStatus string
+
+const (
+	// AppStatusNew is the status of apps nobody reviewed yet
+	AppStatusNew         AppStatus = "new"
+	AppStatusUnderReview AppStatus = "under_review"
+	AppStatusSanctioned  AppStatus = "sanctioned"
+	AppStatusTolerated   AppStatus = "tolerated"
+	AppStatusProhibited  AppStatus = "prohibited"
+)
+
+// AppStatuses lists every status in workflow order
+var AppStatuses = []AppStatus{AppStatusNew, AppStatusUnderReview, AppStatusSanctioned, AppStatusTolerated, AppStatusProhibited}
+
+var appStatusLabels = map[AppStatus]string{
+	AppStatusNew:         "New",
+	AppStatusUnderReview: "Under review",
+	AppStatusSanctioned:  "Sanctioned",
+	AppStatusTolerated:   "Tolerated",
+	AppStatusProhibited:  "Prohibited",
+}
+
+// ParseAppStatus validates a status submitted by a user
+func ParseAppStatus(status string) (AppStatus, error) {
+	if _, ok := appStatusLabels[AppStatus(status)]; !ok {
+		return "", fmt.Errorf("unknown app status: %s", status)
+	}
+
+	return AppStatus(status), nil
+}
+
+// Label returns the human readable name of the status
+func (s AppStatus) Label() string {
+	if label, ok := appStatusLabels[s]; ok {
+		return label
+	}
+
+	return string(s)
+}
+
+// AppReview is the approval decision for a discovered app.
+// Apps are identified by their catalog name, or by their registrable domain when missing from the catalog.
+type AppReview struct {
+	App           string
+	Status        AppStatus
+	Owner         string
+	Justification string
+	// ReviewDate is when the decision should be revisited, zero when not planned
+	ReviewDate time.Time
+	UpdatedBy  string
+	UpdatedAt  time.Time
+}
+
+// ReviewDue returns true if the planned review date has passed
+func (r AppReview) ReviewDue(now time.Time) bool {
+	return !r.ReviewDate.IsZero() && !now.Before(r.ReviewDate)
+}
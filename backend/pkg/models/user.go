@@ -0,0 +1,112 @@
+package models
+
+import "time"
+
+// UserEventType tells the kind of event in the history of a user
+type UserEventType string
+
+const (
+	UserEventLogin UserEventType = "login"
+	// UserEventWarn and UserEventBlock are enforcement decisions, named after their EnforcementAction
+	UserEventWarn  UserEventType = "warn"
+	UserEventBlock UserEventType = "block"
+	// UserEventBreach is raised when a password of the user shows up in a breach
+	UserEventBreach UserEventType = "breach"
+)
+
+// Weights of the risk factors of a user, the score is capped at maxRiskScore
+const (
+	riskPointsBreached   = 30
+	riskPointsProhibited = 20
+	riskPointsReused     = 10
+	riskPointsWithoutMFA = 5
+	maxRiskScore         = 100
+)
+
+// UserAccount aggregates the logins of a user on a registrable domain.
+// Their current passwords are the last ones submitted for every host of the domain.
+type UserAccount struct {
+	Domain    string
+	Logins    int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	HasMFA    bool
+	// BreachCount is the highest breach count of the current passwords, zero when none is known to be breached
+	BreachCount int
+}
+
+// UserDevice is a device and IP address a user logged in from
+type UserDevice struct {
+	DeviceID  string
+	Hostname  string
+	IP        string
+	Logins    int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// UserEvent is an entry in the history of a user
+type UserEvent struct {
+	Timestamp time.Time
+	Type      UserEventType
+	Domain    string
+	Host      string
+	DeviceID  string
+	// HasMFA is set for logins
+	HasMFA bool
+	// Detail is the MFA type of logins and the reason of enforcement decisions
+	Detail string
+	// BreachCount is set for breaches
+	BreachCount int
+}
+
+// RiskFactor is a finding adding to the risk score of a user
+type RiskFactor struct {
+	Label  string
+	Count  int
+	Points int
+}
+
+// UserRisk scores how exposed the accounts of a user are, from 0 to 100
+type UserRisk struct {
+	Score   int
+	Factors []RiskFactor
+}
+
+// NewUserRisk scores a user on the number of accounts with a breached password, prohibited apps in use,
+// accounts sharing a password and accounts never used with MFA
+func NewUserRisk(breached, prohibited, reused, withoutMFA int) UserRisk {
+	var risk UserRisk
+
+	for _, factor := range []RiskFactor{
+		{Label: "Breached passwords", Count: breached, Points: breached * riskPointsBreached},
+		{Label: "Prohibited apps", Count: prohibited, Points: prohibited * riskPointsProhibited},
+		{Label: "Shared passwords", Count: reused, Points: reused * riskPointsReused},
+		{Label: "Apps without MFA", Count: withoutMFA, Points: withoutMFA * riskPointsWithoutMFA},
+	} {
+		if factor.Count == 0 {
+			continue
+		}
+
+		risk.Score += factor.Points
+		risk.Factors = append(risk.Factors, factor)
+	}
+
+	risk.Score = min(risk.Score, maxRiskScore)
+
+	return risk
+}
+
+// Level returns the risk level of the score: low, medium, high or critical
+func (r UserRisk) Level() string {
+	switch {
+	case r.Score >= 75:
+		return "critical"
+	case r.Score >= 50:
+		return "high"
+	case r.Score >= 25:
+		return "medium"
+	default:
+		return "low"
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewUserRisk(t *testing.T) {
+	tests := []struct {
+		name                                     string
+		breached, prohibited, reused, withoutMFA int
+		wantScore                                int
+		wantLevel                                string
+		wantFactors                              []string
+	}{
+		{name: "no findings", wantScore: 0, wantLevel: "low"},
+		{name: "without MFA", withoutMFA: 2, wantScore: 10, wantLevel: "low", wantFactors: []string{"Apps without MFA"}},
+		{name: "shared", reused: 3, wantScore: 30, wantLevel: "medium", wantFactors: []string{"Shared passwords"}},
+		{name: "breached", breached: 1, prohibited: 1, wantScore: 50, wantLevel: "high", wantFactors: []string{"Breached passwords", "Prohibited apps"}},
+		{
+			name:     "capped",
+			breached: 3, prohibited: 1, reused: 2, withoutMFA: 1,
+			wantScore:   100,
+			wantLevel:   "critical",
+			wantFactors: []string{"Breached passwords", "Prohibited apps", "Shared passwords", "Apps without MFA"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			risk := NewUserRisk(tt.breached, tt.prohibited, tt.reused, tt.withoutMFA)
+
+			if risk.Score != tt.wantScore || risk.Level() != tt.wantLevel {
+				t.Errorf("got score %d level %s, want %d %s", risk.Score, risk.Level(), tt.wantScore, tt.wantLevel)
+			}
+
+			var factors []string
+			for _, factor := range risk.Factors {
+				factors = append(factors, factor.Label)
+			}
+			if !reflect.DeepEqual(factors, tt.wantFactors) {
+				t.Errorf("got factors %q, want %q", factors, tt.wantFactors)
+			}
+		})
+	}
+}
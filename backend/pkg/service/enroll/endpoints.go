@@ -0,0 +1,134 @@
+package enroll
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/asaskevich/govalidator"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const (
+	credentialBytes = 32
+)
+
+type enrollData struct {
+	DeviceID string `json:"device_id" valid:"required"`
+}
+
+type enrollResponse struct {
+	DeviceID string `json:"device_id"`
+	Token    string `json:"token"`
+	// SigningKey signs requests and responses, it is only ever sent in this response
+	SigningKey string `json:"signing_key"`
+}
+
+// generateCredential returns a new random device credential or signing key
+func generateCredential() (string, error) {
+	credential := make([]byte, credentialBytes)
+	if _, err := rand.Read(credential); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(credential), nil
+}
+
+// HandleEnroll exchanges the bootstrap token for a credential bound to a single device
+func HandleEnroll(logger *logrus.Logger, store storage.Driver, keyring *fingerprint.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		bootstrapToken := middleware.BearerToken(r)
+		if bootstrapToken == "" {
+			logger.WithField("ip", middleware.GetClientIP(r)).Warn("missing bootstrap token")
+			middleware.WriteError(logger, w, http.StatusUnauthorized, "missing token")
+			return
+		}
+
+		valid, err := store.IsValidToken(r.Context(), bootstrapToken)
+		if err != nil {
+			logger.WithError(err).Error("failed to validate bootstrap token")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !valid {
+			logger.WithField("ip", middleware.GetClientIP(r)).Warn("invalid bootstrap token")
+			middleware.WriteError(logger, w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		var data enrollData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		valid, err = govalidator.ValidateStruct(data)
+		if !valid || err != nil {
+			logger.WithError(err).WithField("body", data).Error("endpoint data validation failed")
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		credential, err := generateCredential()
+		if err != nil {
+			logger.WithError(err).Error("failed to generate device credential")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		signingKey, err := generateCredential()
+		if err != nil {
+			logger.WithError(err).Error("failed to generate device signing key")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		encryptedSigningKey, err := keyring.EncryptSigningKey(signingKey)
+		if err != nil {
+			logger.WithError(err).Error("failed to encrypt device signing key")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := store.EnrollDevice(r.Context(), data.DeviceID, middleware.HashCredential(credential), encryptedSigningKey); err != nil {
+			if errors.Is(err, models.ErrDeviceRevoked) {
+				logger.WithField("device_id", data.DeviceID).Warn("revoked device tried to enroll")
+				middleware.WriteError(logger, w, http.StatusForbidden, "device has been revoked")
+				return
+			}
+
+			// taking over the identity of an enrolled device requires an admin to reset it first
+			if errors.Is(err, models.ErrDeviceEnrolled) {
+				logger.WithFields(logrus.Fields{
+					"device_id": data.DeviceID,
+					"ip":        middleware.GetClientIP(r),
+				}).Warn("enrolled device tried to enroll again")
+				middleware.WriteError(logger, w, http.StatusConflict, "device is already enrolled")
+				return
+			}
+
+			logger.WithError(err).WithField("device_id", data.DeviceID).Error("failed to enroll device")
+			http.Error(w, "failed to store", http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithField("device_id", data.DeviceID).Info("enrolled device")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(enrollResponse{DeviceID: data.DeviceID, Token: credential, SigningKey: signingKey}); err != nil {
+			logger.WithError(err).Error("Failed to write response")
+		}
+	}
+}
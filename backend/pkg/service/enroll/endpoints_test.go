@@ -0,0 +1,173 @@
+package enroll
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const bootstrapToken = "bootstrap"
+
+func newKeyring(t *testing.T) *fingerprint.Keyring {
+	t.Helper()
+
+	keyring, err := fingerprint.NewKeyring(map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return keyring
+}
+
+func enroll(t *testing.T, handler http.Handler, token, deviceID string) (int, enrollResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/enroll", strings.NewReader(`{"device_id":"`+deviceID+`"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	var response enrollResponse
+	if rec.Code == http.StatusCreated {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode enroll response: %v", err)
+		}
+	}
+
+	return rec.Code, response
+}
+
+func TestHandleEnroll(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tests := []struct {
+		name   string
+		token  string
+		before func(ctx context.Context, store *memory.InMemoryStore)
+		want   []int
+	}{
+		{
+			name:  "new device",
+			token: bootstrapToken,
+			want:  []int{http.StatusCreated},
+		},
+		{
+			name:  "invalid bootstrap token",
+			token: "wrong",
+			want:  []int{http.StatusUnauthorized},
+		},
+		{
+			name:  "enrolled device",
+			token: bootstrapToken,
+			want:  []int{http.StatusCreated, http.StatusConflict},
+		},
+		{
+			name:  "revoked device",
+			token: bootstrapToken,
+			before: func(ctx context.Context, store *memory.InMemoryStore) {
+				_ = store.RevokeDevice(ctx, "device")
+			},
+			want: []int{http.StatusForbidden},
+		},
+		{
+			name:  "reset revoked device",
+			token: bootstrapToken,
+			before: func(ctx context.Context, store *memory.InMemoryStore) {
+				_ = store.RevokeDevice(ctx, "device")
+				_ = store.ResetDevice(ctx, "device")
+			},
+			want: []int{http.StatusCreated},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			store := &memory.InMemoryStore{}
+			if err := store.Init(logger, map[string]string{"token": bootstrapToken}); err != nil {
+				t.Fatalf("failed to init store: %v", err)
+			}
+
+			if tt.before != nil {
+				tt.before(ctx, store)
+			}
+
+			handler := HandleEnroll(logger, store, newKeyring(t))
+			for i, want := range tt.want {
+				if got, _ := enroll(t, handler, tt.token, "device"); got != want {
+					t.Fatalf("enrollment %d returned %d, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEnrollReset(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	store := &memory.InMemoryStore{}
+	if err := store.Init(logger, map[string]string{"token": bootstrapToken}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+
+	keyring := newKeyring(t)
+	handler := HandleEnroll(logger, store, keyring)
+
+	code, first := enroll(t, handler, bootstrapToken, "device")
+	if code != http.StatusCreated {
+		t.Fatalf("first enrollment returned %d", code)
+	}
+
+	// the signing key is stored encrypted and is unrelated to the stored credential hash
+	storedKey, err := store.GetDeviceSigningKey(ctx, "device")
+	if err != nil {
+		t.Fatalf("failed to get signing key: %v", err)
+	}
+
+	if storedKey == first.SigningKey || storedKey == middleware.HashCredential(first.Token) {
+		t.Fatal("signing key is not stored encrypted")
+	}
+
+	if signingKey, err := keyring.DecryptSigningKey(storedKey); err != nil || signingKey != first.SigningKey {
+		t.Fatalf("stored signing key does not decrypt to the enrolled one: %v", err)
+	}
+
+	// a second enrollment must not replace the credential of the enrolled device
+	if code, _ := enroll(t, handler, bootstrapToken, "device"); code != http.StatusConflict {
+		t.Fatalf("second enrollment returned %d, want %d", code, http.StatusConflict)
+	}
+
+	if _, ok, _ := store.GetDeviceForCredential(ctx, middleware.HashCredential(first.Token)); !ok {
+		t.Fatal("credential of the enrolled device no longer valid")
+	}
+
+	if err := store.ResetDevice(ctx, "device"); err != nil {
+		t.Fatalf("failed to reset device: %v", err)
+	}
+
+	code, second := enroll(t, handler, bootstrapToken, "device")
+	if code != http.StatusCreated {
+		t.Fatalf("enrollment after reset returned %d", code)
+	}
+
+	if _, ok, _ := store.GetDeviceForCredential(ctx, middleware.HashCredential(first.Token)); ok {
+		t.Fatal("credential from before the reset is still valid")
+	}
+
+	if _, ok, _ := store.GetDeviceForCredential(ctx, middleware.HashCredential(second.Token)); !ok {
+		t.Fatal("credential from after the reset is not valid")
+	}
+}
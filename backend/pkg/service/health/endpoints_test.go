@@ -0,0 +1,38 @@
+package health
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleHealthCheck(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantBody   string
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK, wantBody: "{}"},
+		{method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HandleHealthCheck(logger).ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/health", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tt.wantBody {
+				t.Errorf("got body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
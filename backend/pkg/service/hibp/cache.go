@@ -1,128 +1,227 @@
 package hibp
 
 import (
+	"container/list"
+	"context"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
 	"sync"
 	"time"
+)
 
-	"github.com/sirupsen/logrus"
+const (
+	DefaultCacheTTL        = time.Hour
+	DefaultCacheMaxEntries = 1000
+
+	cacheCleanupInterval = 30 * time.Minute
 )
 
-// CacheEntry represents a cached HIBP result
-type CacheEntry struct {
-	BreachCount int
-	Timestamp   time.Time
+// cacheEntry is a range response for a 5 character hash prefix
+type cacheEntry struct {
+	prefix    string
+	body      string
+	fetchedAt time.Time
 }
 
-// Cache represents an in-memory cache for HIBP results
+// Cache keeps HIBP range responses per prefix, so one lookup serves every hash sharing it.
+// Recently used ranges are kept in memory, in front of the storage driver which shares them
+// across replicas and restarts. Both are limited to maxEntries, evicting the least recently used.
 type Cache struct {
-	entries map[string]CacheEntry
-	mutex   sync.RWMutex
-	logger  *logrus.Logger
-	ttl     time.Duration
+	logger     *logrus.Logger
+	store      storage.Driver
+	ttl        time.Duration
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// most recently used at the front
+	lru *list.List
+
+	stop context.CancelFunc
+	done chan struct{}
 }
 
-// NewCache creates a new HIBP cache with 1-hour TTL
-func NewCache(logger *logrus.Logger) *Cache {
+// NewCache creates a new HIBP range cache backed by store
+func NewCache(logger *logrus.Logger, store storage.Driver, ttl time.Duration, maxEntries int) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+
 	cache := &Cache{
-		entries: make(map[string]CacheEntry),
-		logger:  logger,
-		ttl:     time.Hour, // 1 hour cache as specified
+		logger:     logger,
+		store:      store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		done:       make(chan struct{}),
 	}
-	
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache.stop = cancel
+
 	// Start cleanup goroutine
-	go cache.cleanup()
-	
+	go cache.cleanup(ctx)
+
 	return cache
 }
 
-// Get retrieves a cached result for a password hash
-func (c *Cache) Get(passwordHash string) (int, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	
-	entry, exists := c.entries[passwordHash]
+// Get retrieves the cached range for a prefix, the shared cache is only consulted until ctx is done
+func (c *Cache) Get(ctx context.Context, prefix string) (string, bool) {
+	if body, found := c.getMemory(prefix); found {
+		return body, true
+	}
+
+	cached, found, err := c.store.GetHIBPRange(ctx, prefix)
+	if err != nil {
+		c.logger.WithError(err).WithField("prefix", prefix).Warn("failed to retrieve cached HIBP range")
+		return "", false
+	}
+
+	if !found || time.Since(cached.FetchedAt) > c.ttl {
+		return "", false
+	}
+
+	c.logger.WithField("prefix", prefix).Debug("shared cache hit for HIBP range")
+
+	c.setMemory(prefix, cached.Body, cached.FetchedAt)
+
+	return cached.Body, true
+}
+
+func (c *Cache) getMemory(prefix string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[prefix]
 	if !exists {
-		return 0, false
+		return "", false
 	}
-	
+
+	entry := element.Value.(*cacheEntry)
+
 	// Check if entry has expired
-	if time.Since(entry.Timestamp) > c.ttl {
-		c.logger.WithField("hash_prefix", passwordHash[:5]).Debug("cache entry expired")
-		return 0, false
+	if time.Since(entry.fetchedAt) > c.ttl {
+		c.lru.Remove(element)
+		delete(c.entries, prefix)
+		c.logger.WithField("prefix", prefix).Debug("cache entry expired")
+		return "", false
 	}
-	
+
+	c.lru.MoveToFront(element)
+
 	c.logger.WithFields(logrus.Fields{
-		"hash_prefix": passwordHash[:5],
-		"breach_count": entry.BreachCount,
-		"age": time.Since(entry.Timestamp).String(),
-	}).Debug("cache hit for password hash")
-	
-	return entry.BreachCount, true
+		"prefix": prefix,
+		"age":    time.Since(entry.fetchedAt).String(),
+	}).Debug("cache hit for HIBP range")
+
+	return entry.body, true
 }
 
-// Set stores a result in the cache
-func (c *Cache) Set(passwordHash string, breachCount int) {
+// Set stores a range in the cache, the shared cache is only written until ctx is done
+func (c *Cache) Set(ctx context.Context, prefix, body string) {
+	c.setMemory(prefix, body, time.Now())
+
+	if err := c.store.StoreHIBPRange(ctx, prefix, body); err != nil {
+		c.logger.WithError(err).WithField("prefix", prefix).Warn("failed to store HIBP range")
+		return
+	}
+
+	c.logger.WithField("prefix", prefix).Debug("cached HIBP range")
+}
+
+func (c *Cache) setMemory(prefix, body string, fetchedAt time.Time) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	
-	c.entries[passwordHash] = CacheEntry{
-		BreachCount: breachCount,
-		Timestamp:   time.Now(),
+
+	if element, exists := c.entries[prefix]; exists {
+		element.Value = &cacheEntry{prefix: prefix, body: body, fetchedAt: fetchedAt}
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[prefix] = c.lru.PushFront(&cacheEntry{prefix: prefix, body: body, fetchedAt: fetchedAt})
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).prefix)
 	}
-	
-	c.logger.WithFields(logrus.Fields{
-		"hash_prefix": passwordHash[:5],
-		"breach_count": breachCount,
-	}).Debug("cached HIBP result")
 }
 
-// cleanup removes expired entries from the cache
-func (c *Cache) cleanup() {
-	ticker := time.NewTicker(30 * time.Minute) // Clean up every 30 minutes
+// cleanup removes expired entries from memory and prunes the shared cache
+func (c *Cache) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(cacheCleanupInterval)
 	defer ticker.Stop()
-	
-	for range ticker.C {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		c.mutex.Lock()
-		
+
 		now := time.Now()
 		expired := 0
-		
-		for hash, entry := range c.entries {
-			if now.Sub(entry.Timestamp) > c.ttl {
-				delete(c.entries, hash)
+
+		for prefix, element := range c.entries {
+			if now.Sub(element.Value.(*cacheEntry).fetchedAt) > c.ttl {
+				c.lru.Remove(element)
+				delete(c.entries, prefix)
 				expired++
 			}
 		}
-		
+
 		if expired > 0 {
 			c.logger.WithFields(logrus.Fields{
-				"expired_entries": expired,
+				"expired_entries":   expired,
 				"remaining_entries": len(c.entries),
 			}).Debug("cleaned up expired cache entries")
 		}
-		
+
 		c.mutex.Unlock()
+
+		pruned, err := c.store.PruneHIBPRanges(ctx, now.Add(-c.ttl), c.maxEntries)
+		if err != nil {
+			c.logger.WithError(err).Warn("failed to prune cached HIBP ranges")
+		} else if pruned > 0 {
+			c.logger.WithField("pruned_ranges", pruned).Debug("pruned cached HIBP ranges")
+		}
 	}
 }
 
+// Close stops the cleanup goroutine, cancelling a running prune, and waits for it to exit
+func (c *Cache) Close() {
+	c.stop()
+	<-c.done
+}
+
 // Stats returns cache statistics
 func (c *Cache) Stats() map[string]interface{} {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	return map[string]interface{}{
 		"total_entries": len(c.entries),
+		"max_entries":   c.maxEntries,
 		"ttl_hours":     c.ttl.Hours(),
 	}
 }
 
-// Clear removes all entries from the cache
+// Clear removes all entries from the in-memory cache, the shared cache expires on its own
 func (c *Cache) Clear() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	
+
 	cleared := len(c.entries)
-	c.entries = make(map[string]CacheEntry)
-	
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+
 	c.logger.WithField("cleared_entries", cleared).Info("cleared HIBP cache")
-}
\ No newline at end of file
+}
@@ -0,0 +1,174 @@
+package hibp
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeStore counts the shared cache reads and blocks on them until ctx is done when blocking is set
+type rangeStore struct {
+	storage.Driver
+	reads    atomic.Int32
+	blocking bool
+}
+
+func (s *rangeStore) GetHIBPRange(ctx context.Context, prefix string) (models.HIBPRange, bool, error) {
+	s.reads.Add(1)
+	if s.blocking {
+		<-ctx.Done()
+		return models.HIBPRange{}, false, ctx.Err()
+	}
+	return s.Driver.GetHIBPRange(ctx, prefix)
+}
+
+func (s *rangeStore) StoreHIBPRange(ctx context.Context, prefix, body string) error {
+	if s.blocking {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.Driver.StoreHIBPRange(ctx, prefix, body)
+}
+
+func newRangeStore(t *testing.T, logger *logrus.Logger) *rangeStore {
+	t.Helper()
+
+	store := &memory.InMemoryStore{}
+	if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+
+	return &rangeStore{Driver: store}
+}
+
+func TestCacheGetSet(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	store := newRangeStore(t, logger)
+	cache := NewCache(logger, store, time.Hour, 2)
+	defer cache.Close()
+
+	if _, found := cache.Get(ctx, "5BAA6"); found {
+		t.Fatal("empty cache returned a range")
+	}
+
+	cache.Set(ctx, "5BAA6", rangeBody)
+
+	reads := store.reads.Load()
+	if body, found := cache.Get(ctx, "5BAA6"); !found || body != rangeBody {
+		t.Fatalf("got %q %v, want the stored range", body, found)
+	}
+	if store.reads.Load() != reads {
+		t.Error("a range in memory was read from the shared cache")
+	}
+
+	// another replica finds the range in the shared cache
+	replica := NewCache(logger, store, time.Hour, 2)
+	defer replica.Close()
+
+	if body, found := replica.Get(ctx, "5BAA6"); !found || body != rangeBody {
+		t.Fatalf("got %q %v from the shared cache, want the stored range", body, found)
+	}
+	if store.reads.Load() != reads+1 {
+		t.Error("the shared cache was not read")
+	}
+
+	// and keeps it in memory from then on
+	replica.Get(ctx, "5BAA6")
+	if store.reads.Load() != reads+1 {
+		t.Error("a range from the shared cache was not kept in memory")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	store := newRangeStore(t, logger)
+	cache := NewCache(logger, store, time.Hour, 2)
+	defer cache.Close()
+
+	cache.Set(ctx, "AAAAA", "a")
+	cache.Set(ctx, "BBBBB", "b")
+	// AAAAA is now the most recently used, so BBBBB is evicted
+	cache.Get(ctx, "AAAAA")
+	cache.Set(ctx, "CCCCC", "c")
+
+	if stats := cache.Stats(); stats["total_entries"] != 2 {
+		t.Errorf("got %v entries in memory, want 2", stats["total_entries"])
+	}
+
+	for _, prefix := range []string{"AAAAA", "CCCCC", "BBBBB"} {
+		reads := store.reads.Load()
+		if _, found := cache.Get(ctx, prefix); !found {
+			t.Fatalf("range %s not found", prefix)
+		}
+
+		fromStore := store.reads.Load() != reads
+		if wantFromStore := prefix == "BBBBB"; fromStore != wantFromStore {
+			t.Errorf("range %s read from the shared cache %v, want %v", prefix, fromStore, wantFromStore)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	store := newRangeStore(t, logger)
+	cache := NewCache(logger, store, 20*time.Millisecond, 10)
+	defer cache.Close()
+
+	cache.Set(ctx, "5BAA6", rangeBody)
+	time.Sleep(40 * time.Millisecond)
+
+	// expired both in memory and in the shared cache
+	if _, found := cache.Get(ctx, "5BAA6"); found {
+		t.Error("expired range was returned")
+	}
+}
+
+func TestCacheCancelled(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := newRangeStore(t, logger)
+	store.blocking = true
+
+	cache := NewCache(logger, store, time.Hour, 10)
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if _, found := cache.Get(ctx, "5BAA6"); found {
+			t.Error("range found in a blocked shared cache")
+		}
+		cache.Set(ctx, "5BAA6", rangeBody)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cache kept waiting on the store after the context was done")
+	}
+
+	// the range is still served from memory
+	if _, found := cache.Get(context.Background(), "5BAA6"); !found {
+		t.Error("range was not kept in memory")
+	}
+}
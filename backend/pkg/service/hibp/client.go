@@ -1,181 +1,245 @@
 package hibp
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 const (
-	HIBPAPIBaseURL = "https://api.pwnedpasswords.com/range/"
+	DefaultBaseURL = "https://api.pwnedpasswords.com"
 	UserAgent      = "shade-password-monitor"
+
+	defaultTimeout        = 10 * time.Second
+	defaultMaxConcurrency = 10
+	defaultMaxRetries     = 3
+	defaultRetryBackoff   = 500 * time.Millisecond
+	// a single wait is capped, so a huge Retry-After does not stall lookups indefinitely
+	maxRetryWait = 30 * time.Second
 )
 
+// ClientOptions configures the HIBP API client, zero values are replaced with defaults
+type ClientOptions struct {
+	// BaseURL is the root of the API, e.g. an internal proxy; ranges are requested from /range/<prefix>
+	BaseURL string
+	Timeout time.Duration
+	// Padding asks the API to pad responses with zero-count entries, hiding the response size
+	Padding bool
+	// MaxConcurrency limits the requests in flight
+	MaxConcurrency int
+	// MaxRetries is how often a rate-limited or failed request is retried, with exponential backoff.
+	// Nil uses the default, zero disables retries.
+	MaxRetries   *int
+	RetryBackoff time.Duration
+}
+
 // Client represents a HIBP API client
 type Client struct {
-	httpClient *http.Client
-	logger     *logrus.Logger
-	userAgent  string
+	httpClient   *http.Client
+	logger       *logrus.Logger
+	userAgent    string
+	baseURL      string
+	padding      bool
+	maxRetries   int
+	retryBackoff time.Duration
+	slots        chan struct{}
+}
+
+// retryableError is a failure that may succeed when tried again later
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
 }
 
 // NewClient creates a new HIBP client
-func NewClient(logger *logrus.Logger) *Client {
+func NewClient(logger *logrus.Logger, options ClientOptions) *Client {
+	if options.BaseURL == "" {
+		options.BaseURL = DefaultBaseURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.MaxConcurrency <= 0 {
+		options.MaxConcurrency = defaultMaxConcurrency
+	}
+	maxRetries := defaultMaxRetries
+	if options.MaxRetries != nil {
+		maxRetries = max(*options.MaxRetries, 0)
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultRetryBackoff
+	}
+
 	return &Client{
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: options.Timeout,
 		},
-		logger:    logger,
-		userAgent: UserAgent,
+		logger:       logger,
+		userAgent:    UserAgent,
+		baseURL:      strings.TrimRight(options.BaseURL, "/") + "/range/",
+		padding:      options.Padding,
+		maxRetries:   maxRetries,
+		retryBackoff: options.RetryBackoff,
+		slots:        make(chan struct{}, options.MaxConcurrency),
 	}
 }
 
 // CheckPassword checks if a password has been compromised using HIBP API
 // Returns the number of times the password has been seen in breaches, or 0 if not found
-func (c *Client) CheckPassword(password string) (int, error) {
+func (c *Client) CheckPassword(ctx context.Context, password string) (int, error) {
 	// Generate SHA-1 hash of the password
 	hash := sha1.Sum([]byte(password))
 	hashStr := strings.ToUpper(hex.EncodeToString(hash[:]))
-	
-	// Use k-anonymity: send only first 5 characters of hash
+
+	return c.CheckPasswordHash(ctx, hashStr)
+}
+
+// CheckPasswordHash checks if a password hash has been compromised
+// Expects a SHA-1 hash in uppercase hex format
+func (c *Client) CheckPasswordHash(ctx context.Context, hashStr string) (int, error) {
+	if len(hashStr) != 40 {
+		return 0, fmt.Errorf("invalid hash length: expected 40 characters, got %d", len(hashStr))
+	}
+
+	hashStr = strings.ToUpper(hashStr)
 	prefix := hashStr[:5]
 	suffix := hashStr[5:]
-	
-	// Make request to HIBP API
-	url := HIBPAPIBaseURL + prefix
-	req, err := http.NewRequest("GET", url, nil)
+
+	c.logger.WithField("prefix", prefix).Debug("checking password hash prefix with HIBP")
+
+	body, err := c.GetRange(ctx, prefix)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %w", err)
+		return 0, err
 	}
-	
-	req.Header.Set("User-Agent", c.userAgent)
-	
-	c.logger.WithField("prefix", prefix).Debug("checking password hash prefix with HIBP")
-	
-	resp, err := c.httpClient.Do(req)
+
+	count, err := findSuffix(bytes.NewReader(body), suffix)
 	if err != nil {
-		return 0, fmt.Errorf("failed to make request to HIBP: %w", err)
+		return 0, err
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("HIBP API returned status %d", resp.StatusCode)
+
+	if count > 0 {
+		c.logger.WithFields(logrus.Fields{
+			"prefix": prefix,
+			"count":  count,
+		}).Debug("password hash found in breaches")
+	} else {
+		c.logger.WithField("prefix", prefix).Debug("password hash not found in breaches")
 	}
-	
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("failed to read response body: %w", err)
-	}
-	
-	// Parse response to find our hash suffix
-	lines := strings.Split(string(body), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+
+	return count, nil
+}
+
+// GetRange requests all hash suffixes for a 5 character prefix using k-anonymity.
+// Rate-limited and failed requests are retried with exponential backoff, honouring Retry-After,
+// until ctx is cancelled.
+func (c *Client) GetRange(ctx context.Context, prefix string) ([]byte, error) {
+	backoff := c.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		body, err := c.getRange(ctx, prefix)
+
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= c.maxRetries {
+			return body, err
+		}
+
+		wait := backoff
+		if retryable.retryAfter > wait {
+			wait = retryable.retryAfter
 		}
-		
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			continue
+		if wait > maxRetryWait {
+			wait = maxRetryWait
 		}
-		
-		if strings.EqualFold(parts[0], suffix) {
-			count, err := strconv.Atoi(parts[1])
-			if err != nil {
-				c.logger.WithError(err).WithField("count_str", parts[1]).Warn("failed to parse breach count")
-				return 0, fmt.Errorf("failed to parse breach count: %w", err)
-			}
-			
-			c.logger.WithFields(logrus.Fields{
-				"prefix": prefix,
-				"count":  count,
-			}).Debug("password found in breaches")
-			
-			return count, nil
+
+		c.logger.WithError(err).WithFields(logrus.Fields{
+			"prefix":  prefix,
+			"attempt": attempt + 1,
+			"wait":    wait.String(),
+		}).Debug("retrying HIBP request")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
 		}
+		backoff *= 2
 	}
-	
-	// Hash not found in breaches
-	c.logger.WithField("prefix", prefix).Debug("password not found in breaches")
-	return 0, nil
 }
 
-// CheckPasswordHash checks if a password hash has been compromised
-// Expects a SHA-1 hash in uppercase hex format
-func (c *Client) CheckPasswordHash(hashStr string) (int, error) {
-	if len(hashStr) != 40 {
-		return 0, fmt.Errorf("invalid hash length: expected 40 characters, got %d", len(hashStr))
+// getRange makes a single range request
+func (c *Client) getRange(ctx context.Context, prefix string) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case c.slots <- struct{}{}:
 	}
-	
-	hashStr = strings.ToUpper(hashStr)
-	prefix := hashStr[:5]
-	suffix := hashStr[5:]
-	
-	// Make request to HIBP API
-	url := HIBPAPIBaseURL + prefix
-	req, err := http.NewRequest("GET", url, nil)
+	defer func() { <-c.slots }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+prefix, nil)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	
+
 	req.Header.Set("User-Agent", c.userAgent)
-	
-	c.logger.WithField("prefix", prefix).Debug("checking password hash prefix with HIBP")
-	
+	if c.padding {
+		req.Header.Set("Add-Padding", "true")
+	}
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("failed to make request to HIBP: %w", err)
+		// a cancelled lookup is not worth retrying
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &retryableError{err: fmt.Errorf("failed to make request to HIBP: %w", err)}
 	}
 	defer resp.Body.Close()
-	
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &retryableError{
+			err:        fmt.Errorf("HIBP API returned status %d", resp.StatusCode),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("HIBP API returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("HIBP API returned status %d", resp.StatusCode)
 	}
-	
-	// Read response body
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read response body: %w", err)
-	}
-	
-	// Parse response to find our hash suffix
-	lines := strings.Split(string(body), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			continue
-		}
-		
-		if strings.EqualFold(parts[0], suffix) {
-			count, err := strconv.Atoi(parts[1])
-			if err != nil {
-				c.logger.WithError(err).WithField("count_str", parts[1]).Warn("failed to parse breach count")
-				return 0, fmt.Errorf("failed to parse breach count: %w", err)
-			}
-			
-			c.logger.WithFields(logrus.Fields{
-				"prefix": prefix,
-				"count":  count,
-			}).Debug("password hash found in breaches")
-			
-			return count, nil
-		}
+		return nil, &retryableError{err: fmt.Errorf("failed to read response body: %w", err)}
 	}
-	
-	// Hash not found in breaches
-	c.logger.WithField("prefix", prefix).Debug("password hash not found in breaches")
-	return 0, nil
-}
\ No newline at end of file
+
+	return body, nil
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
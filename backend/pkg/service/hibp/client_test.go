@@ -0,0 +1,212 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeBody holds the suffix of the SHA-1 of "password", 5BAA6 being its prefix
+const rangeBody = "1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n"
+
+func TestClientCheckPasswordHash(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	noRetries := 0
+	twoRetries := 2
+
+	tests := []struct {
+		name       string
+		maxRetries *int
+		// statuses are returned in order, the last one repeats
+		statuses     []int
+		hash         string
+		want         int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "found", statuses: []int{http.StatusOK}, hash: "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", want: 3861493, wantRequests: 1},
+		{name: "lowercase hash", statuses: []int{http.StatusOK}, hash: "5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8", want: 3861493, wantRequests: 1},
+		{name: "padding entry", statuses: []int{http.StatusOK}, hash: "5BAA600D4F6E8FA6EECAD2A3AA415EEC418D38EC", want: 0, wantRequests: 1},
+		{name: "not found", statuses: []int{http.StatusOK}, hash: "5BAA6FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", want: 0, wantRequests: 1},
+		{name: "invalid hash", hash: "5BAA6", wantErr: true},
+		{
+			name:         "rate limited then ok",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK},
+			hash:         "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",
+			want:         3861493,
+			wantRequests: 3,
+		},
+		{
+			name:         "retries exhausted",
+			maxRetries:   &twoRetries,
+			statuses:     []int{http.StatusInternalServerError},
+			hash:         "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			name:         "retries disabled",
+			maxRetries:   &noRetries,
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			hash:         "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "client errors are not retried",
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			hash:         "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8",
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+
+				if r.URL.Path != "/api/range/5BAA6" {
+					t.Errorf("got path %s, want /api/range/5BAA6", r.URL.Path)
+				}
+				if r.Header.Get("Add-Padding") != "true" || r.Header.Get("User-Agent") != UserAgent {
+					t.Errorf("got headers %v", r.Header)
+				}
+
+				status := tt.statuses[min(n, len(tt.statuses))-1]
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(rangeBody))
+				}
+			}))
+			defer server.Close()
+
+			client := NewClient(logger, ClientOptions{
+				BaseURL:      server.URL + "/api/",
+				Padding:      true,
+				MaxRetries:   tt.maxRetries,
+				RetryBackoff: time.Millisecond,
+			})
+
+			got, err := client.CheckPasswordHash(context.Background(), tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got count %d, want %d", got, tt.want)
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", requests.Load(), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClientWithoutPadding(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := r.Header["Add-Padding"]; found {
+			t.Error("padding was requested")
+		}
+		_, _ = w.Write([]byte(rangeBody))
+	}))
+	defer server.Close()
+
+	client := NewClient(logger, ClientOptions{BaseURL: server.URL})
+	if _, err := client.GetRange(context.Background(), "5BAA6"); err != nil {
+		t.Fatalf("failed to get range: %v", err)
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(rangeBody))
+	}))
+	defer server.Close()
+
+	client := NewClient(logger, ClientOptions{BaseURL: server.URL, RetryBackoff: time.Millisecond})
+
+	start := time.Now()
+	if _, err := client.GetRange(context.Background(), "5BAA6"); err != nil {
+		t.Fatalf("failed to get range: %v", err)
+	}
+
+	// Retry-After outweighs the much shorter backoff
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least 1s", elapsed)
+	}
+}
+
+func TestClientCancelledWhileWaiting(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClient(logger, ClientOptions{BaseURL: server.URL})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetRange(ctx, "5BAA6")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s, the wait did not stop on cancel", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{name: "empty", value: "", min: 0, max: 0},
+		{name: "seconds", value: "120", min: 2 * time.Minute, max: 2 * time.Minute},
+		{name: "zero", value: "0", min: 0, max: 0},
+		{name: "negative", value: "-5", min: 0, max: 0},
+		{name: "garbage", value: "soon", min: 0, max: 0},
+		{
+			name:  "http date",
+			value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			min:   50 * time.Second,
+			max:   time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("got %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package hibp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	rangeFileExtension = ".txt"
+
+	// below this many bytes the sorted file is scanned instead of bisected
+	linearScanSize = 4096
+	// a line is a 40 character hash, a colon, a count and a line ending
+	maxLineLength = 64
+)
+
+// Dataset is a breach source reading a locally downloaded Pwned Passwords dataset, for networks without internet access.
+// The path is either a directory with a <PREFIX>.txt range file per prefix as written by ImportDataset,
+// or a single file with HASH:COUNT lines ordered by hash as published by HIBP.
+type Dataset struct {
+	logger *logrus.Logger
+	path   string
+	sorted bool
+}
+
+// NewDataset opens the dataset at path
+func NewDataset(logger *logrus.Logger, path string) (*Dataset, error) {
+	if path == "" {
+		return nil, errors.New("dataset path is required")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dataset := &Dataset{
+		logger: logger,
+		path:   path,
+		sorted: !info.IsDir(),
+	}
+
+	logger.WithField("path", path).WithField("sorted_file", dataset.sorted).Info("using offline HIBP dataset")
+
+	return dataset, nil
+}
+
+// CheckPasswordHash looks up a SHA-1 hash in the dataset
+func (d *Dataset) CheckPasswordHash(_ context.Context, hash string) (int, error) {
+	if len(hash) != 40 {
+		return 0, fmt.Errorf("invalid hash length: expected 40 characters, got %d", len(hash))
+	}
+
+	hash = strings.ToUpper(hash)
+
+	if d.sorted {
+		return d.searchSortedFile(hash)
+	}
+
+	return d.searchRangeFile(hash)
+}
+
+// searchRangeFile scans the range file of the hash prefix
+func (d *Dataset) searchRangeFile(hash string) (int, error) {
+	file, err := os.Open(filepath.Join(d.path, hash[:5]+rangeFileExtension))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// a missing range means an incomplete import, not a clean password
+			return 0, fmt.Errorf("range %s is missing from the dataset", hash[:5])
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	return findSuffix(file, hash[5:])
+}
+
+// searchSortedFile bisects the file for the first line not sorting before the hash
+func (d *Dataset) searchSortedFile(hash string) (int, error) {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// lo is always the start of a line, every line before it sorts before the hash
+	lo, hi := int64(0), info.Size()
+
+	for hi-lo > linearScanSize {
+		mid := lo + (hi-lo)/2
+
+		start, line, err := readLineAfter(file, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if start >= hi || line == nil {
+			break
+		}
+
+		if compareHash(line, hash) < 0 {
+			lo = start + int64(len(line))
+		} else {
+			hi = start
+		}
+	}
+
+	return scanSorted(io.NewSectionReader(file, lo, info.Size()-lo), hash)
+}
+
+// readLineAfter returns the first line starting at or after offset, including its line ending
+func readLineAfter(file *os.File, offset int64) (int64, []byte, error) {
+	start := offset
+	if offset > 0 {
+		// the line is only whole if the previous byte ends a line
+		start = offset - 1
+	}
+
+	buf := make([]byte, 2*maxLineLength)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, nil, err
+	}
+	buf = buf[:n]
+
+	if offset > 0 {
+		newline := bytes.IndexByte(buf, '\n')
+		if newline < 0 {
+			return 0, nil, nil
+		}
+		start += int64(newline) + 1
+		buf = buf[newline+1:]
+	}
+
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		// the last line of the file may lack a line ending
+		return start, buf, nil
+	}
+
+	return start, buf[:end+1], nil
+}
+
+// scanSorted reads lines until one no longer sorts before the hash
+func scanSorted(r io.Reader, hash string) (int, error) {
+	data, err := io.ReadAll(io.LimitReader(r, linearScanSize+2*maxLineLength))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dataset: %w", err)
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		cmp := compareHash(line, hash)
+		if cmp < 0 {
+			continue
+		}
+		if cmp > 0 {
+			break
+		}
+
+		_, countStr, _ := strings.Cut(strings.TrimSpace(string(line)), ":")
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse breach count: %w", err)
+		}
+
+		return count, nil
+	}
+
+	return 0, nil
+}
+
+// compareHash compares the hash at the start of a dataset line with an uppercase hash
+func compareHash(line []byte, hash string) int {
+	lineHash, _, _ := bytes.Cut(line, []byte(":"))
+	return bytes.Compare(bytes.ToUpper(bytes.TrimSpace(lineHash)), []byte(hash))
+}
@@ -0,0 +1,144 @@
+package hibp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// datasetHashes returns uppercase SHA-1 hashes in dataset order, breached i+1 times
+func datasetHashes(prefix string, n int) []string {
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s%d", prefix, i)))
+		hashes = append(hashes, strings.ToUpper(hex.EncodeToString(sum[:])))
+	}
+	sort.Strings(hashes)
+
+	return hashes
+}
+
+// writeSortedFile writes the hashes as a single sorted file as published by HIBP
+func writeSortedFile(t *testing.T, hashes []string, lineEnding string, trailing bool) string {
+	t.Helper()
+
+	lines := make([]string, 0, len(hashes))
+	for i, hash := range hashes {
+		lines = append(lines, fmt.Sprintf("%s:%d", hash, i+1))
+	}
+
+	data := strings.Join(lines, lineEnding)
+	if trailing {
+		data += lineEnding
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write dataset: %v", err)
+	}
+
+	return path
+}
+
+func TestDatasetSortedFile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// large enough to bisect many times before scanning
+	hashes := datasetHashes("breached", 5000)
+	missing := append(datasetHashes("clean", 200), strings.Repeat("0", 40), strings.Repeat("F", 40))
+
+	tests := []struct {
+		name       string
+		hashes     []string
+		lineEnding string
+		trailing   bool
+	}{
+		{name: "line feeds", hashes: hashes, lineEnding: "\n", trailing: true},
+		{name: "carriage returns", hashes: hashes, lineEnding: "\r\n", trailing: true},
+		{name: "no trailing line ending", hashes: hashes, lineEnding: "\n"},
+		{name: "smaller than a scan", hashes: hashes[:20], lineEnding: "\r\n", trailing: true},
+		{name: "single line", hashes: hashes[:1], lineEnding: "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataset, err := NewDataset(logger, writeSortedFile(t, tt.hashes, tt.lineEnding, tt.trailing))
+			if err != nil {
+				t.Fatalf("failed to open dataset: %v", err)
+			}
+
+			ctx := context.Background()
+
+			for i, hash := range tt.hashes {
+				count, err := dataset.CheckPasswordHash(ctx, strings.ToLower(hash))
+				if err != nil {
+					t.Fatalf("failed to check %s: %v", hash, err)
+				}
+				if count != i+1 {
+					t.Fatalf("got count %d for %s, want %d", count, hash, i+1)
+				}
+			}
+
+			for _, hash := range missing {
+				count, err := dataset.CheckPasswordHash(ctx, hash)
+				if err != nil {
+					t.Fatalf("failed to check %s: %v", hash, err)
+				}
+				if count != 0 {
+					t.Fatalf("got count %d for missing %s", count, hash)
+				}
+			}
+		})
+	}
+}
+
+func TestDatasetRangeFiles(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	body := "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "ABCDE"+rangeFileExtension), []byte(body), 0o600); err != nil {
+		t.Fatalf("failed to write range: %v", err)
+	}
+
+	dataset, err := NewDataset(logger, dir)
+	if err != nil {
+		t.Fatalf("failed to open dataset: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		hash    string
+		want    int
+		wantErr bool
+	}{
+		{name: "breached", hash: "ABCDE0018A45C4D1DEF81644B54AB7F969B88D65", want: 3},
+		{name: "lowercase", hash: "abcde011053fd0102e94d6ae2f8b83d76faf94f6", want: 1},
+		{name: "clean", hash: "ABCDE00D4F6E8FA6EECAD2A3AA415EEC418D38EC"},
+		// an incomplete import must not report passwords as clean
+		{name: "missing range", hash: "FFFFF0018A45C4D1DEF81644B54AB7F969B88D65", wantErr: true},
+		{name: "invalid hash", hash: "ABCDE", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dataset.CheckPasswordHash(context.Background(), tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got count %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package hibp
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// every 5 character hex prefix
+	rangeCount = 1 << 20
+
+	importProgressInterval = 1 << 16
+)
+
+// ImportDataset downloads every range from the HIBP API into dir, one <PREFIX>.txt file per prefix.
+// At most concurrency ranges are downloaded at once, further limited by the client.
+// Running it again updates an existing dataset; files are replaced atomically so a server can keep reading it.
+func ImportDataset(ctx context.Context, logger *logrus.Logger, client *Client, dir string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create dataset directory: %w", err)
+	}
+
+	prefixes := make(chan string)
+	var wg sync.WaitGroup
+	var done atomic.Int64
+
+	var firstErr error
+	var errOnce sync.Once
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for prefix := range prefixes {
+				if err := importRange(ctx, client, dir, prefix); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("failed to import range %s: %w", prefix, err)
+						cancel()
+					})
+					continue
+				}
+
+				if count := done.Add(1); count%importProgressInterval == 0 {
+					logger.WithField("ranges", count).WithField("total", rangeCount).Info("importing HIBP dataset")
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < rangeCount; i++ {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case prefixes <- fmt.Sprintf("%05X", i):
+		}
+	}
+
+	close(prefixes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// importRange downloads a single range and moves it into place
+func importRange(ctx context.Context, client *Client, dir, prefix string) error {
+	body, err := client.GetRange(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, prefix+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// padding only hides the response size on the wire, it has no place in the dataset
+	if _, err := tmp.Write(stripPadding(body)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, prefix+rangeFileExtension))
+}
@@ -0,0 +1,153 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQueueWorkers = 4
+	DefaultQueueSize    = 1000
+)
+
+// Job is a submitted password waiting for its breach lookup
+type Job struct {
+	// Fingerprint is the keyed reuse hash the result is stored under
+	Fingerprint string
+	// BreachHash is the SHA-1 looked up in the breach source
+	BreachHash string
+	DeviceID   string
+	Domain     string
+	Username   string
+}
+
+// Queue checks submitted passwords in the background, so ingestion does not wait on the breach source.
+// The number of lookups in flight and the number of waiting jobs are bounded.
+// Breached passwords are stored as verdicts for the device, which picks them up on its next request.
+type Queue struct {
+	logger  *logrus.Logger
+	service *Service
+	store   storage.Driver
+	workers int
+
+	jobs   chan Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewQueue creates a queue of at most size jobs, checked by the given number of workers
+func NewQueue(logger *logrus.Logger, service *Service, store storage.Driver, workers, size int) *Queue {
+	if workers <= 0 {
+		workers = DefaultQueueWorkers
+	}
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	return &Queue{
+		logger:  logger,
+		service: service,
+		store:   store,
+		workers: workers,
+		jobs:    make(chan Job, size),
+	}
+}
+
+// Start starts the workers
+func (q *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-q.jobs:
+					q.check(ctx, job)
+				}
+			}
+		}()
+	}
+
+	q.logger.WithFields(logrus.Fields{
+		"workers":    q.workers,
+		"queue_size": cap(q.jobs),
+	}).Info("started HIBP check queue")
+}
+
+// Enqueue adds a job without blocking, returning false when the queue is full.
+// Dropped jobs are not lost, the scheduled re-check picks up every stored password.
+func (q *Queue) Enqueue(job Job) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		q.logger.WithField("device_id", job.DeviceID).Warn("HIBP check queue is full, leaving password to the scheduled re-check")
+		return false
+	}
+}
+
+// Close stops the workers after their current lookup, jobs still waiting are left to the scheduled re-check
+func (q *Queue) Close() {
+	if q.cancel == nil {
+		return
+	}
+
+	q.cancel()
+	q.wg.Wait()
+
+	if waiting := len(q.jobs); waiting > 0 {
+		q.logger.WithField("jobs", waiting).Info("left queued HIBP checks to the scheduled re-check")
+	}
+}
+
+// check looks up a single password and stores the result
+func (q *Queue) check(ctx context.Context, job Job) {
+	breachCount, err := q.service.CheckPasswordHash(ctx, job.BreachHash)
+	if errors.Is(err, context.Canceled) {
+		// stopping, the scheduled re-check picks the password up
+		return
+	}
+	if err != nil {
+		q.logger.WithError(err).WithField("device_id", job.DeviceID).Warn("failed to check password against HIBP")
+		return
+	}
+
+	// the lookup was made, store it even when stopping
+	ctx = context.WithoutCancel(ctx)
+
+	if err := q.store.StoreHIBPResult(ctx, job.Fingerprint, breachCount); err != nil {
+		q.logger.WithError(err).WithField("device_id", job.DeviceID).Warn("failed to store HIBP result")
+	}
+
+	if breachCount == 0 {
+		return
+	}
+
+	q.logger.WithFields(logrus.Fields{
+		"username":     job.Username,
+		"domain":       job.Domain,
+		"breach_count": breachCount,
+	}).Info("password found in HIBP database")
+
+	verdict := models.HIBPVerdict{
+		DeviceID:    job.DeviceID,
+		Domain:      job.Domain,
+		Username:    job.Username,
+		BreachCount: breachCount,
+		CheckedAt:   time.Now(),
+	}
+	if err := q.store.AddHIBPVerdict(ctx, verdict); err != nil {
+		q.logger.WithError(err).WithField("device_id", job.DeviceID).Error("failed to store HIBP verdict")
+	}
+}
@@ -0,0 +1,125 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueEnqueueFull(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	queue := NewQueue(logger, nil, nil, 1, 2)
+
+	// without workers nothing is taken off the queue
+	for i, want := range []bool{true, true, false} {
+		if got := queue.Enqueue(Job{DeviceID: "device"}); got != want {
+			t.Errorf("enqueue %d got %v, want %v", i, got, want)
+		}
+	}
+
+	// closing a queue that never started does not block
+	queue.Close()
+}
+
+func TestQueueCheck(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	breached := strings.Repeat("A", 40)
+	clean := strings.Repeat("B", 40)
+	failing := strings.Repeat("C", 40)
+
+	tests := []struct {
+		name        string
+		job         Job
+		wantResult  bool
+		wantCount   int
+		wantVerdict bool
+	}{
+		{
+			name:        "breached",
+			job:         Job{Fingerprint: "fp-breached", BreachHash: breached, DeviceID: "laptop", Domain: "slack.com", Username: "alice"},
+			wantResult:  true,
+			wantCount:   7,
+			wantVerdict: true,
+		},
+		{
+			name:       "clean",
+			job:        Job{Fingerprint: "fp-clean", BreachHash: clean, DeviceID: "laptop", Domain: "slack.com", Username: "alice"},
+			wantResult: true,
+		},
+		{
+			// left to the scheduled re-check
+			name: "failed lookup",
+			job:  Job{Fingerprint: "fp-failing", BreachHash: failing, DeviceID: "laptop", Domain: "slack.com", Username: "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memory.InMemoryStore{}
+			if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+				t.Fatalf("failed to init store: %v", err)
+			}
+
+			source := &failingSource{
+				fakeSource: fakeSource{counts: map[string]int{breached: 7}, lookups: make(map[string]int)},
+				failing:    failing,
+			}
+			queue := NewQueue(logger, NewService(logger, source, nil), store, 2, 10)
+			queue.Start()
+
+			if !queue.Enqueue(tt.job) {
+				t.Fatal("failed to enqueue job")
+			}
+
+			// the lookup is made in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for source.lookupCount(tt.job.BreachHash) == 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			queue.Close()
+
+			result, found, err := store.GetHIBPResult(ctx, tt.job.Fingerprint)
+			if err != nil {
+				t.Fatalf("failed to get result: %v", err)
+			}
+			if found != tt.wantResult || result.BreachCount != tt.wantCount {
+				t.Errorf("got result %+v found %v, want count %d found %v", result, found, tt.wantCount, tt.wantResult)
+			}
+
+			verdicts, err := store.TakeHIBPVerdicts(ctx, tt.job.DeviceID)
+			if err != nil {
+				t.Fatalf("failed to take verdicts: %v", err)
+			}
+			if (len(verdicts) == 1) != tt.wantVerdict || len(verdicts) > 1 {
+				t.Fatalf("got verdicts %+v, want verdict %v", verdicts, tt.wantVerdict)
+			}
+			if tt.wantVerdict && (verdicts[0].Domain != tt.job.Domain || verdicts[0].Username != tt.job.Username || verdicts[0].BreachCount != tt.wantCount) {
+				t.Errorf("got verdict %+v for job %+v", verdicts[0], tt.job)
+			}
+		})
+	}
+}
+
+// failingSource fails the lookups of one hash
+type failingSource struct {
+	fakeSource
+	failing string
+}
+
+func (f *failingSource) CheckPasswordHash(ctx context.Context, hash string) (int, error) {
+	count, err := f.fakeSource.CheckPasswordHash(ctx, hash)
+	if hash == f.failing {
+		return 0, errors.New("source unavailable")
+	}
+	return count, err
+}
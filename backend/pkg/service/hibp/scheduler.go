@@ -0,0 +1,172 @@
+package hibp
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// the scheduler wakes up at least this often so new hashes do not wait a full interval
+	maxSchedulerTick = time.Hour
+)
+
+// Scheduler periodically re-checks every stored password hash against HIBP,
+// since a password that was clean when it was submitted may show up in a later breach.
+type Scheduler struct {
+	logger      *logrus.Logger
+	service     *Service
+	store       storage.Driver
+	keyring     *fingerprint.Keyring
+	interval    time.Duration
+	concurrency int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewScheduler creates a scheduler re-checking hashes that were last checked longer than interval ago
+func NewScheduler(logger *logrus.Logger, service *Service, store storage.Driver, keyring *fingerprint.Keyring, interval time.Duration, concurrency int) *Scheduler {
+	return &Scheduler{
+		logger:      logger,
+		service:     service,
+		store:       store,
+		keyring:     keyring,
+		interval:    interval,
+		concurrency: concurrency,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs a first check right away and keeps checking in the background until Close is called
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	tick := s.interval
+	if tick > maxSchedulerTick {
+		tick = maxSchedulerTick
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			s.run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	s.logger.WithFields(logrus.Fields{
+		"interval":    s.interval.String(),
+		"concurrency": s.concurrency,
+	}).Info("started HIBP re-check scheduler")
+}
+
+// Close stops the scheduler, waiting for lookups in flight to finish
+func (s *Scheduler) Close() {
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	<-s.done
+}
+
+// run checks all hashes that are due and stores the results
+func (s *Scheduler) run(ctx context.Context) {
+	hashes, err := s.store.GetStalePasswordHashes(ctx, time.Now().Add(-s.interval))
+	if err != nil {
+		s.logger.WithError(err).Error("failed to retrieve password hashes for HIBP re-check")
+		return
+	}
+
+	previous := make(map[string]int)
+	// reuse hashes per breach hash, so every password is only looked up once
+	reuseHashes := make(map[string][]string)
+	var due []string
+
+	for _, hash := range hashes {
+		if hash.Checked {
+			previous[hash.Hash] = hash.BreachCount
+		}
+
+		breachHash, err := s.keyring.DecryptBreachHash(hash.BreachHash)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to decrypt breach hash")
+			continue
+		}
+
+		if _, exists := reuseHashes[breachHash]; !exists {
+			due = append(due, breachHash)
+		}
+		reuseHashes[breachHash] = append(reuseHashes[breachHash], hash.Hash)
+	}
+
+	if len(due) == 0 {
+		return
+	}
+
+	s.logger.WithField("hashes", len(due)).Debug("re-checking password hashes against HIBP")
+
+	results, err := s.service.BatchCheckPasswordHashes(ctx, due, s.concurrency)
+	if err != nil {
+		s.logger.WithError(err).Debug("HIBP re-check interrupted")
+	}
+
+	// results are stored even when interrupted, the lookups were already made
+	ctx = context.WithoutCancel(ctx)
+
+	failed := 0
+	for breachHash, result := range results {
+		if result.BreachCount < 0 {
+			failed++
+			continue
+		}
+
+		for _, hash := range reuseHashes[breachHash] {
+			s.storeResult(ctx, hash, result.BreachCount, previous)
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"checked": len(results) - failed,
+		"failed":  failed,
+	}).Info("finished HIBP re-check")
+}
+
+// storeResult stores the breach count of a reuse hash and raises an event if it was clean before
+func (s *Scheduler) storeResult(ctx context.Context, hash string, breachCount int, previous map[string]int) {
+	if err := s.store.StoreHIBPResult(ctx, hash, breachCount); err != nil {
+		s.logger.WithError(err).Error("failed to store HIBP result")
+		return
+	}
+
+	previousCount, checkedBefore := previous[hash]
+	if !checkedBefore || previousCount > 0 || breachCount == 0 {
+		return
+	}
+
+	s.logger.WithField("breach_count", breachCount).Warn("previously clean password found in HIBP database")
+
+	event := events.BreachEvent{
+		Timestamp:           time.Now(),
+		Hash:                hash,
+		BreachCount:         breachCount,
+		PreviousBreachCount: previousCount,
+	}
+	if err := s.store.AddBreachEvent(ctx, event); err != nil {
+		s.logger.WithError(err).Error("failed to store breach event")
+	}
+}
@@ -0,0 +1,230 @@
+package hibp
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource returns fixed breach counts and counts the lookups per hash
+type fakeSource struct {
+	mutex   sync.Mutex
+	counts  map[string]int
+	lookups map[string]int
+	err     error
+}
+
+func (f *fakeSource) CheckPasswordHash(_ context.Context, hash string) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lookups[hash]++
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	return f.counts[hash], nil
+}
+
+func (f *fakeSource) lookupCount(hash string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.lookups[hash]
+}
+
+// newSchedulerStore returns a store with logins of alice and bob sharing a password and one of alice that is not
+func newSchedulerStore(t *testing.T, logger *logrus.Logger, keyring *fingerprint.Keyring) *memory.InMemoryStore {
+	t.Helper()
+
+	store := &memory.InMemoryStore{}
+	if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+
+	logins := []struct {
+		user, domain, reuseHash, breachHash string
+	}{
+		{"alice", "slack.com", "reuse-shared-alice", strings.Repeat("A", 40)},
+		{"bob", "github.com", "reuse-shared-bob", strings.Repeat("A", 40)},
+		{"alice", "gitlab.com", "reuse-unique", strings.Repeat("B", 40)},
+		// legacy logins without a breach hash cannot be checked
+		{"carol", "gitlab.com", "reuse-legacy", ""},
+	}
+
+	for _, login := range logins {
+		breachHash := ""
+		if login.breachHash != "" {
+			var err error
+			if breachHash, err = keyring.EncryptBreachHash(login.breachHash); err != nil {
+				t.Fatalf("failed to encrypt breach hash: %v", err)
+			}
+		}
+
+		event := events.LoginEvent{
+			Timestamp:  time.Now(),
+			User:       login.user,
+			Domain:     login.domain,
+			Host:       login.domain,
+			Hash:       keyring.Fingerprint(login.reuseHash),
+			BreachHash: breachHash,
+			DeviceID:   login.user + "-laptop",
+		}
+		if err := store.AddLoginEvent(context.Background(), event); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	return store
+}
+
+func TestSchedulerRun(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	keyring, err := fingerprint.NewKeyring(map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// counts of the first and the second run
+		first, second   map[string]int
+		secondErr       error
+		wantResults     map[string]int
+		wantBreachUsers []string
+	}{
+		{
+			name:   "clean passwords found breached later",
+			first:  map[string]int{},
+			second: map[string]int{strings.Repeat("A", 40): 7},
+			wantResults: map[string]int{
+				"reuse-shared-alice": 7,
+				"reuse-shared-bob":   7,
+				"reuse-unique":       0,
+			},
+			wantBreachUsers: []string{"alice", "bob"},
+		},
+		{
+			// only a password turning breached is news, one that was breached already is not
+			name:   "breached passwords stay breached",
+			first:  map[string]int{strings.Repeat("B", 40): 3},
+			second: map[string]int{strings.Repeat("B", 40): 5},
+			wantResults: map[string]int{
+				"reuse-shared-alice": 0,
+				"reuse-shared-bob":   0,
+				"reuse-unique":       5,
+			},
+		},
+		{
+			name:      "failed lookups keep the previous result",
+			first:     map[string]int{strings.Repeat("B", 40): 3},
+			second:    map[string]int{},
+			secondErr: errors.New("source unavailable"),
+			wantResults: map[string]int{
+				"reuse-shared-alice": 0,
+				"reuse-shared-bob":   0,
+				"reuse-unique":       3,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newSchedulerStore(t, logger, keyring)
+			source := &fakeSource{counts: tt.first, lookups: make(map[string]int)}
+
+			// every stored result is stale again right away
+			scheduler := NewScheduler(logger, NewService(logger, source, nil), store, keyring, time.Nanosecond, 2)
+
+			scheduler.run(ctx)
+
+			// a password shared by several accounts is only looked up once
+			if source.lookups[strings.Repeat("A", 40)] != 1 || source.lookups[strings.Repeat("B", 40)] != 1 || len(source.lookups) != 2 {
+				t.Fatalf("got lookups %v, want one per breach hash", source.lookups)
+			}
+
+			source.counts, source.err = tt.second, tt.secondErr
+			time.Sleep(time.Millisecond)
+			scheduler.run(ctx)
+
+			for reuseHash, want := range tt.wantResults {
+				result, found, err := store.GetHIBPResult(ctx, keyring.Fingerprint(reuseHash))
+				if err != nil || !found {
+					t.Fatalf("no result for %s: %v", reuseHash, err)
+				}
+				if result.BreachCount != want {
+					t.Errorf("got breach count %d for %s, want %d", result.BreachCount, reuseHash, want)
+				}
+			}
+
+			if _, found, _ := store.GetHIBPResult(ctx, keyring.Fingerprint("reuse-legacy")); found {
+				t.Error("legacy login without breach hash was checked")
+			}
+
+			for _, user := range []string{"alice", "bob", "carol"} {
+				page, err := store.GetUserEvents(ctx, user, models.Query{})
+				if err != nil {
+					t.Fatalf("failed to get user events: %v", err)
+				}
+
+				breached := false
+				for _, event := range page.Items {
+					breached = breached || event.Type == models.UserEventBreach
+				}
+
+				want := false
+				for _, breachUser := range tt.wantBreachUsers {
+					want = want || breachUser == user
+				}
+				if breached != want {
+					t.Errorf("user %s has breach event %v, want %v", user, breached, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerStartClose(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	keyring, err := fingerprint.NewKeyring(map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	store := newSchedulerStore(t, logger, keyring)
+	source := &fakeSource{counts: map[string]int{}, lookups: make(map[string]int)}
+	scheduler := NewScheduler(logger, NewService(logger, source, nil), store, keyring, 24*time.Hour, 1)
+
+	// closing a scheduler that never started does not block
+	NewScheduler(logger, nil, store, keyring, time.Hour, 1).Close()
+
+	scheduler.Start()
+
+	// the first check runs right away instead of after the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, found, _ := store.GetHIBPResult(context.Background(), keyring.Fingerprint("reuse-unique")); found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first check did not run on start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	scheduler.Close()
+}
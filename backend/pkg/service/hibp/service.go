@@ -1,58 +1,46 @@
 package hibp
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 // Service represents the HIBP service with caching
 type Service struct {
-	client *Client
+	source Source
 	cache  *Cache
 	logger *logrus.Logger
 }
 
-// NewService creates a new HIBP service with client and cache
-func NewService(logger *logrus.Logger) *Service {
+// NewService creates a new HIBP service looking up hashes in source.
+// Range responses of sources that support them are kept in cache.
+func NewService(logger *logrus.Logger, source Source, cache *Cache) *Service {
 	return &Service{
-		client: NewClient(logger),
-		cache:  NewCache(logger),
+		source: source,
+		cache:  cache,
 		logger: logger,
 	}
 }
 
 // CheckPassword checks if a password has been compromised, using cache when possible
-func (s *Service) CheckPassword(password string) (int, error) {
+func (s *Service) CheckPassword(ctx context.Context, password string) (int, error) {
 	// Generate SHA-1 hash of the password
 	hash := sha1.Sum([]byte(password))
 	hashStr := strings.ToUpper(hex.EncodeToString(hash[:]))
-	
-	return s.CheckPasswordHash(hashStr)
+
+	return s.CheckPasswordHash(ctx, hashStr)
 }
 
 // CheckPasswordHash checks if a password hash has been compromised, using cache when possible
-func (s *Service) CheckPasswordHash(passwordHash string) (int, error) {
-	// Check cache first
-	if breachCount, found := s.cache.Get(passwordHash); found {
-		return breachCount, nil
-	}
-	
-	// Cache miss - check with HIBP API
-	s.logger.WithField("hash_prefix", passwordHash[:5]).Debug("cache miss, checking HIBP API")
-	
-	breachCount, err := s.client.CheckPasswordHash(passwordHash)
-	if err != nil {
-		return 0, err
-	}
-	
-	// Cache the result
-	s.cache.Set(passwordHash, breachCount)
-	
-	return breachCount, nil
+func (s *Service) CheckPasswordHash(ctx context.Context, passwordHash string) (int, error) {
+	breachCount, _, err := s.lookup(ctx, passwordHash)
+	return breachCount, err
 }
 
 // CheckPasswordWithResult returns detailed information about the password check
@@ -65,69 +53,129 @@ type CheckResult struct {
 }
 
 // CheckPasswordWithDetails checks a password and returns detailed results
-func (s *Service) CheckPasswordWithDetails(password string) (*CheckResult, error) {
+func (s *Service) CheckPasswordWithDetails(ctx context.Context, password string) (*CheckResult, error) {
 	// Generate SHA-1 hash of the password
 	hash := sha1.Sum([]byte(password))
 	hashStr := strings.ToUpper(hex.EncodeToString(hash[:]))
-	
-	return s.CheckPasswordHashWithDetails(hashStr)
+
+	return s.CheckPasswordHashWithDetails(ctx, hashStr)
 }
 
 // CheckPasswordHashWithDetails checks a password hash and returns detailed results
-func (s *Service) CheckPasswordHashWithDetails(passwordHash string) (*CheckResult, error) {
-	result := &CheckResult{
+func (s *Service) CheckPasswordHashWithDetails(ctx context.Context, passwordHash string) (*CheckResult, error) {
+	breachCount, fromCache, err := s.lookup(ctx, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckResult{
 		PasswordHash: passwordHash,
+		BreachCount:  breachCount,
+		IsBreached:   breachCount > 0,
 		CheckedAt:    time.Now(),
+		FromCache:    fromCache,
+	}, nil
+}
+
+// lookup returns the breach count of a hash and whether its range came from cache
+func (s *Service) lookup(ctx context.Context, passwordHash string) (int, bool, error) {
+	if len(passwordHash) != 40 {
+		return 0, false, fmt.Errorf("invalid hash length: expected 40 characters, got %d", len(passwordHash))
+	}
+
+	rangeSource, ok := s.source.(RangeSource)
+	if !ok {
+		// local sources are fast enough without a cache
+		breachCount, err := s.source.CheckPasswordHash(ctx, passwordHash)
+		return breachCount, false, err
 	}
-	
-	// Check cache first
-	if breachCount, found := s.cache.Get(passwordHash); found {
-		result.BreachCount = breachCount
-		result.IsBreached = breachCount > 0
-		result.FromCache = true
-		return result, nil
+
+	passwordHash = strings.ToUpper(passwordHash)
+	prefix := passwordHash[:5]
+
+	body, fromCache := s.cache.Get(ctx, prefix)
+	if !fromCache {
+		// Cache miss - check with the breach source
+		s.logger.WithField("hash_prefix", prefix).Debug("cache miss, checking breach source")
+
+		fetched, err := rangeSource.GetRange(ctx, prefix)
+		if err != nil {
+			return 0, false, err
+		}
+
+		body = string(stripPadding(fetched))
+		s.cache.Set(ctx, prefix, body)
 	}
-	
-	// Cache miss - check with HIBP API
-	s.logger.WithField("hash_prefix", passwordHash[:5]).Debug("cache miss, checking HIBP API")
-	
-	breachCount, err := s.client.CheckPasswordHash(passwordHash)
+
+	breachCount, err := findSuffix(strings.NewReader(body), passwordHash[5:])
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
-	
-	// Cache the result
-	s.cache.Set(passwordHash, breachCount)
-	
-	result.BreachCount = breachCount
-	result.IsBreached = breachCount > 0
-	result.FromCache = false
-	
-	return result, nil
+
+	return breachCount, fromCache, nil
 }
 
-// BatchCheckPasswordHashes checks multiple password hashes
-func (s *Service) BatchCheckPasswordHashes(passwordHashes []string) (map[string]*CheckResult, error) {
+// BatchCheckPasswordHashes checks multiple password hashes with up to concurrency lookups in flight.
+// Hashes that could not be checked have a BreachCount of -1. When ctx is cancelled no new lookups
+// are started and the results so far are returned together with the context error.
+func (s *Service) BatchCheckPasswordHashes(ctx context.Context, passwordHashes []string, concurrency int) (map[string]*CheckResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
 	results := make(map[string]*CheckResult)
-	
-	for _, hash := range passwordHashes {
-		result, err := s.CheckPasswordHashWithDetails(hash)
-		if err != nil {
-			s.logger.WithError(err).WithField("hash_prefix", hash[:5]).Error("failed to check password hash")
-			// Continue with other hashes even if one fails
-			results[hash] = &CheckResult{
-				PasswordHash: hash,
-				BreachCount:  -1, // Indicate error
-				IsBreached:   false,
-				CheckedAt:    time.Now(),
-				FromCache:    false,
+	var resultsMutex sync.Mutex
+
+	hashes := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for hash := range hashes {
+				result, err := s.CheckPasswordHashWithDetails(ctx, hash)
+				if err != nil {
+					s.logger.WithError(err).WithField("hash_prefix", hash[:5]).Error("failed to check password hash")
+					// Continue with other hashes even if one fails
+					result = &CheckResult{
+						PasswordHash: hash,
+						BreachCount:  -1, // Indicate error
+						IsBreached:   false,
+						CheckedAt:    time.Now(),
+						FromCache:    false,
+					}
+				}
+
+				resultsMutex.Lock()
+				results[hash] = result
+				resultsMutex.Unlock()
 			}
-			continue
+		}()
+	}
+
+	var err error
+
+dispatch:
+	for _, hash := range passwordHashes {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		case hashes <- hash:
 		}
-		results[hash] = result
 	}
-	
-	return results, nil
+
+	close(hashes)
+	wg.Wait()
+
+	return results, err
+}
+
+// Close stops the background work of the service
+func (s *Service) Close() {
+	s.cache.Close()
 }
 
 // GetCacheStats returns cache statistics
@@ -141,8 +189,8 @@ func (s *Service) ClearCache() {
 }
 
 // IsPasswordBreached is a convenience method that returns true if password is breached
-func (s *Service) IsPasswordBreached(password string) (bool, error) {
-	count, err := s.CheckPassword(password)
+func (s *Service) IsPasswordBreached(ctx context.Context, password string) (bool, error) {
+	count, err := s.CheckPassword(ctx, password)
 	if err != nil {
 		return false, err
 	}
@@ -150,10 +198,10 @@ func (s *Service) IsPasswordBreached(password string) (bool, error) {
 }
 
 // IsPasswordHashBreached is a convenience method that returns true if password hash is breached
-func (s *Service) IsPasswordHashBreached(passwordHash string) (bool, error) {
-	count, err := s.CheckPasswordHash(passwordHash)
+func (s *Service) IsPasswordHashBreached(ctx context.Context, passwordHash string) (bool, error) {
+	count, err := s.CheckPasswordHash(ctx, passwordHash)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,86 @@
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	SourceAPI     = "api"
+	SourceDataset = "dataset"
+)
+
+// Source looks up how often a password hash was seen in breaches
+type Source interface {
+	// CheckPasswordHash returns the breach count of a SHA-1 hash in hex format, 0 if it was never seen
+	CheckPasswordHash(ctx context.Context, hash string) (int, error)
+}
+
+// RangeSource is a source that serves whole ranges of SUFFIX:COUNT lines for a 5 character prefix,
+// which the service caches so one lookup serves every hash sharing the prefix
+type RangeSource interface {
+	Source
+	GetRange(ctx context.Context, prefix string) ([]byte, error)
+}
+
+// GetSource returns the breach source by name, the HIBP API is used when none is set
+func GetSource(logger *logrus.Logger, sourceName string, datasetPath string, clientOptions ClientOptions) (Source, error) {
+	switch strings.ToLower(sourceName) {
+	case "", SourceAPI:
+		return NewClient(logger, clientOptions), nil
+	case SourceDataset:
+		dataset, err := NewDataset(logger, datasetPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open HIBP dataset: %v", err)
+		}
+		return dataset, nil
+	default:
+		return nil, fmt.Errorf("unknown HIBP source: %s", sourceName)
+	}
+}
+
+// findSuffix scans a range response of SUFFIX:COUNT lines for a hash suffix.
+// Padding entries have a count of 0, so a padded match correctly reads as not breached.
+func findSuffix(r io.Reader, suffix string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineSuffix, countStr, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse breach count: %w", err)
+		}
+
+		return count, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read range: %w", err)
+	}
+
+	return 0, nil
+}
+
+// stripPadding removes the zero-count entries added by the Add-Padding header
+func stripPadding(body []byte) []byte {
+	stripped := make([]byte, 0, len(body))
+
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		_, count, found := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+		if !found || bytes.Equal(count, []byte("0")) {
+			continue
+		}
+		stripped = append(stripped, line...)
+	}
+
+	return stripped
+}
@@ -0,0 +1,82 @@
+package hibp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripPadding(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "padded",
+			body: "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n",
+			want: "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n",
+		},
+		{
+			name: "padding on the last line without line ending",
+			body: "0018A45C4D1DEF81644B54AB7F969B88D65:3\n00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0",
+			want: "0018A45C4D1DEF81644B54AB7F969B88D65:3\n",
+		},
+		{
+			name: "count ending in zero",
+			body: "0018A45C4D1DEF81644B54AB7F969B88D65:10\n",
+			want: "0018A45C4D1DEF81644B54AB7F969B88D65:10\n",
+		},
+		{
+			name: "only padding",
+			body: "00D4F6E8FA6EECAD2A3AA415EEC418D38EC:0\r\n",
+			want: "",
+		},
+		{
+			name: "blank and malformed lines",
+			body: "\n0018A45C4D1DEF81644B54AB7F969B88D65:3\ngarbage\n",
+			want: "0018A45C4D1DEF81644B54AB7F969B88D65:3\n",
+		},
+		{
+			name: "empty",
+			body: "",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(stripPadding([]byte(tt.body))); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSuffix(t *testing.T) {
+	body := "0018A45C4D1DEF81644B54AB7F969B88D65:3\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n"
+
+	tests := []struct {
+		name    string
+		body    string
+		suffix  string
+		want    int
+		wantErr bool
+	}{
+		{name: "found", body: body, suffix: "011053FD0102E94D6AE2F8B83D76FAF94F6", want: 1},
+		{name: "lowercase suffix", body: body, suffix: "0018a45c4d1def81644b54ab7f969b88d65", want: 3},
+		{name: "not found", body: body, suffix: "00D4F6E8FA6EECAD2A3AA415EEC418D38EC"},
+		{name: "invalid count", body: "0018A45C4D1DEF81644B54AB7F969B88D65:many\n", suffix: "0018A45C4D1DEF81644B54AB7F969B88D65", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findSuffix(strings.NewReader(tt.body), tt.suffix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got count %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,12 @@ package login
 import (
 	"encoding/json"
 	"github.com/asaskevich/govalidator"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/domainname"
 	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/fingerprint"
 	"github.com/hazcod/shade/pkg/service/hibp"
+	"github.com/hazcod/shade/pkg/service/middleware"
 	"github.com/hazcod/shade/pkg/storage"
 	"github.com/sirupsen/logrus"
 	"net"
@@ -14,36 +18,18 @@ import (
 )
 
 type loginData struct {
-	Domain       string    `json:"domain" valid:"required"`
-	Username     string    `json:"username" valid:"required"`
-	Hash         string    `json:"hash" valid:"required"`
-	DeviceID     string    `json:"device_id" valid:"required"`
-	CapturedTime time.Time `json:"captured_time"`
-	HasMFA       bool      `json:"hasMFA"`
-	MFAType      string    `json:"mfaType"`
-}
-
-// getClientIP extracts the real client IP from the HTTP request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for proxies/load balancers)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		if ips := strings.Split(xff, ","); len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-	
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	Version  int    `json:"version"`
+	Domain   string `json:"domain" valid:"required"`
+	Username string `json:"username" valid:"required"`
+	// Hash is used to detect password reuse and never leaves the backend
+	Hash          string `json:"hash" valid:"required"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	// BreachHash is only used for breach lookups
+	BreachHash   *breachHash `json:"breach_hash"`
+	DeviceID     string      `json:"device_id" valid:"required"`
+	CapturedTime time.Time   `json:"captured_time"`
+	HasMFA       bool        `json:"hasMFA"`
+	MFAType      string      `json:"mfaType"`
 }
 
 // getHostnameFromIP attempts to resolve hostname from IP address
@@ -55,9 +41,7 @@ func getHostnameFromIP(ip string) string {
 	return strings.TrimSuffix(names[0], ".")
 }
 
-func HandleLoginData(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
-	// Initialize HIBP service
-	hibpService := hibp.NewService(logger)
+func HandleLoginData(logger *logrus.Logger, store storage.Driver, hibpQueue *hibp.Queue, keyring *fingerprint.Keyring, appCatalog *catalog.Catalog) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -72,73 +56,126 @@ func HandleLoginData(logger *logrus.Logger, store storage.Driver) http.HandlerFu
 
 		valid, err := govalidator.ValidateStruct(data)
 		if !valid || err != nil {
-			logger.WithError(err).WithField("body", data).Error("endpoint data validation failed")
+			// the payload holds password hashes, which must never end up in the logs
+			logger.WithError(err).WithFields(logrus.Fields{
+				"device_id": data.DeviceID,
+				"domain":    data.Domain,
+				"version":   data.Version,
+			}).Error("endpoint data validation failed")
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
+		// the credential is bound to a single device, it may not submit events for others
+		if deviceID := middleware.DeviceID(r.Context()); data.DeviceID != deviceID {
+			logger.WithFields(logrus.Fields{
+				"ip":                middleware.GetClientIP(r),
+				"device_id":         deviceID,
+				"claimed_device_id": data.DeviceID,
+			}).Warn("device credential used for another device")
+			middleware.WriteError(logger, w, http.StatusForbidden, "device mismatch")
+			return
+		}
+
+		breachHashValue, err := data.validateHashes()
+		if err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				"device_id": data.DeviceID,
+				"version":   data.Version,
+			}).Warn("rejected credential payload")
+			middleware.WriteError(logger, w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// only keyed values are stored, a dump of the store is useless without the fingerprint key
+		passwordFingerprint := keyring.Fingerprint(data.Hash)
+
+		storedBreachHash := ""
+		if breachHashValue != "" {
+			if storedBreachHash, err = keyring.EncryptBreachHash(breachHashValue); err != nil {
+				logger.WithError(err).Error("failed to encrypt breach hash")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Set capture time if not provided
 		if data.CapturedTime.IsZero() {
 			data.CapturedTime = time.Now()
 		}
 
-		data.Domain = strings.ToLower(data.Domain)
+		// group logins on the registrable domain, keeping the visited host and its tenant as detail
+		name := domainname.Normalize(data.Domain)
+		data.Domain = name.Domain
 		data.Username = strings.ToLower(data.Username)
 
 		// Extract real client IP and hostname
-		clientIP := getClientIP(r)
+		clientIP := middleware.GetClientIP(r)
 		hostname := getHostnameFromIP(clientIP)
 
 		loginEvent := events.LoginEvent{
-			Timestamp: data.CapturedTime,
-			User:      data.Username,
-			Domain:    data.Domain,
-			Hash:      data.Hash,
-			DeviceID:  data.DeviceID,
-			IP:        clientIP,
-			Hostname:  hostname,
-			HasMFA:    data.HasMFA,
-			MFAType:   data.MFAType,
+			Timestamp:  data.CapturedTime,
+			User:       data.Username,
+			Domain:     data.Domain,
+			Host:       name.Host,
+			Tenant:     name.Tenant,
+			Hash:       passwordFingerprint,
+			BreachHash: storedBreachHash,
+			DeviceID:   data.DeviceID,
+			IP:         clientIP,
+			Hostname:   hostname,
+			HasMFA:     data.HasMFA,
+			MFAType:    data.MFAType,
 		}
 
-		// Check password against HIBP
-		breachCount, hibpErr := hibpService.CheckPasswordHash(data.Hash)
-		hibpChecked := hibpErr == nil
-		
-		if hibpErr != nil {
-			logger.WithError(hibpErr).WithField("hash_prefix", data.Hash[:5]).Warn("failed to check password against HIBP")
-			// Continue processing even if HIBP check fails
-		} else {
-			// Store HIBP result in database
-			if storeErr := store.StoreHIBPResult(data.Hash, breachCount); storeErr != nil {
-				logger.WithError(storeErr).WithField("hash_prefix", data.Hash[:5]).Warn("failed to store HIBP result")
-			}
-			
-			if breachCount > 0 {
-				logger.WithFields(logrus.Fields{
-					"username": data.Username,
-					"domain": data.Domain,
-					"breach_count": breachCount,
-				}).Info("password found in HIBP database")
-			}
-		}
-
-		// Store the login data
-		if err := store.AddLoginEvent(loginEvent); err != nil {
-			logger.WithError(err).WithField("body", data).Error("store add failed")
+		// Store the login data before anything else, so a slow breach lookup cannot lose it
+		if err := store.AddLoginEvent(r.Context(), loginEvent); err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				"device_id": data.DeviceID,
+				"domain":    data.Domain,
+				"version":   data.Version,
+			}).Error("store add failed")
 			http.Error(w, "failed to store", http.StatusInternalServerError)
 			return
 		}
 
-		// Prepare response with HIBP information
+		// Known passwords are answered right away, others are checked in the background.
+		// Failures do not affect the stored event, the scheduled re-check picks it up.
+		hibpResponse := map[string]interface{}{
+			"checked":      false,
+			"pending":      false,
+			"breached":     false,
+			"breach_count": 0,
+		}
+
+		if breachHashValue == "" {
+			hibpResponse["error"] = "payload version does not support breach checks"
+		} else if result, found, err := store.GetHIBPResult(r.Context(), passwordFingerprint); err != nil {
+			logger.WithError(err).WithField("device_id", data.DeviceID).Warn("failed to retrieve HIBP result")
+			hibpResponse["error"] = "breach lookup failed"
+		} else if found {
+			hibpResponse["checked"] = true
+			hibpResponse["breached"] = result.BreachCount > 0
+			hibpResponse["breach_count"] = result.BreachCount
+		} else if hibpQueue.Enqueue(hibp.Job{
+			Fingerprint: passwordFingerprint,
+			BreachHash:  breachHashValue,
+			DeviceID:    data.DeviceID,
+			Domain:      data.Domain,
+			Username:    data.Username,
+		}) {
+			hibpResponse["pending"] = true
+		} else {
+			hibpResponse["error"] = "breach check queue is full"
+		}
+
+		// Prepare response with HIBP information, including verdicts of earlier submissions
 		response := map[string]interface{}{
-			"status":  "success",
-			"message": "Login data stored successfully",
-			"hibp": map[string]interface{}{
-				"checked": hibpChecked,
-				"breached": hibpChecked && breachCount > 0,
-				"breach_count": breachCount,
-			},
+			"status":      "success",
+			"message":     "Login data stored successfully",
+			"hibp":        hibpResponse,
+			"verdicts":    takeVerdicts(r.Context(), logger, store, data.DeviceID),
+			"enforcement": enforce(r.Context(), logger, store, appCatalog, loginEvent),
 		}
 
 		// Return success response
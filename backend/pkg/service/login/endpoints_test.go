@@ -0,0 +1,283 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/service/hibp"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDevice = "device"
+
+var (
+	reuseHash  = sha512Hex("hunter2")
+	breachSum  = sha1Hex("hunter2")
+	testSecret = map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"}
+)
+
+func sha512Hex(value string) string {
+	sum := sha512.Sum512([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// sha1Hex returns the uppercase SHA-1 as looked up in HIBP
+func sha1Hex(value string) string {
+	sum := sha1.Sum([]byte(value))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// recordingStore records the stored login events and fails storing them when err is set
+type recordingStore struct {
+	storage.Driver
+	logins []events.LoginEvent
+	err    error
+}
+
+func (s *recordingStore) AddLoginEvent(ctx context.Context, event events.LoginEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.logins = append(s.logins, event)
+	return s.Driver.AddLoginEvent(ctx, event)
+}
+
+type testServer struct {
+	handler http.Handler
+	store   *recordingStore
+	keyring *fingerprint.Keyring
+	queue   *hibp.Queue
+	logs    *bytes.Buffer
+}
+
+// newTestServer returns the login handler behind device authentication, with a queue that is never started
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	logs := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(logs)
+
+	memoryStore := &memory.InMemoryStore{}
+	if err := memoryStore.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	store := &recordingStore{Driver: memoryStore}
+
+	if err := store.EnrollDevice(context.Background(), testDevice, middleware.HashCredential("token"), ""); err != nil {
+		t.Fatalf("failed to enroll device: %v", err)
+	}
+
+	keyring, err := fingerprint.NewKeyring(testSecret)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	queue := hibp.NewQueue(logger, nil, store, 1, 1)
+	handler := middleware.RequireDeviceCredential(logger, store)(HandleLoginData(logger, store, queue, keyring, appCatalog))
+
+	return &testServer{handler: handler, store: store, keyring: keyring, queue: queue, logs: logs}
+}
+
+func (s *testServer) post(t *testing.T, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/creds/register", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(rec, req)
+
+	response := make(map[string]interface{})
+	if rec.Code == http.StatusCreated {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+
+	return rec.Code, response
+}
+
+func TestHandleLoginData(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantPending bool
+		wantBreach  bool
+	}{
+		{
+			name:        "split breach hash",
+			body:        `{"version":2,"domain":"https://acme.slack.com","username":"Alice","hash":"` + reuseHash + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","prefix":"` + breachSum[:5] + `","suffix":"` + breachSum[5:] + `"},"device_id":"device"}`,
+			wantStatus:  http.StatusCreated,
+			wantPending: true,
+			wantBreach:  true,
+		},
+		{
+			name:        "whole lowercase breach hash",
+			body:        `{"version":2,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","hash":"` + strings.ToLower(breachSum) + `"},"device_id":"device"}`,
+			wantStatus:  http.StatusCreated,
+			wantPending: true,
+			wantBreach:  true,
+		},
+		{
+			name:       "legacy payload without breach hash",
+			body:       `{"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","device_id":"device"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "missing breach hash",
+			body:       `{"version":2,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","hash_algorithm":"sha512","device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported hash algorithm",
+			body:       `{"version":2,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","hash_algorithm":"sha1","breach_hash":{"algorithm":"sha1","hash":"` + breachSum + `"},"device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported breach hash algorithm",
+			body:       `{"version":2,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"md5","hash":"` + breachSum + `"},"device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "short breach hash prefix",
+			body:       `{"version":2,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","prefix":"` + breachSum[:4] + `","suffix":"` + breachSum[4:] + `"},"device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "reuse hash of the wrong length",
+			body:       `{"version":2,"domain":"slack.com","username":"alice","hash":"` + breachSum + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","hash":"` + breachSum + `"},"device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown payload version",
+			body:       `{"version":3,"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing username",
+			body:       `{"version":2,"domain":"slack.com","hash":"` + reuseHash + `","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","hash":"` + breachSum + `"},"device_id":"device"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "login of another device",
+			body:       `{"domain":"slack.com","username":"alice","hash":"` + reuseHash + `","device_id":"other"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "malformed JSON",
+			body:       `{"domain":`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+
+			status, response := server.post(t, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", status, tt.wantStatus)
+			}
+
+			// password hashes must never be logged, whatever the outcome
+			for _, secret := range []string{reuseHash, breachSum, breachSum[5:], strings.ToLower(breachSum)} {
+				if strings.Contains(server.logs.String(), secret) {
+					t.Fatalf("password hash logged: %s", server.logs.String())
+				}
+			}
+
+			if status != http.StatusCreated {
+				if len(server.store.logins) != 0 {
+					t.Fatalf("rejected login stored: %+v", server.store.logins)
+				}
+				return
+			}
+
+			result := response["hibp"].(map[string]interface{})
+			if result["pending"] != tt.wantPending {
+				t.Errorf("got hibp result %v, want pending %v", result, tt.wantPending)
+			}
+
+			if len(server.store.logins) != 1 {
+				t.Fatalf("stored %d logins, want 1", len(server.store.logins))
+			}
+			stored := server.store.logins[0]
+
+			if stored.User != "alice" || stored.Domain != "slack.com" {
+				t.Errorf("login stored as %s on %s, want alice on slack.com", stored.User, stored.Domain)
+			}
+			if stored.Hash != server.keyring.Fingerprint(reuseHash) {
+				t.Errorf("reuse hash stored as %s, want its fingerprint", stored.Hash)
+			}
+
+			if !tt.wantBreach {
+				if stored.BreachHash != "" {
+					t.Errorf("legacy login stored breach hash %s", stored.BreachHash)
+				}
+				return
+			}
+
+			if breachHash, err := server.keyring.DecryptBreachHash(stored.BreachHash); err != nil || breachHash != breachSum || stored.BreachHash == breachSum {
+				t.Errorf("breach hash not stored encrypted: %s, %v", stored.BreachHash, err)
+			}
+		})
+	}
+}
+
+func TestHandleLoginDataStoreFailure(t *testing.T) {
+	server := newTestServer(t)
+	server.store.err = errors.New("database unavailable")
+
+	status, _ := server.post(t, `{"version":2,"domain":"slack.com","username":"alice","hash":"`+reuseHash+`","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","hash":"`+breachSum+`"},"device_id":"device"}`)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	logs := server.logs.String()
+	if !strings.Contains(logs, "store add failed") {
+		t.Fatalf("store failure not logged: %s", logs)
+	}
+	if strings.Contains(logs, reuseHash) || strings.Contains(logs, breachSum) {
+		t.Errorf("password hash logged: %s", logs)
+	}
+}
+
+func TestHandleLoginDataKnownResult(t *testing.T) {
+	server := newTestServer(t)
+
+	if err := server.store.StoreHIBPResult(context.Background(), server.keyring.Fingerprint(reuseHash), 42); err != nil {
+		t.Fatalf("failed to store HIBP result: %v", err)
+	}
+
+	status, response := server.post(t, `{"version":2,"domain":"slack.com","username":"alice","hash":"`+reuseHash+`","hash_algorithm":"sha512","breach_hash":{"algorithm":"sha1","hash":"`+breachSum+`"},"device_id":"device"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+
+	result := response["hibp"].(map[string]interface{})
+	if result["checked"] != true || result["breached"] != true || result["breach_count"] != float64(42) || result["pending"] != false {
+		t.Errorf("got hibp result %v, want the stored breach count", result)
+	}
+}
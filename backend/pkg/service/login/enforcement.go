@@ -0,0 +1,142 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// defaultEnforcementMessages are shown when the policy does not set a message for the action
+var defaultEnforcementMessages = map[models.EnforcementAction]string{
+	models.EnforcementWarn:  "{app} is not approved by your organization. Do not use it for company data.",
+	models.EnforcementBlock: "Logging in to {app} is blocked by your organization.",
+}
+
+// enforcement tells the extension what to do with a login
+type enforcement struct {
+	Action  models.EnforcementAction `json:"action"`
+	Message string                   `json:"message,omitempty"`
+	App     string                   `json:"app,omitempty"`
+	// reason is recorded for administrators, users only get the message
+	reason string
+}
+
+// matchPolicyDomain returns the policy domain a host is equal to or a subdomain of
+func matchPolicyDomain(host string, policyDomains []string) (string, bool) {
+	for _, policyDomain := range policyDomains {
+		if host == policyDomain || strings.HasSuffix(host, "."+policyDomain) {
+			return policyDomain, true
+		}
+	}
+
+	return "", false
+}
+
+// appReview returns the review of the app a login belongs to.
+// The app of the host is more specific than the app of the registrable domain, so its review takes precedence.
+func appReview(reviews map[string]models.AppReview, appCatalog *catalog.Catalog, name domainname.Name) (string, models.AppReview) {
+	hostApp := appCatalog.Key(name.Host)
+	if review, found := reviews[hostApp]; found {
+		return hostApp, review
+	}
+
+	domainApp := appCatalog.Key(name.Domain)
+	if review, found := reviews[domainApp]; found {
+		return domainApp, review
+	}
+
+	return hostApp, models.AppReview{App: hostApp, Status: models.AppStatusNew}
+}
+
+// decideEnforcement applies the policy to a login. Blocking takes precedence over warning,
+// logins are blocked on a blocked domain or for an app reviewed as prohibited and warned about on a prohibited domain.
+func decideEnforcement(policy models.Policy, reviews map[string]models.AppReview, appCatalog *catalog.Catalog, name domainname.Name) enforcement {
+	app, review := appReview(reviews, appCatalog, name)
+	decision := enforcement{Action: models.EnforcementAllow, App: app}
+
+	if blocked, found := matchPolicyDomain(name.Host, policy.BlockedDomains); found {
+		decision.Action = models.EnforcementBlock
+		decision.reason = fmt.Sprintf("domain %s is blocked by the policy", blocked)
+	} else if review.Status == models.AppStatusProhibited {
+		decision.Action = models.EnforcementBlock
+		decision.reason = fmt.Sprintf("app %s is reviewed as prohibited", app)
+	} else if prohibited, found := matchPolicyDomain(name.Host, policy.ProhibitedDomains); found {
+		decision.Action = models.EnforcementWarn
+		decision.reason = fmt.Sprintf("domain %s is prohibited by the policy", prohibited)
+	} else {
+		return decision
+	}
+
+	message := defaultEnforcementMessages[decision.Action]
+	if decision.Action == models.EnforcementBlock && policy.Messages.Blocked != "" {
+		message = policy.Messages.Blocked
+	} else if decision.Action == models.EnforcementWarn && policy.Messages.Prohibited != "" {
+		message = policy.Messages.Prohibited
+	}
+
+	decision.Message = strings.NewReplacer("{domain}", name.Host, "{app}", app).Replace(message)
+
+	return decision
+}
+
+// enforce decides what the extension should do with a login and records the decision.
+// Detection must keep working when the policy cannot be read, so failures let the login through
+// without recording a decision, as none was made.
+func enforce(ctx context.Context, logger *logrus.Logger, store storage.Driver, appCatalog *catalog.Catalog, event events.LoginEvent) enforcement {
+	name := domainname.Name{Host: event.Host, Domain: event.Domain, Tenant: event.Tenant}
+	allow := enforcement{Action: models.EnforcementAllow, App: appCatalog.Key(event.Host)}
+
+	policy, _, err := store.GetPolicy(ctx)
+	if err != nil {
+		logger.WithError(err).Error("failed to retrieve policy for enforcement")
+		return allow
+	}
+
+	reviews, err := store.GetAppReviews(ctx)
+	if err != nil {
+		logger.WithError(err).Error("failed to retrieve app reviews for enforcement")
+		return allow
+	}
+
+	decision := decideEnforcement(policy, reviews, appCatalog, name)
+	if decision.Action == models.EnforcementAllow {
+		decision.reason = "no policy rule or review applies"
+	}
+
+	decisionLogger := logger.WithFields(logrus.Fields{
+		"device_id": event.DeviceID,
+		"host":      event.Host,
+		"app":       decision.App,
+		"action":    decision.Action,
+		"reason":    decision.reason,
+	})
+	if decision.Action == models.EnforcementAllow {
+		decisionLogger.Debug("enforced policy on login")
+	} else {
+		decisionLogger.Info("enforced policy on login")
+	}
+
+	enforcementEvent := events.EnforcementEvent{
+		Timestamp: event.Timestamp,
+		User:      event.User,
+		Domain:    event.Domain,
+		Host:      event.Host,
+		App:       decision.App,
+		DeviceID:  event.DeviceID,
+		Action:    decision.Action,
+		Reason:    decision.reason,
+		Message:   decision.Message,
+	}
+
+	if err := store.AddEnforcementEvent(ctx, enforcementEvent); err != nil {
+		logger.WithError(err).WithField("device_id", event.DeviceID).Error("failed to store enforcement event")
+	}
+
+	return decision
+}
@@ -0,0 +1,196 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDecideEnforcement(t *testing.T) {
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		policy      models.Policy
+		reviews     map[string]models.AppReview
+		host        string
+		wantAction  models.EnforcementAction
+		wantApp     string
+		wantMessage string
+	}{
+		{
+			name:       "no rules",
+			host:       "app.slack.com",
+			wantAction: models.EnforcementAllow,
+			wantApp:    "Slack",
+		},
+		{
+			name:        "blocked subdomain",
+			policy:      models.Policy{BlockedDomains: []string{"slack.com"}},
+			host:        "app.slack.com",
+			wantAction:  models.EnforcementBlock,
+			wantApp:     "Slack",
+			wantMessage: "Logging in to Slack is blocked by your organization.",
+		},
+		{
+			// a policy domain only matches on a label boundary
+			name:       "blocked suffix of another domain",
+			policy:     models.Policy{BlockedDomains: []string{"ack.com"}},
+			host:       "slack.com",
+			wantAction: models.EnforcementAllow,
+			wantApp:    "Slack",
+		},
+		{
+			name:        "prohibited domain with custom message",
+			policy:      models.Policy{ProhibitedDomains: []string{"dropbox.com"}, Messages: models.PolicyMessages{Prohibited: "Do not use {app} on {domain}"}},
+			host:        "www.dropbox.com",
+			wantAction:  models.EnforcementWarn,
+			wantApp:     "Dropbox",
+			wantMessage: "Do not use Dropbox on www.dropbox.com",
+		},
+		{
+			name:        "blocking takes precedence",
+			policy:      models.Policy{ProhibitedDomains: []string{"mega.nz"}, BlockedDomains: []string{"mega.nz"}, Messages: models.PolicyMessages{Blocked: "Blocked"}},
+			host:        "mega.nz",
+			wantAction:  models.EnforcementBlock,
+			wantApp:     "MEGA",
+			wantMessage: "Blocked",
+		},
+		{
+			name:        "reviewed as prohibited",
+			reviews:     map[string]models.AppReview{"Dropbox": {App: "Dropbox", Status: models.AppStatusProhibited}},
+			host:        "www.dropbox.com",
+			wantAction:  models.EnforcementBlock,
+			wantApp:     "Dropbox",
+			wantMessage: "Logging in to Dropbox is blocked by your organization.",
+		},
+		{
+			// a sanctioned app does not lift a policy rule
+			name:        "sanctioned app on a prohibited domain",
+			policy:      models.Policy{ProhibitedDomains: []string{"dropbox.com"}},
+			reviews:     map[string]models.AppReview{"Dropbox": {App: "Dropbox", Status: models.AppStatusSanctioned}},
+			host:        "www.dropbox.com",
+			wantAction:  models.EnforcementWarn,
+			wantApp:     "Dropbox",
+			wantMessage: "Dropbox is not approved by your organization. Do not use it for company data.",
+		},
+		{
+			name:       "unknown app",
+			reviews:    map[string]models.AppReview{"Dropbox": {App: "Dropbox", Status: models.AppStatusProhibited}},
+			host:       "intranet.example.org",
+			wantAction: models.EnforcementAllow,
+			wantApp:    "example.org",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decideEnforcement(tt.policy, tt.reviews, appCatalog, domainname.Normalize(tt.host))
+
+			if got.Action != tt.wantAction || got.App != tt.wantApp || got.Message != tt.wantMessage {
+				t.Errorf("got %+v, want action %s app %s message %q", got, tt.wantAction, tt.wantApp, tt.wantMessage)
+			}
+			if (got.reason == "") != (tt.wantAction == models.EnforcementAllow) {
+				t.Errorf("got reason %q for action %s", got.reason, got.Action)
+			}
+		})
+	}
+}
+
+// failingPolicyStore fails reading the policy
+type failingPolicyStore struct {
+	storage.Driver
+}
+
+func (failingPolicyStore) GetPolicy(context.Context) (models.Policy, bool, error) {
+	return models.Policy{}, false, errors.New("store unavailable")
+}
+
+func TestEnforce(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// failing makes reading the policy fail
+		failing    bool
+		host       string
+		wantAction models.EnforcementAction
+		wantEvent  bool
+	}{
+		{name: "allowed logins are recorded", host: "app.slack.com", wantAction: models.EnforcementAllow, wantEvent: true},
+		{name: "warned", host: "www.dropbox.com", wantAction: models.EnforcementWarn, wantEvent: true},
+		{name: "blocked", host: "mega.nz", wantAction: models.EnforcementBlock, wantEvent: true},
+		{name: "policy unavailable", failing: true, host: "mega.nz", wantAction: models.EnforcementAllow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memoryStore := &memory.InMemoryStore{}
+			if err := memoryStore.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+				t.Fatalf("failed to init store: %v", err)
+			}
+
+			policy := models.Policy{ProhibitedDomains: []string{"dropbox.com"}, BlockedDomains: []string{"mega.nz"}}
+			if _, err := memoryStore.StorePolicy(ctx, policy); err != nil {
+				t.Fatalf("failed to store policy: %v", err)
+			}
+
+			var store storage.Driver = memoryStore
+			if tt.failing {
+				store = failingPolicyStore{Driver: memoryStore}
+			}
+
+			name := domainname.Normalize(tt.host)
+			event := events.LoginEvent{
+				Timestamp: time.Now(),
+				User:      "alice",
+				Domain:    name.Domain,
+				Host:      name.Host,
+				DeviceID:  testDevice,
+			}
+
+			decision := enforce(ctx, logger, store, appCatalog, event)
+			if decision.Action != tt.wantAction {
+				t.Errorf("got action %s, want %s", decision.Action, tt.wantAction)
+			}
+
+			recorded, err := memoryStore.GetEnforcementEvents(ctx, models.Query{}, "")
+			if err != nil {
+				t.Fatalf("failed to get enforcement events: %v", err)
+			}
+			if !tt.wantEvent {
+				if len(recorded.Items) != 0 {
+					t.Errorf("got events %+v, want none", recorded.Items)
+				}
+				return
+			}
+
+			if len(recorded.Items) != 1 {
+				t.Fatalf("got %d events, want 1", len(recorded.Items))
+			}
+			got := recorded.Items[0]
+			if got.Action != tt.wantAction || got.User != "alice" || got.Host != tt.host || got.DeviceID != testDevice || got.Reason == "" || got.Message != decision.Message {
+				t.Errorf("got event %+v for decision %+v", got, decision)
+			}
+		})
+	}
+}
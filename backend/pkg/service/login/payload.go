@@ -0,0 +1,86 @@
+package login
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// payloadVersion is the credential payload sent by current extensions,
+	// older extensions send no version and only a reuse hash
+	payloadVersion = 2
+
+	hashAlgorithmSHA512     = "sha512"
+	breachHashAlgorithmSHA1 = "sha1"
+
+	sha512HexLength        = 128
+	sha1HexLength          = 40
+	breachHashPrefixLength = 5
+)
+
+// breachHash is the SHA-1 of the password as used by the HIBP range API.
+// It is either sent as a whole or split into the k-anonymity prefix and suffix.
+type breachHash struct {
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Suffix    string `json:"suffix,omitempty"`
+}
+
+// fullHash returns the uppercase SHA-1, validating the algorithm and length
+func (b breachHash) fullHash() (string, error) {
+	if b.Algorithm != breachHashAlgorithmSHA1 {
+		return "", fmt.Errorf("unsupported breach hash algorithm %q, expected %s", b.Algorithm, breachHashAlgorithmSHA1)
+	}
+
+	hash := b.Hash
+	if hash == "" {
+		if len(b.Prefix) != breachHashPrefixLength {
+			return "", fmt.Errorf("breach hash prefix must be %d characters, got %d", breachHashPrefixLength, len(b.Prefix))
+		}
+		hash = b.Prefix + b.Suffix
+	}
+
+	if len(hash) != sha1HexLength || !isHex(hash) {
+		return "", fmt.Errorf("breach hash is not a %s hex digest", breachHashAlgorithmSHA1)
+	}
+
+	return strings.ToUpper(hash), nil
+}
+
+// validateHashes checks that the hashes in the payload match their declared algorithms.
+// It returns the breach hash, which is empty for legacy payloads without one.
+func (l loginData) validateHashes() (string, error) {
+	switch l.Version {
+	case 0, 1:
+		// legacy payloads only carry a SHA-512 reuse hash, they cannot be checked against HIBP
+		if len(l.Hash) != sha512HexLength || !isHex(l.Hash) {
+			return "", fmt.Errorf("hash is not a %s hex digest", hashAlgorithmSHA512)
+		}
+		return "", nil
+
+	case payloadVersion:
+		if l.HashAlgorithm != hashAlgorithmSHA512 {
+			return "", fmt.Errorf("unsupported hash algorithm %q, expected %s", l.HashAlgorithm, hashAlgorithmSHA512)
+		}
+
+		if len(l.Hash) != sha512HexLength || !isHex(l.Hash) {
+			return "", fmt.Errorf("hash is not a %s hex digest", hashAlgorithmSHA512)
+		}
+
+		if l.BreachHash == nil {
+			return "", fmt.Errorf("breach_hash is required for payload version %d", payloadVersion)
+		}
+
+		return l.BreachHash.fullHash()
+
+	default:
+		return "", fmt.Errorf("unsupported payload version %d", l.Version)
+	}
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,60 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// verdict reports a password that was found breached after it was submitted
+type verdict struct {
+	Domain      string    `json:"domain"`
+	Username    string    `json:"username"`
+	BreachCount int       `json:"breach_count"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// takeVerdicts returns the breach verdicts of a device that were not reported yet
+func takeVerdicts(ctx context.Context, logger *logrus.Logger, store storage.Driver, deviceID string) []verdict {
+	stored, err := store.TakeHIBPVerdicts(ctx, deviceID)
+	if err != nil {
+		logger.WithError(err).WithField("device_id", deviceID).Error("failed to retrieve HIBP verdicts")
+		return []verdict{}
+	}
+
+	verdicts := make([]verdict, 0, len(stored))
+	for _, v := range stored {
+		verdicts = append(verdicts, verdict{
+			Domain:      v.Domain,
+			Username:    v.Username,
+			BreachCount: v.BreachCount,
+			CheckedAt:   v.CheckedAt,
+		})
+	}
+
+	return verdicts
+}
+
+// HandleVerdicts returns the breach verdicts of background checks for the authenticated device.
+// Verdicts are only returned once, which is why this is a POST.
+func HandleVerdicts(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response := map[string]interface{}{
+			"verdicts": takeVerdicts(r.Context(), logger, store, middleware.DeviceID(r.Context())),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.WithError(err).Error("Failed to write response")
+		}
+	}
+}
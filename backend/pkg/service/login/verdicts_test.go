@@ -0,0 +1,75 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleVerdicts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	srv := newTestServer(t)
+	handler := middleware.RequireDeviceCredential(logger, srv.store)(HandleVerdicts(logger, srv.store))
+
+	stored := []models.HIBPVerdict{
+		{DeviceID: testDevice, Domain: "slack.com", Username: "alice", BreachCount: 7, CheckedAt: time.Now()},
+		{DeviceID: "other", Domain: "github.com", Username: "bob", BreachCount: 3, CheckedAt: time.Now()},
+	}
+	for _, v := range stored {
+		if err := srv.store.AddHIBPVerdict(ctx, v); err != nil {
+			t.Fatalf("failed to add verdict: %v", err)
+		}
+	}
+
+	request := func(method string) (int, []verdict) {
+		req := httptest.NewRequest(method, "/api/verdicts", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		var response struct {
+			Verdicts []verdict `json:"verdicts"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+
+		return rec.Code, response.Verdicts
+	}
+
+	if status, _ := request(http.MethodGet); status != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for GET, want %d", status, http.StatusMethodNotAllowed)
+	}
+
+	// only the verdicts of the calling device are returned
+	status, verdicts := request(http.MethodPost)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+	if len(verdicts) != 1 || verdicts[0].Domain != "slack.com" || verdicts[0].Username != "alice" || verdicts[0].BreachCount != 7 {
+		t.Errorf("got verdicts %+v, want the slack.com verdict", verdicts)
+	}
+
+	// and only once
+	if _, verdicts := request(http.MethodPost); verdicts == nil || len(verdicts) != 0 {
+		t.Errorf("got verdicts %+v, want an empty list", verdicts)
+	}
+
+	remaining, err := srv.store.TakeHIBPVerdicts(ctx, "other")
+	if err != nil || len(remaining) != 1 {
+		t.Errorf("got %d verdicts of the other device, want 1: %v", len(remaining), err)
+	}
+}
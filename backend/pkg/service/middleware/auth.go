@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	deviceIDKey   contextKey = "device_id"
+	signingKeyKey contextKey = "signing_key"
+)
+
+type errorResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// HashCredential returns the representation of a device credential that is persisted
+func HashCredential(credential string) string {
+	hash := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(hash[:])
+}
+
+// BearerToken returns the bearer token of the Authorization header
+func BearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// WriteError writes a JSON error response in the format the extension expects
+func WriteError(logger *logrus.Logger, w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(errorResponse{Status: "error", Message: message}); err != nil {
+		logger.WithError(err).Error("Failed to write response")
+	}
+}
+
+// DeviceID returns the device authenticated by RequireDeviceCredential
+func DeviceID(ctx context.Context) string {
+	deviceID, _ := ctx.Value(deviceIDKey).(string)
+	return deviceID
+}
+
+// RequireDeviceCredential only lets through requests carrying a valid, non-revoked device credential
+func RequireDeviceCredential(logger *logrus.Logger, store storage.Driver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			failLogger := logger.WithFields(logrus.Fields{
+				"ip":         GetClientIP(r),
+				"path":       r.URL.Path,
+				"user_agent": r.UserAgent(),
+			})
+
+			token := BearerToken(r)
+			if token == "" {
+				failLogger.Warn("missing API token")
+				WriteError(logger, w, http.StatusUnauthorized, "missing token")
+				return
+			}
+
+			deviceID, valid, err := store.GetDeviceForCredential(r.Context(), HashCredential(token))
+			if err != nil {
+				logger.WithError(err).Error("failed to validate device credential")
+				WriteError(logger, w, http.StatusInternalServerError, "internal error")
+				return
+			}
+
+			if !valid {
+				failLogger.Warn("invalid API token")
+				WriteError(logger, w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), deviceIDKey, deviceID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// RequireClientCertificate only lets through requests that presented a client certificate verified during the TLS handshake
+func RequireClientCertificate(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				logger.WithFields(logrus.Fields{
+					"ip":   GetClientIP(r),
+					"path": r.URL.Path,
+				}).Warn("missing client certificate")
+				WriteError(logger, w, http.StatusUnauthorized, "client certificate required")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
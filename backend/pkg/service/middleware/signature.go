@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderTimestamp = "X-Shade-Timestamp"
+	HeaderNonce     = "X-Shade-Nonce"
+	HeaderSignature = "X-Shade-Signature"
+
+	// DefaultMaxClockSkew is how far a request timestamp may deviate from our clock
+	DefaultMaxClockSkew = 5 * time.Minute
+
+	// responseMethod takes the place of the request method in response signatures
+	responseMethod = "RESPONSE"
+
+	maxSignedBodySize = 1 << 20
+	minNonceLength    = 16
+)
+
+// nonceSweeper deletes expired nonces from the store once per ttl instead of running a cleanup goroutine
+type nonceSweeper struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+func (n *nonceSweeper) sweep(ctx context.Context, logger *logrus.Logger, store storage.Driver) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(n.lastSweep) <= n.ttl {
+		return
+	}
+	n.lastSweep = now
+
+	if err := store.DeleteExpiredNonces(ctx, now); err != nil {
+		logger.WithError(err).Warn("failed to delete expired nonces")
+	}
+}
+
+// SigningKey returns the request signing key of the device, loaded by VerifySignature
+func SigningKey(ctx context.Context) string {
+	signingKey, _ := ctx.Value(signingKeyKey).(string)
+	return signingKey
+}
+
+// SignaturePayload returns the string a request signature is computed over, target is as returned by SignedTarget
+func SignaturePayload(method, target, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return method + "\n" + target + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])
+}
+
+// SignedTarget returns the path and query of a request URL, so neither can be changed without breaking the signature
+func SignedTarget(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	return u.Path + "?" + u.RawQuery
+}
+
+// ComputeSignature signs the payload with the signing key of a device
+func ComputeSignature(key, payload string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignResponse signs a response body for the device that made the request.
+// The signature covers the timestamp and nonce of the request, so a response cannot be replayed to another request.
+func SignResponse(w http.ResponseWriter, r *http.Request, body []byte) {
+	key := SigningKey(r.Context())
+	if key == "" {
+		return
+	}
+
+	payload := SignaturePayload(responseMethod, SignedTarget(r.URL), r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), body)
+	w.Header().Set(HeaderSignature, ComputeSignature(key, payload))
+}
+
+// VerifySignature checks the HMAC signature of requests authenticated by RequireDeviceCredential.
+// The signing key is a separate secret handed out once on enrollment, so it is never sent along with requests
+// and cannot be derived from the stored credential hash. Nonces are kept in the store, so a replay is detected
+// by every replica. Unsigned requests are only let through when required is false.
+func VerifySignature(logger *logrus.Logger, store storage.Driver, keyring *fingerprint.Keyring, required bool, maxClockSkew time.Duration) func(http.Handler) http.Handler {
+	if maxClockSkew <= 0 {
+		maxClockSkew = DefaultMaxClockSkew
+	}
+
+	// a nonce only has to be remembered for as long as its timestamp is accepted
+	nonceTTL := 2 * maxClockSkew
+	sweeper := &nonceSweeper{ttl: nonceTTL, lastSweep: time.Now()}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deviceID := DeviceID(r.Context())
+			failLogger := logger.WithFields(logrus.Fields{
+				"ip":        GetClientIP(r),
+				"path":      r.URL.Path,
+				"device_id": deviceID,
+			})
+
+			storedKey, err := store.GetDeviceSigningKey(r.Context(), deviceID)
+			if err != nil {
+				logger.WithError(err).Error("failed to get device signing key")
+				WriteError(logger, w, http.StatusInternalServerError, "internal error")
+				return
+			}
+
+			var key string
+			if storedKey != "" {
+				if key, err = keyring.DecryptSigningKey(storedKey); err != nil {
+					logger.WithError(err).WithField("device_id", deviceID).Error("failed to decrypt device signing key")
+					WriteError(logger, w, http.StatusInternalServerError, "internal error")
+					return
+				}
+
+				r = r.WithContext(context.WithValue(r.Context(), signingKeyKey, key))
+			}
+
+			signature := r.Header.Get(HeaderSignature)
+			if signature == "" {
+				if required {
+					failLogger.Warn("missing request signature")
+					WriteError(logger, w, http.StatusUnauthorized, "missing signature")
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timestamp := r.Header.Get(HeaderTimestamp)
+			nonce := r.Header.Get(HeaderNonce)
+
+			if err := checkTimestamp(timestamp, maxClockSkew); err != nil {
+				failLogger.WithError(err).Warn("stale request signature")
+				WriteError(logger, w, http.StatusUnauthorized, "invalid timestamp")
+				return
+			}
+
+			if len(nonce) < minNonceLength {
+				failLogger.Warn("invalid request nonce")
+				WriteError(logger, w, http.StatusUnauthorized, "invalid nonce")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+			if err != nil {
+				WriteError(logger, w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if key == "" {
+				failLogger.Warn("signed request of a device without signing key")
+				WriteError(logger, w, http.StatusUnauthorized, "missing signing key")
+				return
+			}
+
+			expected := ComputeSignature(key, SignaturePayload(r.Method, SignedTarget(r.URL), timestamp, nonce, body))
+
+			if !hmac.Equal([]byte(expected), []byte(signature)) {
+				failLogger.Warn("invalid request signature")
+				WriteError(logger, w, http.StatusUnauthorized, "invalid signature")
+				return
+			}
+
+			sweeper.sweep(r.Context(), logger, store)
+
+			// only remember nonces of valid signatures, so they cannot be used to block legitimate requests
+			fresh, err := store.UseNonce(r.Context(), deviceID+":"+nonce, time.Now().Add(nonceTTL))
+			if err != nil {
+				logger.WithError(err).Error("failed to store request nonce")
+				WriteError(logger, w, http.StatusInternalServerError, "internal error")
+				return
+			}
+
+			if !fresh {
+				failLogger.Warn("replayed request")
+				WriteError(logger, w, http.StatusUnauthorized, "replayed request")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkTimestamp verifies that a unix timestamp lies within the allowed clock skew
+func checkTimestamp(timestamp string, maxClockSkew time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+	}
+
+	// far off timestamps, such as milliseconds, saturate the duration, which would overflow when negated
+	skew := time.Since(time.Unix(seconds, 0)).Abs()
+
+	if skew > maxClockSkew {
+		return fmt.Errorf("timestamp is %s off", skew.Round(time.Second))
+	}
+
+	return nil
+}
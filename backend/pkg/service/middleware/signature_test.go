@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testDevice     = "device"
+	testSigningKey = "signing-key"
+)
+
+func TestComputeSignature(t *testing.T) {
+	// the extension computes the same signatures, so these values must never change
+	tests := []struct {
+		name        string
+		payload     string
+		wantPayload string
+		want        string
+	}{
+		{
+			name:        "request",
+			payload:     SignaturePayload(http.MethodPost, "/api/login", "1700000000", "nonce-0123456789", []byte(`{"a":1}`)),
+			wantPayload: "POST\n/api/login\n1700000000\nnonce-0123456789\n015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862",
+			want:        "2c0711f213b5869e9e270fbc64f582aa618b7fb8b910cde8dc6495a09d6d9d95",
+		},
+		{
+			name:        "empty body",
+			payload:     SignaturePayload(http.MethodGet, "/api/policy", "1700000000", "nonce-0123456789", nil),
+			wantPayload: "GET\n/api/policy\n1700000000\nnonce-0123456789\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:        "query",
+			payload:     SignaturePayload(http.MethodGet, SignedTarget(&url.URL{Path: "/api/policy", RawQuery: "level=host&q=a%20b"}), "1700000000", "nonce-0123456789", nil),
+			wantPayload: "GET\n/api/policy?level=host&q=a%20b\n1700000000\nnonce-0123456789\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			want:        "b4c74e9b4bbca3c7661a528f1f3c362f6eaf1ed15e46b38b9e7ebb232a4fe716",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.payload != tt.wantPayload {
+				t.Errorf("got payload %q, want %q", tt.payload, tt.wantPayload)
+			}
+			if tt.want != "" {
+				if got := ComputeSignature(testSigningKey, tt.payload); got != tt.want {
+					t.Errorf("got signature %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		wantErr   bool
+	}{
+		{name: "now", timestamp: strconv.FormatInt(now.Unix(), 10)},
+		{name: "within skew in the past", timestamp: strconv.FormatInt(now.Add(-4*time.Minute).Unix(), 10)},
+		{name: "within skew in the future", timestamp: strconv.FormatInt(now.Add(4*time.Minute).Unix(), 10)},
+		{name: "too old", timestamp: strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10), wantErr: true},
+		{name: "too far in the future", timestamp: strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10), wantErr: true},
+		{name: "milliseconds", timestamp: strconv.FormatInt(now.UnixMilli(), 10), wantErr: true},
+		{name: "not a number", timestamp: "yesterday", wantErr: true},
+		{name: "empty", timestamp: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkTimestamp(tt.timestamp, DefaultMaxClockSkew); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// signedRequest returns a request of the device, signed with key unless it is empty
+func signedRequest(deviceID, key, timestamp, nonce, body string) *http.Request {
+	return signedRequestTo("/api/login", deviceID, key, timestamp, nonce, body)
+}
+
+// signedRequestTo returns a signed request like signedRequest, to the given path and query
+func signedRequestTo(target, deviceID, key, timestamp, nonce, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), deviceIDKey, deviceID))
+
+	if key != "" {
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderNonce, nonce)
+		req.Header.Set(HeaderSignature, ComputeSignature(key, SignaturePayload(req.Method, SignedTarget(req.URL), timestamp, nonce, []byte(body))))
+	}
+
+	return req
+}
+
+func TestVerifySignature(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	nonce := "0123456789abcdef"
+
+	tests := []struct {
+		name     string
+		required bool
+		requests []*http.Request
+		want     []int
+	}{
+		{
+			name:     "signed",
+			required: true,
+			requests: []*http.Request{signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`)},
+			want:     []int{http.StatusOK},
+		},
+		{
+			name:     "replayed",
+			required: true,
+			requests: []*http.Request{
+				signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`),
+				signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`),
+			},
+			want: []int{http.StatusOK, http.StatusUnauthorized},
+		},
+		{
+			name:     "unsigned while optional",
+			requests: []*http.Request{signedRequest(testDevice, "", "", "", `{"a":1}`)},
+			want:     []int{http.StatusOK},
+		},
+		{
+			name:     "unsigned while required",
+			required: true,
+			requests: []*http.Request{signedRequest(testDevice, "", "", "", `{"a":1}`)},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			// a bad signature must not use up the nonce, or anyone could block the requests of a device
+			name: "wrong key",
+			requests: []*http.Request{
+				signedRequest(testDevice, "other-key", now, nonce, `{"a":1}`),
+				signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`),
+			},
+			want: []int{http.StatusUnauthorized, http.StatusOK},
+		},
+		{
+			name: "tampered body",
+			requests: func() []*http.Request {
+				req := signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`)
+				req.Body = io.NopCloser(strings.NewReader(`{"a":2}`))
+				return []*http.Request{req}
+			}(),
+			want: []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "signed query",
+			required: true,
+			requests: []*http.Request{signedRequestTo("/api/login?level=host", testDevice, testSigningKey, now, nonce, `{"a":1}`)},
+			want:     []int{http.StatusOK},
+		},
+		{
+			name: "tampered query",
+			requests: func() []*http.Request {
+				req := signedRequestTo("/api/login?level=host", testDevice, testSigningKey, now, nonce, `{"a":1}`)
+				req.URL.RawQuery = "level=registrable"
+				return []*http.Request{req}
+			}(),
+			want: []int{http.StatusUnauthorized},
+		},
+		{
+			name: "query added",
+			requests: func() []*http.Request {
+				req := signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`)
+				req.URL.RawQuery = "level=host"
+				return []*http.Request{req}
+			}(),
+			want: []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "stale timestamp",
+			requests: []*http.Request{signedRequest(testDevice, testSigningKey, stale, nonce, `{"a":1}`)},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "short nonce",
+			requests: []*http.Request{signedRequest(testDevice, testSigningKey, now, "short", `{"a":1}`)},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			name:     "device without signing key",
+			requests: []*http.Request{signedRequest("unknown", testSigningKey, now, nonce, `{"a":1}`)},
+			want:     []int{http.StatusUnauthorized},
+		},
+		{
+			// nonces are remembered per device
+			name: "same nonce on another device",
+			requests: []*http.Request{
+				signedRequest(testDevice, testSigningKey, now, nonce, `{"a":1}`),
+				signedRequest("other", "other-key", now, nonce, `{"a":1}`),
+			},
+			want: []int{http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			store := &memory.InMemoryStore{}
+			if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+				t.Fatalf("failed to init store: %v", err)
+			}
+
+			keyring, err := fingerprint.NewKeyring(map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"})
+			if err != nil {
+				t.Fatalf("failed to create keyring: %v", err)
+			}
+
+			for deviceID, key := range map[string]string{testDevice: testSigningKey, "other": "other-key"} {
+				encrypted, err := keyring.EncryptSigningKey(key)
+				if err != nil {
+					t.Fatalf("failed to encrypt signing key: %v", err)
+				}
+				if err := store.EnrollDevice(ctx, deviceID, HashCredential(deviceID), encrypted); err != nil {
+					t.Fatalf("failed to enroll device: %v", err)
+				}
+			}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the body is still readable after verifying it
+				if body, _ := io.ReadAll(r.Body); len(body) == 0 {
+					t.Error("request body consumed by the middleware")
+				}
+				SignResponse(w, r, []byte("ok"))
+			})
+			handler := VerifySignature(logger, store, keyring, tt.required, 0)(next)
+
+			for i, req := range tt.requests {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code != tt.want[i] {
+					t.Fatalf("request %d returned %d, want %d", i+1, rec.Code, tt.want[i])
+				}
+
+				// responses are signed for devices with a signing key, bound to the request they answer
+				if rec.Code == http.StatusOK {
+					key := testSigningKey
+					if DeviceID(req.Context()) == "other" {
+						key = "other-key"
+					}
+
+					payload := SignaturePayload(responseMethod, SignedTarget(req.URL), req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderNonce), []byte("ok"))
+					if got := rec.Header().Get(HeaderSignature); got != ComputeSignature(key, payload) {
+						t.Errorf("request %d got response signature %q", i+1, got)
+					}
+				}
+			}
+		})
+	}
+}
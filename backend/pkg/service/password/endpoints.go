@@ -1,18 +1,39 @@
 package password
 
 import (
+	"encoding/hex"
 	"encoding/json"
-	"github.com/asaskevich/govalidator"
+	"errors"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/service/middleware"
 	"github.com/hazcod/shade/pkg/storage"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"slices"
+	"time"
 )
 
+const sha512HexLength = 128
+
+// duplicatePasswordData optionally scopes the check to a password the extension just submitted
 type duplicatePasswordData struct {
-	Username string `json:"username" valid:"required"`
+	Domain string `json:"domain"`
+	// Hash is the SHA-512 reuse hash, as sent to /api/creds/register
+	Hash string `json:"hash"`
+	// Level is "host" to compare exact hosts instead of registrable domains
+	Level string `json:"level"`
 }
 
-func CheckDuplicatePassword(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+// CheckDuplicatePassword returns the groups of domains on which the user of the device uses the same password.
+// Only logins submitted by the device itself are compared: usernames are sent by the extension and not
+// authenticated, so they cannot be used to look into the passwords stored from other devices.
+// With a hash only the group of that password is returned, with a domain the other domains sharing its password
+// are listed as well so the extension can warn about them. Domains are compared on their registrable domain,
+// so logins on several hosts of the same app are not reported as reuse unless the host level is requested.
+func CheckDuplicatePassword(logger *logrus.Logger, store storage.Driver, keyring *fingerprint.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -20,23 +41,27 @@ func CheckDuplicatePassword(logger *logrus.Logger, store storage.Driver) http.Ha
 		}
 
 		var data duplicatePasswordData
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		valid, err := govalidator.ValidateStruct(data)
-		if !valid || err != nil {
-			logger.WithError(err).WithField("body", data).Error("endpoint data validation failed")
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+		passwordFingerprint := ""
+		if data.Hash != "" {
+			if _, err := hex.DecodeString(data.Hash); err != nil || len(data.Hash) != sha512HexLength {
+				middleware.WriteError(logger, w, http.StatusBadRequest, "hash is not a sha512 hex digest")
+				return
+			}
+			passwordFingerprint = keyring.Fingerprint(data.Hash)
 		}
 
-		// Store the login data
-		dupes, err := store.GetDuplicatePasswordsForUser(data.Username)
+		deviceID := middleware.DeviceID(r.Context())
+		level := models.ParseDomainLevel(data.Level)
+
+		dupes, err := store.GetDuplicatePasswordsForDevice(r.Context(), deviceID, passwordFingerprint, level)
 		if err != nil {
-			logger.WithError(err).WithField("body", data).Error("store add failed")
-			http.Error(w, "failed to store", http.StatusInternalServerError)
+			logger.WithError(err).WithField("device_id", deviceID).Error("failed to retrieve duplicate passwords")
+			http.Error(w, "failed to retrieve duplicate passwords", http.StatusInternalServerError)
 			return
 		}
 
@@ -44,20 +69,79 @@ func CheckDuplicatePassword(logger *logrus.Logger, store storage.Driver) http.Ha
 			logger.Debugf("%+v", dupes)
 		}
 
-		// Return success response
+		response := map[string]interface{}{
+			"duplicates": dupes,
+		}
+
+		if data.Domain != "" {
+			name := domainname.Normalize(data.Domain)
+
+			domain := name.Domain
+			if level == models.DomainLevelHost {
+				domain = name.Host
+			}
+
+			alsoUsedOn := make([]string, 0)
+
+			for _, domains := range dupes {
+				if !slices.Contains(domains, domain) {
+					continue
+				}
+				for _, other := range domains {
+					if other != domain && !slices.Contains(alsoUsedOn, other) {
+						alsoUsedOn = append(alsoUsedOn, other)
+					}
+				}
+			}
+
+			response["also_used_on"] = alsoUsedOn
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(&dupes); err != nil {
+		if err := json.NewEncoder(w).Encode(response); err != nil {
 			logger.WithError(err).Error("Failed to write response")
 		}
 	}
 }
 
+// compromisedAccount is an account the user should change the password of
+type compromisedAccount struct {
+	Domain      string    `json:"domain"`
+	Username    string    `json:"username"`
+	BreachCount int       `json:"breach_count"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// CheckCompromisedPasswords returns the accounts of the authenticated device that use a breached password
 func CheckCompromisedPasswords(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+
+		deviceID := middleware.DeviceID(r.Context())
+
+		stored, err := store.GetCompromisedAccounts(r.Context(), deviceID)
+		if err != nil {
+			logger.WithError(err).WithField("device_id", deviceID).Error("failed to retrieve compromised accounts")
+			http.Error(w, "failed to retrieve compromised accounts", http.StatusInternalServerError)
+			return
+		}
+
+		accounts := make([]compromisedAccount, 0, len(stored))
+		for _, account := range stored {
+			accounts = append(accounts, compromisedAccount{
+				Domain:      account.Domain,
+				Username:    account.Username,
+				BreachCount: account.BreachCount,
+				CheckedAt:   account.CheckedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"accounts": accounts}); err != nil {
+			logger.WithError(err).Error("Failed to write response")
+		}
 	}
 }
@@ -0,0 +1,156 @@
+package password
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/fingerprint"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckDuplicatePassword(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx := context.Background()
+
+	keyring, err := fingerprint.NewKeyring(map[string]string{"2025_01": "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	store := &memory.InMemoryStore{}
+	if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+
+	shared := strings.Repeat("a", sha512HexLength)
+	other := strings.Repeat("b", sha512HexLength)
+	now := time.Now()
+
+	logins := []events.LoginEvent{
+		{Timestamp: now, User: "alice", Domain: "slack.com", Host: "app.slack.com", Hash: keyring.Fingerprint(shared), DeviceID: "laptop"},
+		{Timestamp: now, User: "alice", Domain: "github.com", Host: "github.com", Hash: keyring.Fingerprint(shared), DeviceID: "laptop"},
+		{Timestamp: now, User: "alice", Domain: "gitlab.com", Host: "gitlab.com", Hash: keyring.Fingerprint(other), DeviceID: "laptop"},
+		// another device claiming to be alice
+		{Timestamp: now, User: "alice", Domain: "dropbox.com", Host: "dropbox.com", Hash: keyring.Fingerprint(other), DeviceID: "intruder"},
+	}
+	for _, login := range logins {
+		if err := store.AddLoginEvent(ctx, login); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	for _, deviceID := range []string{"laptop", "intruder"} {
+		if err := store.EnrollDevice(ctx, deviceID, middleware.HashCredential(deviceID+"-token"), ""); err != nil {
+			t.Fatalf("failed to enroll device: %v", err)
+		}
+	}
+
+	handler := middleware.RequireDeviceCredential(logger, store)(CheckDuplicatePassword(logger, store, keyring))
+
+	tests := []struct {
+		name           string
+		deviceID       string
+		method         string
+		body           string
+		wantStatus     int
+		wantDuplicates [][]string
+		wantAlsoUsedOn []string
+	}{
+		{
+			name:           "every password",
+			deviceID:       "laptop",
+			body:           `{}`,
+			wantStatus:     http.StatusOK,
+			wantDuplicates: [][]string{{"github.com", "slack.com"}},
+		},
+		{
+			name:           "empty body",
+			deviceID:       "laptop",
+			wantStatus:     http.StatusOK,
+			wantDuplicates: [][]string{{"github.com", "slack.com"}},
+		},
+		{
+			name:           "submitted password on a domain",
+			deviceID:       "laptop",
+			body:           `{"domain":"https://app.slack.com","hash":"` + shared + `"}`,
+			wantStatus:     http.StatusOK,
+			wantDuplicates: [][]string{{"github.com", "slack.com"}},
+			wantAlsoUsedOn: []string{"github.com"},
+		},
+		{
+			name:           "password used once",
+			deviceID:       "laptop",
+			body:           `{"domain":"gitlab.com","hash":"` + other + `"}`,
+			wantStatus:     http.StatusOK,
+			wantDuplicates: [][]string{},
+			wantAlsoUsedOn: []string{},
+		},
+		{
+			// the logins of alice on other devices stay out of reach of a device claiming her username
+			name:           "other device of the same username",
+			deviceID:       "intruder",
+			body:           `{"hash":"` + other + `"}`,
+			wantStatus:     http.StatusOK,
+			wantDuplicates: [][]string{},
+		},
+		{
+			name:       "invalid hash",
+			deviceID:   "laptop",
+			body:       `{"hash":"abc"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "read with GET",
+			deviceID:   "laptop",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "/api/password/domaincheck", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.deviceID+"-token")
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Duplicates [][]string `json:"duplicates"`
+				AlsoUsedOn []string   `json:"also_used_on"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if !slices.EqualFunc(response.Duplicates, tt.wantDuplicates, slices.Equal[[]string]) {
+				t.Errorf("got duplicates %v, want %v", response.Duplicates, tt.wantDuplicates)
+			}
+			if !slices.Equal(response.AlsoUsedOn, tt.wantAlsoUsedOn) {
+				t.Errorf("got also used on %v, want %v", response.AlsoUsedOn, tt.wantAlsoUsedOn)
+			}
+		})
+	}
+}
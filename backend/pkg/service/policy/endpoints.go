@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/service/middleware"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// document is the policy as the extension receives it
+type document struct {
+	Version           int       `json:"version"`
+	Enabled           bool      `json:"enabled"`
+	UsernameFilters   []string  `json:"username_filters"`
+	IgnoredDomains    []string  `json:"ignored_domains"`
+	ProhibitedDomains []string  `json:"prohibited_domains"`
+	BlockedDomains    []string  `json:"blocked_domains"`
+	Messages          messages  `json:"messages"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type messages struct {
+	Prohibited string `json:"prohibited"`
+	Blocked    string `json:"blocked"`
+	Breach     string `json:"breach"`
+	Reuse      string `json:"reuse"`
+}
+
+func newDocument(policy models.Policy) document {
+	return document{
+		Version:           policy.Version,
+		Enabled:           !policy.Disabled,
+		UsernameFilters:   nonNil(policy.UsernameFilters),
+		IgnoredDomains:    nonNil(policy.IgnoredDomains),
+		ProhibitedDomains: nonNil(policy.ProhibitedDomains),
+		BlockedDomains:    nonNil(policy.BlockedDomains),
+		Messages: messages{
+			Prohibited: policy.Messages.Prohibited,
+			Blocked:    policy.Messages.Blocked,
+			Breach:     policy.Messages.Breach,
+			Reuse:      policy.Messages.Reuse,
+		},
+		UpdatedAt: policy.UpdatedAt,
+	}
+}
+
+// nonNil makes sure lists are encoded as [] instead of null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// entityTag returns the entity tag of a policy version
+func entityTag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// GetPolicy returns the current policy for the extension.
+// Until a policy is stored, version 0 is returned which keeps the extension defaults.
+// The version is used as ETag so extensions polling with If-None-Match only download changes,
+// and the version and body are signed with the policy key extensions pin, so a policy cannot be forged
+// or rolled back to an older version on the way. Without a signer policies are served unsigned.
+func GetPolicy(logger *logrus.Logger, store storage.Driver, signer *Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		policy, _, err := store.GetPolicy(r.Context())
+		if err != nil {
+			logger.WithError(err).Error("failed to retrieve policy")
+			middleware.WriteError(logger, w, http.StatusInternalServerError, "failed to retrieve policy")
+			return
+		}
+
+		etag := entityTag(policy.Version)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		body, err := json.Marshal(newDocument(policy))
+		if err != nil {
+			logger.WithError(err).Error("failed to encode policy")
+			middleware.WriteError(logger, w, http.StatusInternalServerError, "failed to encode policy")
+			return
+		}
+
+		if signer != nil {
+			w.Header().Set(HeaderVersion, strconv.Itoa(policy.Version))
+			w.Header().Set(HeaderSignature, signer.Sign(policy.Version, body))
+		}
+
+		middleware.SignResponse(w, r, body)
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			logger.WithError(err).Error("Failed to write response")
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package policy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(signer.PublicKey())
+	if err != nil {
+		t.Fatalf("invalid public key: %v", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signer.Sign(3, []byte(`{"version":3}`)))
+	if err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+
+	if !ed25519.Verify(publicKey, SignaturePayload(3, []byte(`{"version":3}`)), signature) {
+		t.Error("signature does not verify")
+	}
+	// a signed body cannot be served as another version
+	if ed25519.Verify(publicKey, SignaturePayload(4, []byte(`{"version":3}`)), signature) {
+		t.Error("signature verifies for another version")
+	}
+
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewSigner(invalid); err == nil {
+			t.Errorf("expected an error for key %q", invalid)
+		}
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	publicKey, _ := base64.StdEncoding.DecodeString(signer.PublicKey())
+
+	stored := models.Policy{
+		UsernameFilters:   []string{"@example.com"},
+		ProhibitedDomains: []string{"dropbox.com"},
+		Messages:          models.PolicyMessages{Prohibited: "Use the company drive"},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		store       bool
+		signer      *Signer
+		ifNoneMatch string
+		wantStatus  int
+		wantVersion int
+	}{
+		{name: "defaults", method: http.MethodGet, signer: signer, wantStatus: http.StatusOK, wantVersion: 0},
+		{name: "stored", method: http.MethodGet, store: true, signer: signer, wantStatus: http.StatusOK, wantVersion: 1},
+		{name: "unsigned", method: http.MethodGet, store: true, wantStatus: http.StatusOK, wantVersion: 1},
+		{name: "not modified", method: http.MethodGet, store: true, signer: signer, ifNoneMatch: `"1"`, wantStatus: http.StatusNotModified, wantVersion: 1},
+		{name: "changed", method: http.MethodGet, store: true, signer: signer, ifNoneMatch: `"0"`, wantStatus: http.StatusOK, wantVersion: 1},
+		{name: "wrong method", method: http.MethodPost, signer: signer, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memory.InMemoryStore{}
+			if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+				t.Fatalf("failed to init store: %v", err)
+			}
+			if tt.store {
+				if _, err := store.StorePolicy(context.Background(), stored); err != nil {
+					t.Fatalf("failed to store policy: %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(tt.method, "/api/policy", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+
+			GetPolicy(logger, store, tt.signer).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusMethodNotAllowed {
+				return
+			}
+
+			if etag := rec.Header().Get("ETag"); etag != `"`+strconv.Itoa(tt.wantVersion)+`"` {
+				t.Errorf("got ETag %s, want version %d", etag, tt.wantVersion)
+			}
+			if rec.Code == http.StatusNotModified {
+				if rec.Body.Len() != 0 {
+					t.Error("not modified response has a body")
+				}
+				return
+			}
+
+			body := rec.Body.Bytes()
+
+			signature := rec.Header().Get(HeaderSignature)
+			if tt.signer == nil {
+				if signature != "" || rec.Header().Get(HeaderVersion) != "" {
+					t.Error("unsigned policy has signature headers")
+				}
+			} else {
+				decoded, _ := base64.StdEncoding.DecodeString(signature)
+				if rec.Header().Get(HeaderVersion) != strconv.Itoa(tt.wantVersion) || !ed25519.Verify(publicKey, SignaturePayload(tt.wantVersion, body), decoded) {
+					t.Errorf("signature %q does not verify for version %d", signature, tt.wantVersion)
+				}
+			}
+
+			var got document
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("failed to decode policy: %v", err)
+			}
+
+			want := newDocument(models.Policy{})
+			if tt.store {
+				want = newDocument(stored)
+				want.Version = 1
+				want.UpdatedAt = got.UpdatedAt
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got policy %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewDocumentLists(t *testing.T) {
+	body, err := json.Marshal(newDocument(models.Policy{}))
+	if err != nil {
+		t.Fatalf("failed to encode policy: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatalf("failed to decode policy: %v", err)
+	}
+
+	// the extension expects lists, never null
+	for _, name := range []string{"username_filters", "ignored_domains", "prohibited_domains", "blocked_domains"} {
+		if _, ok := fields[name].([]interface{}); !ok {
+			t.Errorf("got %s %v, want a list", name, fields[name])
+		}
+	}
+	if fields["enabled"] != true {
+		t.Errorf("got enabled %v, want true", fields["enabled"])
+	}
+}
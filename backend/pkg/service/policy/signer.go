@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+const (
+	HeaderVersion   = "X-Shade-Policy-Version"
+	HeaderSignature = "X-Shade-Policy-Signature"
+)
+
+// Signer signs policy documents with the Ed25519 key of the backend.
+// Extensions pin its public key, so a policy is only applied when it was issued by the backend,
+// even when the device signing key is known to whoever serves it.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner creates a signer from a base64 encoded Ed25519 seed as created by GenerateKey
+func NewSigner(encodedKey string) (*Signer, error) {
+	seed, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy signing key: %w", err)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("policy signing key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+
+	return &Signer{key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// GenerateKey returns a new base64 encoded Ed25519 seed for NewSigner
+func GenerateKey() (string, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(seed), nil
+}
+
+// PublicKey returns the base64 encoded public key extensions pin
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// SignaturePayload returns the bytes a policy signature is computed over
+func SignaturePayload(version int, body []byte) []byte {
+	return append([]byte(strconv.Itoa(version)+"\n"), body...)
+}
+
+// Sign returns the base64 encoded signature of a policy version and its body
+func (s *Signer) Sign(version int, body []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, SignaturePayload(version, body)))
+}
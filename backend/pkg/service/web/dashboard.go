@@ -2,6 +2,7 @@ package web
 
 import (
 	"embed"
+	"github.com/gorilla/csrf"
 	"github.com/hazcod/shade/pkg/auth/session"
 	"github.com/hazcod/shade/pkg/models"
 	"github.com/hazcod/shade/pkg/storage"
@@ -20,8 +21,12 @@ var staticFS embed.FS
 // Templates loaded from embedded files
 var dashboardTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/dashboard.tmpl"))
 var saasTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/saas.tmpl"))
+var saasDetailTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/saas_detail.tmpl"))
 var securityTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/security.tmpl"))
 var usersTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/users.tmpl"))
+var userDetailTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/user.tmpl"))
+var policyTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/policy.tmpl"))
+var enforcementTmpl = template.Must(template.ParseFS(templateFS, "templates/base.tmpl", "templates/enforcement.tmpl"))
 
 // Static file handler for embedded files
 func GetStaticFile(logger *logrus.Logger) http.HandlerFunc {
@@ -62,26 +67,46 @@ type baseData struct {
 type dashboardPageData struct {
 	baseData
 	Stats models.DashboardStats
+	Apps  appStats
 }
 
 type saasPageData struct {
 	baseData
-	Domains []string
+	CSRFField  template.HTML
+	Search     string
+	Status     string
+	Statuses   []models.AppStatus
+	Level      levelToggle
+	Apps       []saasApp
+	Pagination pagination
+	// Truncated is set when more domains were discovered than are grouped into apps
+	Truncated bool
+	// Return is the query of the page, to come back to after a review
+	Return string
 }
 
 type securityPageData struct {
 	baseData
-	DuplicatePasswords map[string]map[string]string
-	UsersWithoutMFA    []string
+	Search                       string
+	Level                        levelToggle
+	DuplicatePasswords           []models.DuplicatePasswordEntry
+	DuplicatePasswordsPagination pagination
+	UsersWithoutMFA              []string
+	UsersWithoutMFAPagination    pagination
 }
 
 type usersPageData struct {
 	baseData
-	Users []models.EnrolledUser
+	CSRFField  template.HTML
+	Search     string
+	Sort       string
+	Descending bool
+	Users      []models.EnrolledUser
+	Pagination pagination
 }
 
 // Dashboard stats page handler
-func GetDashboard(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+func GetDashboard(logger *logrus.Logger, store storage.Driver, appCache *AppCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -95,13 +120,20 @@ func GetDashboard(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 			return
 		}
 
-		stats, err := store.GetDashboardStats()
+		stats, err := store.GetDashboardStats(r.Context())
 		if err != nil {
 			logger.WithError(err).Error("error getting dashboard stats")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		apps, _, err := appCache.Apps(r.Context(), models.DomainLevelRegistrable)
+		if err != nil {
+			logger.WithError(err).Error("error getting discovered apps")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		data := dashboardPageData{
 			baseData: baseData{
 				Title:       "Dashboard",
@@ -109,6 +141,7 @@ func GetDashboard(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 				CurrentPage: "dashboard",
 			},
 			Stats: stats,
+			Apps:  countApps(apps),
 		}
 
 		w.Header().Set("Content-Type", "text/html")
@@ -119,8 +152,8 @@ func GetDashboard(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 	}
 }
 
-// SaaS discovery page handler
-func GetSaasPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+// SaaS discovery page handler, discovered domains are grouped by the app they belong to
+func GetSaasPage(logger *logrus.Logger, appCache *AppCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -134,20 +167,48 @@ func GetSaasPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
 			return
 		}
 
-		domains, err := store.GetAllDomains()
+		query := queryFromRequest(r, "cursor")
+
+		discovered, truncated, err := appCache.Apps(r.Context(), query.Level)
 		if err != nil {
-			logger.WithError(err).Error("error getting all domains")
+			logger.WithError(err).Error("error getting discovered apps")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		status := r.URL.Query().Get("status")
+
+		// apps are paged rather than domains, so all domains of an app end up on the same page
+		apps := make([]saasApp, 0)
+		for _, app := range discovered {
+			if (status == "" || string(app.Review.Status) == status) && matchesApp(app, query.Search) {
+				apps = append(apps, app)
+			}
+		}
+
+		page, err := models.Paginate(apps, query, func(i int) []string {
+			return []string{strings.ToLower(apps[i].Name), apps[i].Key}
+		})
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
 		data := saasPageData{
 			baseData: baseData{
 				Title:       "Discovered SaaS",
 				Username:    user.Email,
 				CurrentPage: "saas",
 			},
-			Domains: domains,
+			CSRFField:  csrf.TemplateField(r),
+			Search:     query.Search,
+			Status:     status,
+			Statuses:   models.AppStatuses,
+			Level:      newLevelToggle(r, query.Level, "cursor"),
+			Apps:       page.Items,
+			Pagination: newPagination(r, "cursor", page.NextCursor),
+			Truncated:  truncated,
+			Return:     r.URL.RawQuery,
 		}
 
 		w.Header().Set("Content-Type", "text/html")
@@ -173,14 +234,16 @@ func GetSecurityPage(logger *logrus.Logger, store storage.Driver) http.HandlerFu
 			return
 		}
 
-		dupePasswords, err := store.GetDuplicatePasswords()
+		dupesQuery := queryFromRequest(r, "dupes_cursor")
+
+		dupePasswords, err := store.GetDuplicatePasswords(r.Context(), dupesQuery)
 		if err != nil {
 			logger.WithError(err).Error("error getting duplicate passwords")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		usersWithoutMFA, err := store.GetUsersWithoutMFA()
+		usersWithoutMFA, err := store.GetUsersWithoutMFA(r.Context(), queryFromRequest(r, "mfa_cursor"))
 		if err != nil {
 			logger.WithError(err).Error("error getting users without MFA")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -193,8 +256,12 @@ func GetSecurityPage(logger *logrus.Logger, store storage.Driver) http.HandlerFu
 				Username:    user.Email,
 				CurrentPage: "security",
 			},
-			DuplicatePasswords: dupePasswords,
-			UsersWithoutMFA:    usersWithoutMFA,
+			Search:                       dupesQuery.Search,
+			Level:                        newLevelToggle(r, dupesQuery.Level, "dupes_cursor"),
+			DuplicatePasswords:           dupePasswords.Items,
+			DuplicatePasswordsPagination: newPagination(r, "dupes_cursor", dupePasswords.NextCursor),
+			UsersWithoutMFA:              usersWithoutMFA.Items,
+			UsersWithoutMFAPagination:    newPagination(r, "mfa_cursor", usersWithoutMFA.NextCursor),
 		}
 
 		w.Header().Set("Content-Type", "text/html")
@@ -220,7 +287,9 @@ func GetUsersPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 			return
 		}
 
-		users, err := store.GetEnrolledUsers()
+		query := queryFromRequest(r, "cursor")
+
+		users, err := store.GetEnrolledUsers(r.Context(), query)
 		if err != nil {
 			logger.WithError(err).Error("error getting enrolled users")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -233,7 +302,12 @@ func GetUsersPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 				Username:    user.Email,
 				CurrentPage: "endpoints",
 			},
-			Users: users,
+			CSRFField:  csrf.TemplateField(r),
+			Search:     query.Search,
+			Sort:       query.Sort,
+			Descending: query.Descending,
+			Users:      users.Items,
+			Pagination: newPagination(r, "cursor", users.NextCursor),
 		}
 
 		w.Header().Set("Content-Type", "text/html")
@@ -243,3 +317,75 @@ func GetUsersPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc
 		}
 	}
 }
+
+// Device revocation handler for the endpoints page
+func RevokeDevice(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		deviceID := r.FormValue("device_id")
+		if deviceID == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if err := store.RevokeDevice(r.Context(), deviceID); err != nil {
+			logger.WithError(err).WithField("device_id", deviceID).Error("error revoking device")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"device_id": deviceID,
+			"admin":     user.Email,
+		}).Info("revoked device")
+
+		http.Redirect(w, r, "/dashboard/endpoints", http.StatusSeeOther)
+	}
+}
+
+// Device reset handler for the endpoints page, lets a device enroll again with the bootstrap token
+func ResetDevice(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		deviceID := r.FormValue("device_id")
+		if deviceID == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if err := store.ResetDevice(r.Context(), deviceID); err != nil {
+			logger.WithError(err).WithField("device_id", deviceID).Error("error resetting device")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"device_id": deviceID,
+			"admin":     user.Email,
+		}).Info("reset device")
+
+		http.Redirect(w, r, "/dashboard/endpoints", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"github.com/hazcod/shade/pkg/auth/session"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+type enforcementPageData struct {
+	baseData
+	Search     string
+	Action     string
+	Actions    []models.EnforcementAction
+	Events     []events.EnforcementEvent
+	Pagination pagination
+}
+
+// Enforcement events page handler, lists the enforcement decisions on logins, filtered on action
+func GetEnforcementPage(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		query := queryFromRequest(r, "cursor")
+
+		// unknown actions list every event, like an empty filter
+		action, _ := models.ParseEnforcementAction(r.URL.Query().Get("action"))
+
+		enforcementEvents, err := store.GetEnforcementEvents(r.Context(), query, action)
+		if err != nil {
+			logger.WithError(err).Error("error getting enforcement events")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := enforcementPageData{
+			baseData: baseData{
+				Title:       "Enforcement",
+				Username:    user.Email,
+				CurrentPage: "enforcement",
+			},
+			Search:     query.Search,
+			Action:     string(action),
+			Actions:    models.EnforcementActions,
+			Events:     enforcementEvents.Items,
+			Pagination: newPagination(r, "cursor", enforcementEvents.NextCursor),
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := enforcementTmpl.Execute(w, data); err != nil {
+			logger.WithError(err).Error("error rendering template")
+			http.Error(w, "Template Error", http.StatusInternalServerError)
+		}
+	}
+}
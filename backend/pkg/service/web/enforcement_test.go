@@ -0,0 +1,61 @@
+package web
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetEnforcementPage(t *testing.T) {
+	logger, store := newTestStore(t)
+
+	recorded := []events.EnforcementEvent{
+		{Timestamp: time.Now(), User: "alice", Domain: "slack.com", Host: "app.slack.com", App: "Slack", DeviceID: "laptop", Action: models.EnforcementAllow},
+		{Timestamp: time.Now(), User: "bob", Domain: "mega.nz", Host: "mega.nz", App: "MEGA", DeviceID: "desktop", Action: models.EnforcementBlock},
+	}
+	for _, event := range recorded {
+		if err := store.AddEnforcementEvent(context.Background(), event); err != nil {
+			t.Fatalf("failed to add enforcement event: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantApps   []string
+		wantHidden []string
+	}{
+		{name: "every decision", target: "/dashboard/enforcement", wantApps: []string{"Slack", "MEGA"}},
+		{name: "blocked", target: "/dashboard/enforcement?action=block", wantApps: []string{"MEGA"}, wantHidden: []string{"Slack"}},
+		{name: "allowed", target: "/dashboard/enforcement?action=allow", wantApps: []string{"Slack"}, wantHidden: []string{"MEGA"}},
+		{name: "unknown action", target: "/dashboard/enforcement?action=nope", wantApps: []string{"Slack", "MEGA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			GetEnforcementPage(logger, store).ServeHTTP(rec, newSessionRequest(t, http.MethodGet, tt.target, ""))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			body := rec.Body.String()
+			for _, app := range tt.wantApps {
+				if !strings.Contains(body, app) {
+					t.Errorf("page does not list %s", app)
+				}
+			}
+			for _, app := range tt.wantHidden {
+				if strings.Contains(body, app) {
+					t.Errorf("page lists %s", app)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"github.com/hazcod/shade/pkg/models"
+	"net/http"
+	"strconv"
+)
+
+// pagination holds the links to render below a paged table
+type pagination struct {
+	// NextURL is empty on the last page
+	NextURL string
+	// FirstURL is empty on the first page
+	FirstURL string
+}
+
+// queryFromRequest builds a storage query from the URL parameters of the request.
+// cursorParam allows pages with several paged tables to keep a cursor per table.
+func queryFromRequest(r *http.Request, cursorParam string) models.Query {
+	params := r.URL.Query()
+
+	limit, _ := strconv.Atoi(params.Get("limit"))
+
+	return models.Query{
+		Search:     params.Get("q"),
+		Sort:       params.Get("sort"),
+		Descending: params.Get("order") == "desc",
+		Limit:      limit,
+		Cursor:     params.Get(cursorParam),
+		Level:      models.ParseDomainLevel(params.Get("level")),
+	}
+}
+
+// newPagination returns the links to the first and next page of a paged table
+func newPagination(r *http.Request, cursorParam, nextCursor string) pagination {
+	var p pagination
+
+	if nextCursor != "" {
+		params := r.URL.Query()
+		params.Set(cursorParam, nextCursor)
+		p.NextURL = "?" + params.Encode()
+	}
+
+	if r.URL.Query().Get(cursorParam) != "" {
+		params := r.URL.Query()
+		params.Del(cursorParam)
+		p.FirstURL = "?" + params.Encode()
+	}
+
+	return p
+}
+
+// levelToggle holds the links to switch a domain table between apps and hosts
+type levelToggle struct {
+	AppsURL   string
+	HostsURL  string
+	HostLevel bool
+}
+
+// newLevelToggle returns the links to both domain levels, restarting paged tables at their first page
+func newLevelToggle(r *http.Request, level models.DomainLevel, cursorParams ...string) levelToggle {
+	params := r.URL.Query()
+	for _, cursorParam := range cursorParams {
+		params.Del(cursorParam)
+	}
+
+	params.Del("level")
+	appsURL := "?" + params.Encode()
+
+	params.Set("level", string(models.DomainLevelHost))
+	hostsURL := "?" + params.Encode()
+
+	return levelToggle{
+		AppsURL:   appsURL,
+		HostsURL:  hostsURL,
+		HostLevel: level == models.DomainLevelHost,
+	}
+}
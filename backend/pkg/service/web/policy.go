@@ -0,0 +1,194 @@
+package web
+
+import (
+	"github.com/gorilla/csrf"
+	"github.com/hazcod/shade/pkg/auth/session"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxPolicyEntries       = 1000
+	maxPolicyEntryLength   = 253
+	maxPolicyMessageLength = 500
+)
+
+type policyPageData struct {
+	baseData
+	CSRFField template.HTML
+	Policy    models.Policy
+	// Stored is false until the first policy is saved and the extension defaults apply
+	Stored bool
+	// PublicKey is the policy signing key extensions pin, empty when policies are served unsigned
+	PublicKey string
+}
+
+// Joined returns the entries of a policy list as the textarea content, one per line
+func (policyPageData) Joined(values []string) string {
+	return strings.Join(values, "\n")
+}
+
+// policyList parses a textarea into unique, non-empty entries
+func policyList(value string, normalize func(string) string) ([]string, bool) {
+	entries := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, line := range strings.Split(value, "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" {
+			continue
+		}
+		if len(entry) > maxPolicyEntryLength {
+			return nil, false
+		}
+
+		if normalize != nil {
+			entry = normalize(entry)
+		}
+		if entry == "" || seen[entry] {
+			continue
+		}
+
+		seen[entry] = true
+		entries = append(entries, entry)
+	}
+
+	return entries, len(entries) <= maxPolicyEntries
+}
+
+// policyDomain reduces a policy domain entry to the host it applies to, subdomains are matched by the extension.
+// A leading www. is dropped so the entry also covers the other hosts of the site.
+func policyDomain(entry string) string {
+	name := domainname.Normalize(strings.TrimPrefix(entry, "*."))
+	if name.Host != name.Domain {
+		return strings.TrimPrefix(name.Host, "www.")
+	}
+	return name.Host
+}
+
+// Extension policy page handler
+func GetPolicyPage(logger *logrus.Logger, store storage.Driver, publicKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		policy, stored, err := store.GetPolicy(r.Context())
+		if err != nil {
+			logger.WithError(err).Error("error getting policy")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := policyPageData{
+			baseData: baseData{
+				Title:       "Policy",
+				Username:    user.Email,
+				CurrentPage: "policy",
+			},
+			CSRFField: csrf.TemplateField(r),
+			Policy:    policy,
+			Stored:    stored,
+			PublicKey: publicKey,
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := policyTmpl.Execute(w, data); err != nil {
+			logger.WithError(err).Error("error rendering template")
+			http.Error(w, "Template Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// Policy update handler, every update is stored as a new version
+func UpdatePolicy(logger *logrus.Logger, store storage.Driver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		usernameFilters, ok := policyList(r.FormValue("username_filters"), nil)
+		if !ok {
+			http.Error(w, "Too many or too long username filters", http.StatusBadRequest)
+			return
+		}
+
+		ignoredDomains, ok := policyList(r.FormValue("ignored_domains"), policyDomain)
+		if !ok {
+			http.Error(w, "Too many or too long ignored domains", http.StatusBadRequest)
+			return
+		}
+
+		prohibitedDomains, ok := policyList(r.FormValue("prohibited_domains"), policyDomain)
+		if !ok {
+			http.Error(w, "Too many or too long prohibited domains", http.StatusBadRequest)
+			return
+		}
+
+		blockedDomains, ok := policyList(r.FormValue("blocked_domains"), policyDomain)
+		if !ok {
+			http.Error(w, "Too many or too long blocked domains", http.StatusBadRequest)
+			return
+		}
+
+		messages := models.PolicyMessages{
+			Prohibited: strings.TrimSpace(r.FormValue("message_prohibited")),
+			Blocked:    strings.TrimSpace(r.FormValue("message_blocked")),
+			Breach:     strings.TrimSpace(r.FormValue("message_breach")),
+			Reuse:      strings.TrimSpace(r.FormValue("message_reuse")),
+		}
+		for _, message := range []string{messages.Prohibited, messages.Blocked, messages.Breach, messages.Reuse} {
+			if len(message) > maxPolicyMessageLength {
+				http.Error(w, "Message too long", http.StatusBadRequest)
+				return
+			}
+		}
+
+		policy := models.Policy{
+			Disabled:          r.FormValue("enabled") == "",
+			UsernameFilters:   usernameFilters,
+			IgnoredDomains:    ignoredDomains,
+			ProhibitedDomains: prohibitedDomains,
+			BlockedDomains:    blockedDomains,
+			Messages:          messages,
+			UpdatedBy:         user.Email,
+			UpdatedAt:         time.Now(),
+		}
+
+		version, err := store.StorePolicy(r.Context(), policy)
+		if err != nil {
+			logger.WithError(err).Error("error storing policy")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"version": version,
+			"admin":   user.Email,
+		}).Info("updated extension policy")
+
+		http.Redirect(w, r, "/dashboard/policy", http.StatusSeeOther)
+	}
+}
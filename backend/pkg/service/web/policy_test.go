@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicyDomain(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{entry: "dropbox.com", want: "dropbox.com"},
+		{entry: "WWW.Dropbox.com", want: "dropbox.com"},
+		{entry: "*.dropbox.com", want: "dropbox.com"},
+		{entry: "app.slack.com", want: "app.slack.com"},
+		{entry: "https://app.slack.com/login", want: "app.slack.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			if got := policyDomain(tt.entry); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		form       url.Values
+		wantStatus int
+		// the lists of the stored policy, nothing is stored when the status is not a redirect
+		wantFilters    []string
+		wantProhibited []string
+		wantBlocked    []string
+		wantDisabled   bool
+	}{
+		{
+			name:   "lists are normalized",
+			method: http.MethodPost,
+			form: url.Values{
+				"enabled":            {"on"},
+				"username_filters":   {" @example.com \n\n@example.com\n@corp.example.com"},
+				"prohibited_domains": {"WWW.Dropbox.com\n*.dropbox.com\napp.box.com"},
+				"blocked_domains":    {"https://mega.nz/login"},
+				"message_prohibited": {"  Use the company drive  "},
+			},
+			wantStatus:     http.StatusSeeOther,
+			wantFilters:    []string{"@example.com", "@corp.example.com"},
+			wantProhibited: []string{"dropbox.com", "app.box.com"},
+			wantBlocked:    []string{"mega.nz"},
+		},
+		{
+			name:           "unchecked enabled disables capturing",
+			method:         http.MethodPost,
+			form:           url.Values{},
+			wantStatus:     http.StatusSeeOther,
+			wantFilters:    []string{},
+			wantProhibited: []string{},
+			wantBlocked:    []string{},
+			wantDisabled:   true,
+		},
+		{
+			name:       "entry too long",
+			method:     http.MethodPost,
+			form:       url.Values{"ignored_domains": {strings.Repeat("a", maxPolicyEntryLength+1) + ".com"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too many entries",
+			method:     http.MethodPost,
+			form:       url.Values{"username_filters": {manyEntries(maxPolicyEntries + 1)}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "message too long",
+			method:     http.MethodPost,
+			form:       url.Values{"message_breach": {strings.Repeat("a", maxPolicyMessageLength+1)}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			form:       url.Values{},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, store := newTestStore(t)
+
+			req := newSessionRequest(t, tt.method, "/dashboard/policy", tt.form.Encode())
+			rec := httptest.NewRecorder()
+
+			UpdatePolicy(logger, store).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			policy, stored, err := store.GetPolicy(context.Background())
+			if err != nil {
+				t.Fatalf("failed to get policy: %v", err)
+			}
+			if stored != (tt.wantStatus == http.StatusSeeOther) {
+				t.Fatalf("got policy stored %v for status %d", stored, rec.Code)
+			}
+			if !stored {
+				return
+			}
+
+			if policy.Version != 1 || policy.UpdatedBy != testAdmin || policy.Disabled != tt.wantDisabled {
+				t.Errorf("got version %d by %s disabled %v", policy.Version, policy.UpdatedBy, policy.Disabled)
+			}
+			if !reflect.DeepEqual(policy.UsernameFilters, tt.wantFilters) {
+				t.Errorf("got username filters %q, want %q", policy.UsernameFilters, tt.wantFilters)
+			}
+			if !reflect.DeepEqual(policy.ProhibitedDomains, tt.wantProhibited) {
+				t.Errorf("got prohibited domains %q, want %q", policy.ProhibitedDomains, tt.wantProhibited)
+			}
+			if !reflect.DeepEqual(policy.BlockedDomains, tt.wantBlocked) {
+				t.Errorf("got blocked domains %q, want %q", policy.BlockedDomains, tt.wantBlocked)
+			}
+			if policy.Messages.Prohibited != strings.TrimSpace(tt.form.Get("message_prohibited")) {
+				t.Errorf("got prohibited message %q", policy.Messages.Prohibited)
+			}
+		})
+	}
+}
+
+func TestGetPolicyPage(t *testing.T) {
+	logger, store := newTestStore(t)
+
+	rec := httptest.NewRecorder()
+	GetPolicyPage(logger, store, "cHVibGljLWtleQ==").ServeHTTP(rec, newSessionRequest(t, http.MethodGet, "/dashboard/policy", ""))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "cHVibGljLWtleQ==") {
+		t.Error("policy page does not show the public key")
+	}
+}
+
+// manyEntries returns n distinct username filters
+func manyEntries(n int) string {
+	entries := make([]string, n)
+	for i := range entries {
+		entries[i] = fmt.Sprintf("@team%d.example.com", i)
+	}
+	return strings.Join(entries, "\n")
+}
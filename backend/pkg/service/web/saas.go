@@ -0,0 +1,451 @@
+package web
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/auth/session"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxReviewOwnerLength         = 200
+	maxReviewJustificationLength = 2000
+	reviewDateLayout             = "2006-01-02"
+	// timelineDays is the number of days shown on the login timeline of an app
+	timelineDays = 30
+	// appCacheTTL is how long discovered apps are cached, newly discovered domains show up after it
+	appCacheTTL = time.Minute
+	// maxAppDomains bounds the domains grouped into apps, so a huge store cannot exhaust the memory of the dashboard
+	maxAppDomains = 100000
+)
+
+var appStatusBadges = map[models.AppStatus]string{
+	models.AppStatusNew:         "bg-secondary",
+	models.AppStatusUnderReview: "bg-info",
+	models.AppStatusSanctioned:  "bg-success",
+	models.AppStatusTolerated:   "bg-warning",
+	models.AppStatusProhibited:  "bg-danger",
+}
+
+// saasApp is a discovered app together with its review
+type saasApp struct {
+	catalog.Entry
+	Key       string
+	Review    models.AppReview
+	ReviewDue bool
+}
+
+// StatusBadge returns the badge class of the review status
+func (a saasApp) StatusBadge() string {
+	return appStatusBadges[a.Review.Status]
+}
+
+// timelineBar is a day on the login timeline, Percent is relative to the busiest day
+type timelineBar struct {
+	models.TimelineDay
+	Percent int
+}
+
+type saasDetailPageData struct {
+	baseData
+	Domain            string
+	HostLevel         bool
+	App               saasApp
+	Summary           models.DomainSummary
+	Search            string
+	Users             []models.DomainUser
+	UsersPagination   pagination
+	Devices           []models.DomainDevice
+	DevicesPagination pagination
+	Timeline          []timelineBar
+}
+
+// newSaasApp returns a discovered app with its review, apps without a review have the new status
+func newSaasApp(entry catalog.Entry, reviews map[string]models.AppReview, now time.Time) saasApp {
+	key := entry.Key()
+
+	review, found := reviews[key]
+	if !found {
+		review = models.AppReview{App: key, Status: models.AppStatusNew}
+	}
+
+	return saasApp{
+		Entry:     entry,
+		Key:       key,
+		Review:    review,
+		ReviewDue: review.ReviewDue(now),
+	}
+}
+
+// appStats counts the discovered apps by review outcome
+type appStats struct {
+	Total      int
+	Unreviewed int
+	Prohibited int
+}
+
+// loadDomains returns the first limit discovered domains at the given level, sorted on name,
+// and whether more domains were discovered than returned
+func loadDomains(ctx context.Context, store storage.Driver, level models.DomainLevel, limit int) ([]string, bool, error) {
+	query := models.Query{Limit: min(models.MaxLimit, limit), Level: level}
+	domains := make([]string, 0)
+
+	for {
+		page, err := store.GetAllDomains(ctx, query)
+		if err != nil {
+			return nil, false, err
+		}
+
+		domains = append(domains, page.Items...)
+
+		if page.NextCursor == "" {
+			return domains, false, nil
+		}
+		if len(domains) >= limit {
+			return domains[:limit], true, nil
+		}
+
+		query.Cursor = page.NextCursor
+		query.Limit = min(models.MaxLimit, limit-len(domains))
+	}
+}
+
+// matchesApp returns true if the app name, vendor, category, owner or one of its domains contains search
+func matchesApp(app saasApp, search string) bool {
+	search = strings.ToLower(search)
+
+	fields := append([]string{app.Name, app.Vendor, app.Category, app.Review.Owner}, app.Discovered...)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cachedApps are the discovered apps at a domain level
+type cachedApps struct {
+	// mutex is held while loading, so concurrent requests for the level wait for a single load
+	mutex     sync.Mutex
+	apps      []saasApp
+	truncated bool
+	loadedAt  time.Time
+}
+
+// AppCache keeps the discovered domains grouped by app together with their reviews,
+// so the dashboard does not load and group the domains and reviews on each request.
+// Apps are reloaded after appCacheTTL, or right away when a review changed.
+// Grouping needs the catalog, so it happens here instead of in the store, over at most maxAppDomains domains.
+type AppCache struct {
+	logger     *logrus.Logger
+	store      storage.Driver
+	appCatalog *catalog.Catalog
+	maxDomains int
+
+	mutex  sync.Mutex
+	levels map[models.DomainLevel]*cachedApps
+}
+
+// NewAppCache creates an empty cache of the apps discovered in store
+func NewAppCache(logger *logrus.Logger, store storage.Driver, appCatalog *catalog.Catalog) *AppCache {
+	return &AppCache{
+		logger:     logger,
+		store:      store,
+		appCatalog: appCatalog,
+		maxDomains: maxAppDomains,
+		levels:     make(map[models.DomainLevel]*cachedApps),
+	}
+}
+
+// level returns the cache of a domain level
+func (c *AppCache) level(level models.DomainLevel) *cachedApps {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, found := c.levels[level]
+	if !found {
+		cached = &cachedApps{}
+		c.levels[level] = cached
+	}
+
+	return cached
+}
+
+// Apps returns the discovered apps at the given level, sorted on name, and whether domains were left out
+// because more than maxAppDomains were discovered. The result is shared and must not be modified.
+func (c *AppCache) Apps(ctx context.Context, level models.DomainLevel) ([]saasApp, bool, error) {
+	cached := c.level(level)
+
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+
+	if !cached.loadedAt.IsZero() && time.Since(cached.loadedAt) < appCacheTTL {
+		return cached.apps, cached.truncated, nil
+	}
+
+	domains, truncated, err := loadDomains(ctx, c.store, level, c.maxDomains)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if truncated {
+		c.logger.WithFields(logrus.Fields{
+			"level":       level,
+			"max_domains": c.maxDomains,
+		}).Warn("too many discovered domains, only the first ones are grouped by app")
+	}
+
+	reviews, err := c.store.GetAppReviews(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	entries := c.appCatalog.Group(domains)
+	apps := make([]saasApp, 0, len(entries))
+
+	for _, entry := range entries {
+		apps = append(apps, newSaasApp(entry, reviews, now))
+	}
+
+	cached.apps, cached.truncated, cached.loadedAt = apps, truncated, now
+
+	return apps, truncated, nil
+}
+
+// Invalidate drops the cached apps, so a changed review shows on the next request
+func (c *AppCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// a load in progress keeps the cache it started with, the next request loads into a new one
+	clear(c.levels)
+}
+
+// newTimeline returns a bar for every day since the first day, including the days without logins
+func newTimeline(days []models.TimelineDay, first time.Time, count int) []timelineBar {
+	byDay := make(map[time.Time]models.TimelineDay, len(days))
+	busiest := 0
+
+	for _, day := range days {
+		byDay[day.Day.UTC()] = day
+		busiest = max(busiest, day.Logins)
+	}
+
+	bars := make([]timelineBar, 0, count)
+	for i := 0; i < count; i++ {
+		date := first.AddDate(0, 0, i)
+
+		day, found := byDay[date]
+		if !found {
+			day = models.TimelineDay{Day: date}
+		}
+
+		bar := timelineBar{TimelineDay: day}
+		if busiest > 0 {
+			bar.Percent = day.Logins * 100 / busiest
+		}
+
+		bars = append(bars, bar)
+	}
+
+	return bars
+}
+
+// countApps counts the apps nobody reviewed yet and the prohibited apps still in use
+func countApps(apps []saasApp) appStats {
+	stats := appStats{Total: len(apps)}
+
+	for _, app := range apps {
+		switch app.Review.Status {
+		case models.AppStatusNew:
+			stats.Unreviewed++
+		case models.AppStatusProhibited:
+			stats.Prohibited++
+		}
+	}
+
+	return stats
+}
+
+// SaaS detail page handler, shows who uses a discovered domain, from which devices and how securely
+func GetSaasDetailPage(logger *logrus.Logger, store storage.Driver, appCatalog *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		domain := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/dashboard/saas/"))
+		if domain == "" || strings.Contains(domain, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		// a subdomain is only stored as host, so it can only be shown at that level
+		level := models.ParseDomainLevel(r.URL.Query().Get("level"))
+		if domainname.Normalize(domain).Domain != domain {
+			level = models.DomainLevelHost
+		}
+
+		summary, found, err := store.GetDomainSummary(r.Context(), domain, level)
+		if err != nil {
+			logger.WithError(err).WithField("domain", domain).Error("error getting domain summary")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		usersQuery := queryFromRequest(r, "users_cursor")
+		users, err := store.GetDomainUsers(r.Context(), domain, level, usersQuery)
+		if err != nil {
+			logger.WithError(err).WithField("domain", domain).Error("error getting domain users")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		devicesQuery := queryFromRequest(r, "devices_cursor")
+		devices, err := store.GetDomainDevices(r.Context(), domain, level, devicesQuery)
+		if err != nil {
+			logger.WithError(err).WithField("domain", domain).Error("error getting domain devices")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		first := today.AddDate(0, 0, 1-timelineDays)
+
+		timeline, err := store.GetDomainTimeline(r.Context(), domain, level, first)
+		if err != nil {
+			logger.WithError(err).WithField("domain", domain).Error("error getting domain timeline")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		reviews, err := store.GetAppReviews(r.Context())
+		if err != nil {
+			logger.WithError(err).Error("error getting app reviews")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := saasDetailPageData{
+			baseData: baseData{
+				Title:       domain,
+				Username:    user.Email,
+				CurrentPage: "saas",
+			},
+			Domain:            domain,
+			HostLevel:         level == models.DomainLevelHost,
+			App:               newSaasApp(appCatalog.Group([]string{domain})[0], reviews, time.Now()),
+			Summary:           summary,
+			Search:            usersQuery.Search,
+			Users:             users.Items,
+			UsersPagination:   newPagination(r, "users_cursor", users.NextCursor),
+			Devices:           devices.Items,
+			DevicesPagination: newPagination(r, "devices_cursor", devices.NextCursor),
+			Timeline:          newTimeline(timeline, first, timelineDays),
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := saasDetailTmpl.Execute(w, data); err != nil {
+			logger.WithError(err).Error("error rendering template")
+			http.Error(w, "Template Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// ReviewApp stores the review of a discovered app submitted from the SaaS page
+func ReviewApp(logger *logrus.Logger, store storage.Driver, appCache *AppCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		app := strings.TrimSpace(r.FormValue("app"))
+		if app == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		status, err := models.ParseAppStatus(r.FormValue("status"))
+		if err != nil {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		owner := strings.TrimSpace(r.FormValue("owner"))
+		justification := strings.TrimSpace(r.FormValue("justification"))
+		if len(owner) > maxReviewOwnerLength || len(justification) > maxReviewJustificationLength {
+			http.Error(w, "Owner or justification too long", http.StatusBadRequest)
+			return
+		}
+
+		var reviewDate time.Time
+		if value := r.FormValue("review_date"); value != "" {
+			if reviewDate, err = time.Parse(reviewDateLayout, value); err != nil {
+				http.Error(w, "Invalid review date", http.StatusBadRequest)
+				return
+			}
+		}
+
+		review := models.AppReview{
+			App:           app,
+			Status:        status,
+			Owner:         owner,
+			Justification: justification,
+			ReviewDate:    reviewDate,
+			UpdatedBy:     user.Email,
+			UpdatedAt:     time.Now(),
+		}
+
+		if err := store.SetAppReview(r.Context(), review); err != nil {
+			logger.WithError(err).WithField("app", app).Error("error storing app review")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		appCache.Invalidate()
+
+		logger.WithFields(logrus.Fields{
+			"app":    app,
+			"status": status,
+			"admin":  user.Email,
+		}).Info("reviewed app")
+
+		// return to the page the review was submitted from, only its query is taken over
+		redirect := "/dashboard/saas"
+		if params, err := url.ParseQuery(r.FormValue("return")); err == nil && len(params) > 0 {
+			redirect += "?" + params.Encode()
+		}
+
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,345 @@
+package web
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppCacheApps(t *testing.T) {
+	logger, store := newTestStore(t)
+	ctx := context.Background()
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	logins := []struct {
+		host, domain string
+	}{
+		{"app.slack.com", "slack.com"},
+		{"acme.slack.com", "slack.com"},
+		{"www.dropbox.com", "dropbox.com"},
+		{"intranet.example.org", "example.org"},
+	}
+	for _, login := range logins {
+		event := events.LoginEvent{Timestamp: time.Now(), User: "alice", Domain: login.domain, Host: login.host, DeviceID: "laptop", Hash: login.host}
+		if err := store.AddLoginEvent(ctx, event); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		maxDomains    int
+		level         models.DomainLevel
+		wantApps      []string
+		wantTruncated bool
+	}{
+		{name: "registrable domains", maxDomains: maxAppDomains, level: models.DomainLevelRegistrable, wantApps: []string{"Dropbox", "example.org", "Slack"}},
+		{name: "hosts of one app are grouped", maxDomains: maxAppDomains, level: models.DomainLevelHost, wantApps: []string{"Dropbox", "intranet.example.org", "Slack"}},
+		// the domains are loaded in alphabetical order, so example.org is left out
+		{name: "bounded", maxDomains: 1, level: models.DomainLevelRegistrable, wantApps: []string{"Dropbox"}, wantTruncated: true},
+		{name: "bound equal to the domains", maxDomains: 3, level: models.DomainLevelRegistrable, wantApps: []string{"Dropbox", "example.org", "Slack"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appCache := NewAppCache(logger, store, appCatalog)
+			appCache.maxDomains = tt.maxDomains
+
+			apps, truncated, err := appCache.Apps(ctx, tt.level)
+			if err != nil {
+				t.Fatalf("failed to get apps: %v", err)
+			}
+
+			names := make([]string, 0, len(apps))
+			for _, app := range apps {
+				names = append(names, app.Name)
+			}
+
+			if truncated != tt.wantTruncated || len(names) != len(tt.wantApps) {
+				t.Fatalf("got apps %q truncated %v, want %q truncated %v", names, truncated, tt.wantApps, tt.wantTruncated)
+			}
+			for i := range names {
+				if names[i] != tt.wantApps[i] {
+					t.Errorf("got apps %q, want %q", names, tt.wantApps)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestAppCacheInvalidate(t *testing.T) {
+	logger, store := newTestStore(t)
+	ctx := context.Background()
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	event := events.LoginEvent{Timestamp: time.Now(), User: "alice", Domain: "dropbox.com", Host: "www.dropbox.com", DeviceID: "laptop", Hash: "a"}
+	if err := store.AddLoginEvent(ctx, event); err != nil {
+		t.Fatalf("failed to add login event: %v", err)
+	}
+
+	appCache := NewAppCache(logger, store, appCatalog)
+	if _, _, err := appCache.Apps(ctx, models.DomainLevelRegistrable); err != nil {
+		t.Fatalf("failed to get apps: %v", err)
+	}
+
+	if err := store.SetAppReview(ctx, models.AppReview{App: "Dropbox", Status: models.AppStatusProhibited}); err != nil {
+		t.Fatalf("failed to set review: %v", err)
+	}
+
+	// cached until invalidated
+	apps, _, _ := appCache.Apps(ctx, models.DomainLevelRegistrable)
+	if apps[0].Review.Status != models.AppStatusNew {
+		t.Errorf("got status %s before invalidating, want the cached status", apps[0].Review.Status)
+	}
+
+	appCache.Invalidate()
+
+	apps, _, _ = appCache.Apps(ctx, models.DomainLevelRegistrable)
+	if apps[0].Review.Status != models.AppStatusProhibited {
+		t.Errorf("got status %s after invalidating, want %s", apps[0].Review.Status, models.AppStatusProhibited)
+	}
+}
+
+func TestReviewApp(t *testing.T) {
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+		wantReview   models.AppReview
+	}{
+		{
+			name:   "review",
+			method: http.MethodPost,
+			form: url.Values{
+				"app":           {"Dropbox"},
+				"status":        {"prohibited"},
+				"owner":         {" IT "},
+				"justification": {"Use the company drive"},
+				"review_date":   {"2026-01-31"},
+				"return":        {"status=new&q=drop"},
+			},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/saas?q=drop&status=new",
+			wantReview: models.AppReview{
+				App:           "Dropbox",
+				Status:        models.AppStatusProhibited,
+				Owner:         "IT",
+				Justification: "Use the company drive",
+				ReviewDate:    time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC),
+				UpdatedBy:     testAdmin,
+			},
+		},
+		{
+			// only the query is taken over, so the redirect cannot leave the dashboard
+			name:         "return to another site",
+			method:       http.MethodPost,
+			form:         url.Values{"app": {"Dropbox"}, "status": {"sanctioned"}, "return": {"//evil.example.com/"}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/saas?%2F%2Fevil.example.com%2F=",
+			wantReview:   models.AppReview{App: "Dropbox", Status: models.AppStatusSanctioned, UpdatedBy: testAdmin},
+		},
+		{name: "missing app", method: http.MethodPost, form: url.Values{"status": {"sanctioned"}}, wantStatus: http.StatusBadRequest},
+		{name: "unknown status", method: http.MethodPost, form: url.Values{"app": {"Dropbox"}, "status": {"approved"}}, wantStatus: http.StatusBadRequest},
+		{
+			name:       "owner too long",
+			method:     http.MethodPost,
+			form:       url.Values{"app": {"Dropbox"}, "status": {"sanctioned"}, "owner": {strings.Repeat("a", maxReviewOwnerLength+1)}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid review date",
+			method:     http.MethodPost,
+			form:       url.Values{"app": {"Dropbox"}, "status": {"sanctioned"}, "review_date": {"31-01-2026"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "wrong method", method: http.MethodGet, form: url.Values{}, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, store := newTestStore(t)
+			ctx := context.Background()
+
+			event := events.LoginEvent{Timestamp: time.Now(), User: "alice", Domain: "dropbox.com", Host: "www.dropbox.com", DeviceID: "laptop", Hash: "a"}
+			if err := store.AddLoginEvent(ctx, event); err != nil {
+				t.Fatalf("failed to add login event: %v", err)
+			}
+
+			appCache := NewAppCache(logger, store, appCatalog)
+			if _, _, err := appCache.Apps(ctx, models.DomainLevelRegistrable); err != nil {
+				t.Fatalf("failed to get apps: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			ReviewApp(logger, store, appCache).ServeHTTP(rec, newSessionRequest(t, tt.method, "/dashboard/saas/review", tt.form.Encode()))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("got location %q, want %q", location, tt.wantLocation)
+			}
+
+			reviews, err := store.GetAppReviews(ctx)
+			if err != nil {
+				t.Fatalf("failed to get reviews: %v", err)
+			}
+
+			review, found := reviews["Dropbox"]
+			if found != (tt.wantStatus == http.StatusSeeOther) {
+				t.Fatalf("got review stored %v for status %d", found, rec.Code)
+			}
+			if !found {
+				return
+			}
+
+			review.UpdatedAt = time.Time{}
+			if !reflect.DeepEqual(review, tt.wantReview) {
+				t.Errorf("got review %+v, want %+v", review, tt.wantReview)
+			}
+
+			// the review shows right away instead of after the cache expired
+			apps, _, _ := appCache.Apps(ctx, models.DomainLevelRegistrable)
+			if apps[0].Review.Status != tt.wantReview.Status {
+				t.Errorf("got cached status %s, want %s", apps[0].Review.Status, tt.wantReview.Status)
+			}
+		})
+	}
+}
+
+func TestCountApps(t *testing.T) {
+	apps := []saasApp{
+		{Review: models.AppReview{Status: models.AppStatusNew}},
+		{Review: models.AppReview{Status: models.AppStatusNew}},
+		{Review: models.AppReview{Status: models.AppStatusUnderReview}},
+		{Review: models.AppReview{Status: models.AppStatusProhibited}},
+		{Review: models.AppReview{Status: models.AppStatusSanctioned}},
+	}
+
+	want := appStats{Total: 5, Unreviewed: 2, Prohibited: 1}
+	if got := countApps(apps); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetSaasDetailPage(t *testing.T) {
+	logger, store := newTestStore(t)
+	ctx := context.Background()
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	logins := []events.LoginEvent{
+		{User: "alice@example.com", Domain: "slack.com", Host: "app.slack.com", DeviceID: "alice-laptop", Hash: "a"},
+		{User: "bob@example.com", Domain: "slack.com", Host: "acme.slack.com", DeviceID: "bob-desktop", Hash: "b"},
+	}
+	for _, login := range logins {
+		login.Timestamp = time.Now()
+		if err := store.AddLoginEvent(ctx, login); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantShown  []string
+		wantHidden []string
+	}{
+		{
+			name:       "registrable domain",
+			target:     "/dashboard/saas/slack.com",
+			wantStatus: http.StatusOK,
+			wantShown:  []string{"Slack", "alice@example.com", "bob@example.com", "alice-laptop", "bob-desktop"},
+		},
+		{
+			// a subdomain is only stored as host
+			name:       "host",
+			target:     "/dashboard/saas/App.Slack.com",
+			wantStatus: http.StatusOK,
+			wantShown:  []string{"app.slack.com", "alice@example.com", "alice-laptop"},
+			wantHidden: []string{"bob@example.com", "bob-desktop"},
+		},
+		{name: "unknown domain", target: "/dashboard/saas/dropbox.com", wantStatus: http.StatusNotFound},
+		{name: "registrable domain at host level", target: "/dashboard/saas/slack.com?level=host", wantStatus: http.StatusNotFound},
+		{name: "no domain", target: "/dashboard/saas/", wantStatus: http.StatusNotFound},
+		{name: "nested path", target: "/dashboard/saas/slack.com/users", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			GetSaasDetailPage(logger, store, appCatalog).ServeHTTP(rec, newSessionRequest(t, http.MethodGet, tt.target, ""))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			body := rec.Body.String()
+			for _, shown := range tt.wantShown {
+				if !strings.Contains(body, shown) {
+					t.Errorf("page does not show %s", shown)
+				}
+			}
+			for _, hidden := range tt.wantHidden {
+				if strings.Contains(body, hidden) {
+					t.Errorf("page shows %s", hidden)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTimeline(t *testing.T) {
+	first := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	days := []models.TimelineDay{
+		{Day: first.AddDate(0, 0, 1), Logins: 4, Users: 2},
+		{Day: first.AddDate(0, 0, 3), Logins: 1, Users: 1},
+	}
+
+	bars := newTimeline(days, first, 4)
+
+	want := []timelineBar{
+		{TimelineDay: models.TimelineDay{Day: first}},
+		{TimelineDay: days[0], Percent: 100},
+		{TimelineDay: models.TimelineDay{Day: first.AddDate(0, 0, 2)}},
+		{TimelineDay: days[1], Percent: 25},
+	}
+	if !reflect.DeepEqual(bars, want) {
+		t.Errorf("got %+v, want %+v", bars, want)
+	}
+
+	// days without logins do not divide by zero
+	for _, bar := range newTimeline(nil, first, 2) {
+		if bar.Percent != 0 || bar.Logins != 0 {
+			t.Errorf("got %+v for a day without logins", bar)
+		}
+	}
+}
@@ -4,9 +4,14 @@ function filterTable() {
 	var table = document.getElementById("saasTable");
 	var tr = table.getElementsByTagName("tr");
 	for (var i = 1; i < tr.length; i++) {
-		var td = tr[i].getElementsByTagName("td")[0];
-		if (td) {
-			var txtValue = td.textContent || td.innerText;
+		// review forms are toggled by their app row
+		if (tr[i].classList.contains("review-form")) {
+			continue;
+		}
+		// match the whole row, so vendors and categories can be searched as well
+		var tds = tr[i].getElementsByTagName("td");
+		if (tds.length) {
+			var txtValue = tr[i].textContent || tr[i].innerText;
 			if (txtValue.toUpperCase().indexOf(filter) > -1) {
 				tr[i].style.display = "";
 			} else {
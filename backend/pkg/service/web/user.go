@@ -0,0 +1,205 @@
+package web
+
+import (
+	"github.com/hazcod/shade/pkg/auth/session"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var riskBadges = map[string]string{
+	"low":      "bg-success",
+	"medium":   "bg-warning text-dark",
+	"high":     "bg-danger",
+	"critical": "bg-dark",
+}
+
+// userAccount is the account of a user on a domain of an app
+type userAccount struct {
+	models.UserAccount
+	// SharedWith are the other domains a current password of the account is used on
+	SharedWith []string
+}
+
+// userApp is an app a user logged in to, with their account on every discovered domain of it
+type userApp struct {
+	saasApp
+	Accounts []userAccount
+}
+
+type userDetailPageData struct {
+	baseData
+	User       string
+	Risk       models.UserRisk
+	RiskBadge  string
+	Apps       []userApp
+	Shared     [][]string
+	Devices    []models.UserDevice
+	Events     []models.UserEvent
+	Descending bool
+	OrderURL   string
+	Pagination pagination
+}
+
+// sharedDomains returns the domains every domain shares a current password with
+func sharedDomains(groups [][]string) map[string][]string {
+	shared := make(map[string][]string)
+
+	for _, group := range groups {
+		for _, domain := range group {
+			for _, other := range group {
+				if other != domain {
+					shared[domain] = append(shared[domain], other)
+				}
+			}
+		}
+	}
+
+	return shared
+}
+
+// User detail page handler, shows what a user logs in to, how securely and how risky that makes them
+func GetUserDetailPage(logger *logrus.Logger, store storage.Driver, appCatalog *catalog.Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := session.GetUser(r)
+		if err != nil {
+			logger.WithError(err).Error("error getting user from session")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		username := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/dashboard/users/"))
+		if username == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		domains, err := store.GetDomainsForUser(r.Context(), username)
+		if err != nil {
+			logger.WithError(err).WithField("username", username).Error("error getting domains for user")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(domains) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		accounts, err := store.GetUserAccounts(r.Context(), username)
+		if err != nil {
+			logger.WithError(err).WithField("username", username).Error("error getting user accounts")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		shared, err := store.GetDuplicatePasswordsForUser(r.Context(), username, "", models.DomainLevelRegistrable)
+		if err != nil {
+			logger.WithError(err).WithField("username", username).Error("error getting duplicate passwords for user")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		devices, err := store.GetUserDevices(r.Context(), username)
+		if err != nil {
+			logger.WithError(err).WithField("username", username).Error("error getting user devices")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		query := queryFromRequest(r, "cursor")
+		userEvents, err := store.GetUserEvents(r.Context(), username, query)
+		if err != nil {
+			logger.WithError(err).WithField("username", username).Error("error getting user events")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		reviews, err := store.GetAppReviews(r.Context())
+		if err != nil {
+			logger.WithError(err).Error("error getting app reviews")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		byDomain := make(map[string]models.UserAccount, len(accounts))
+		for _, account := range accounts {
+			byDomain[account.Domain] = account
+		}
+		sharedWith := sharedDomains(shared)
+
+		var breached, prohibited, reused, withoutMFA int
+
+		now := time.Now()
+		apps := make([]userApp, 0)
+		for _, entry := range appCatalog.Group(domains) {
+			app := userApp{saasApp: newSaasApp(entry, reviews, now)}
+			if app.Review.Status == models.AppStatusProhibited {
+				prohibited++
+			}
+
+			for _, domain := range entry.Discovered {
+				account := userAccount{UserAccount: byDomain[domain], SharedWith: sharedWith[domain]}
+				account.Domain = domain
+
+				if account.BreachCount > 0 {
+					breached++
+				}
+				if len(account.SharedWith) > 0 {
+					reused++
+				}
+				if !account.HasMFA {
+					withoutMFA++
+				}
+
+				app.Accounts = append(app.Accounts, account)
+			}
+
+			apps = append(apps, app)
+		}
+
+		risk := models.NewUserRisk(breached, prohibited, reused, withoutMFA)
+
+		// switch the history between oldest and newest first, starting at the first page
+		params := r.URL.Query()
+		params.Del("cursor")
+		if query.Descending {
+			params.Del("order")
+		} else {
+			params.Set("order", "desc")
+		}
+
+		data := userDetailPageData{
+			baseData: baseData{
+				Title:       username,
+				Username:    user.Email,
+				CurrentPage: "security",
+			},
+			User:       username,
+			Risk:       risk,
+			RiskBadge:  riskBadges[risk.Level()],
+			Apps:       apps,
+			Shared:     shared,
+			Devices:    devices,
+			Events:     userEvents.Items,
+			Descending: query.Descending,
+			OrderURL:   "?" + params.Encode(),
+			Pagination: newPagination(r, "cursor", userEvents.NextCursor),
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := userDetailTmpl.Execute(w, data); err != nil {
+			logger.WithError(err).Error("error rendering template")
+			http.Error(w, "Template Error", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/catalog"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedDomains(t *testing.T) {
+	got := sharedDomains([][]string{{"github.com", "slack.com"}, {"dropbox.com", "github.com", "box.com"}})
+
+	want := map[string][]string{
+		"github.com":  {"slack.com", "dropbox.com", "box.com"},
+		"slack.com":   {"github.com"},
+		"dropbox.com": {"github.com", "box.com"},
+		"box.com":     {"dropbox.com", "github.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetUserDetailPage(t *testing.T) {
+	logger, store := newTestStore(t)
+	ctx := context.Background()
+
+	appCatalog, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("failed to load catalog: %v", err)
+	}
+
+	logins := []events.LoginEvent{
+		{User: "alice@example.com", Domain: "slack.com", Host: "app.slack.com", DeviceID: "alice-laptop", Hash: "shared", HasMFA: true},
+		{User: "alice@example.com", Domain: "github.com", Host: "github.com", DeviceID: "alice-laptop", Hash: "shared"},
+		{User: "alice@example.com", Domain: "dropbox.com", Host: "www.dropbox.com", DeviceID: "alice-phone", Hash: "unique", HasMFA: true},
+		{User: "bob@example.com", Domain: "slack.com", Host: "app.slack.com", DeviceID: "bob-desktop", Hash: "bob"},
+	}
+	for _, login := range logins {
+		login.Timestamp = time.Now()
+		if err := store.AddLoginEvent(ctx, login); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	if err := store.StoreHIBPResult(ctx, "unique", 5); err != nil {
+		t.Fatalf("failed to store HIBP result: %v", err)
+	}
+	if err := store.SetAppReview(ctx, models.AppReview{App: "Dropbox", Status: models.AppStatusProhibited}); err != nil {
+		t.Fatalf("failed to set review: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantShown  []string
+		wantHidden []string
+	}{
+		{
+			name:       "user",
+			target:     "/dashboard/users/Alice@Example.com",
+			wantStatus: http.StatusOK,
+			wantShown: []string{
+				"alice@example.com", "alice-laptop", "alice-phone", "Slack", "Dropbox",
+				// breached, prohibited, two shared passwords and one app without MFA
+				"75 &middot; critical",
+				"Breached passwords: 1 (+30)",
+				"Prohibited apps: 1 (+20)",
+				"Shared passwords: 2 (+20)",
+				"Apps without MFA: 1 (+5)",
+			},
+			wantHidden: []string{"bob@example.com", "bob-desktop"},
+		},
+		{name: "unknown user", target: "/dashboard/users/carol@example.com", wantStatus: http.StatusNotFound},
+		{name: "no user", target: "/dashboard/users/", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			GetUserDetailPage(logger, store, appCatalog).ServeHTTP(rec, newSessionRequest(t, http.MethodGet, tt.target, ""))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			body := rec.Body.String()
+			for _, shown := range tt.wantShown {
+				if !strings.Contains(body, shown) {
+					t.Errorf("page does not show %s", shown)
+				}
+			}
+			for _, hidden := range tt.wantHidden {
+				if strings.Contains(body, hidden) {
+					t.Errorf("page shows %s", hidden)
+				}
+			}
+		})
+	}
+}
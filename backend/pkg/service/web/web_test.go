@@ -0,0 +1,53 @@
+package web
+
+import (
+	"github.com/hazcod/shade/pkg/auth/session"
+	"github.com/hazcod/shade/pkg/model"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdmin = "admin@example.com"
+
+func init() {
+	session.Initialize("0123456789abcdef0123456789abcdef", true)
+}
+
+func newTestStore(t *testing.T) (*logrus.Logger, *memory.InMemoryStore) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	store := &memory.InMemoryStore{}
+	if err := store.Init(logger, map[string]string{"token": "bootstrap"}); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+
+	return logger, store
+}
+
+// newSessionRequest returns a request of the signed in testAdmin, body is sent as a form when not empty
+func newSessionRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := session.SetUser(rec, httptest.NewRequest(http.MethodGet, "/", nil), &model.User{Email: testAdmin}); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	return req
+}
@@ -1,26 +1,94 @@
 package storage
 
 import (
+	"context"
+	"github.com/hazcod/shade/pkg/domainname"
 	"github.com/hazcod/shade/pkg/events"
 	"github.com/hazcod/shade/pkg/models"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 type Driver interface {
 	Init(logger *logrus.Logger, settings map[string]string) error
-	AddLoginEvent(data events.LoginEvent) error
-	GetAllDomains() ([]string, error)
-	GetDomainsForUser(username string) ([]string, error)
-	GetDuplicatePasswordsForUser(username string) ([][]string, error)
-	IsDuplicatePassword(username, passwordHash string) ([]string, error)
-	GetDuplicatePasswords() (map[string]map[string]string, error)
-	IsValidToken(token string) (bool, error)
-	GetCompromisedPasswords() (map[string]string, error)
-	GetEnrolledUsers() ([]models.EnrolledUser, error)
-	GetDashboardStats() (models.DashboardStats, error)
-	GetUsersWithoutMFA() ([]string, error)
+	// Close flushes pending writes and releases the connections of the driver
+	Close() error
+	AddLoginEvent(ctx context.Context, data events.LoginEvent) error
+	// GetAllDomains returns the registrable domains or the hosts logged in to, depending on the query level
+	GetAllDomains(ctx context.Context, query models.Query) (models.Page[string], error)
+	GetDomainsForUser(ctx context.Context, username string) ([]string, error)
+	// GetDuplicatePasswordsForDevice returns the groups of 2+ domains sharing a current password submitted by
+	// the device itself. An empty passwordHash returns all groups.
+	GetDuplicatePasswordsForDevice(ctx context.Context, deviceID, passwordHash string, level models.DomainLevel) ([][]string, error)
+	// Aggregates of the logins on a single domain, which is a registrable domain or a host depending on the level.
+	// GetDomainSummary returns false when nobody logged in on the domain.
+	GetDomainSummary(ctx context.Context, domain string, level models.DomainLevel) (models.DomainSummary, bool, error)
+	GetDomainUsers(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainUser], error)
+	GetDomainDevices(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainDevice], error)
+	// GetDomainTimeline returns the days since the given time with logins on the domain, oldest first
+	GetDomainTimeline(ctx context.Context, domain string, level models.DomainLevel, since time.Time) ([]models.TimelineDay, error)
+	// Aggregates of the logins of a single user across their devices.
+	// GetDuplicatePasswordsForUser returns the groups of 2+ domains sharing a current password of the user,
+	// across all of their devices. An empty passwordHash returns all groups.
+	GetDuplicatePasswordsForUser(ctx context.Context, username, passwordHash string, level models.DomainLevel) ([][]string, error)
+	// GetUserAccounts returns an account per registrable domain the user logged in on, sorted on domain
+	GetUserAccounts(ctx context.Context, username string) ([]models.UserAccount, error)
+	// GetUserDevices returns every device and IP address the user logged in from, most recently used first
+	GetUserDevices(ctx context.Context, username string) ([]models.UserDevice, error)
+	// GetUserEvents returns the logins, enforcement decisions and breached passwords of the user, oldest first
+	// unless the query is descending
+	GetUserEvents(ctx context.Context, username string, query models.Query) (models.Page[models.UserEvent], error)
+	IsDuplicatePassword(ctx context.Context, username, passwordHash string) ([]string, error)
+	GetDuplicatePasswords(ctx context.Context, query models.Query) (models.Page[models.DuplicatePasswordEntry], error)
+	// IsValidToken checks the bootstrap token devices present when enrolling, in constant time
+	IsValidToken(ctx context.Context, token string) (bool, error)
+	// Device enrollment, credentials are only ever stored hashed and signing keys encrypted
+	EnrollDevice(ctx context.Context, deviceID, credentialHash, signingKey string) error
+	GetDeviceForCredential(ctx context.Context, credentialHash string) (string, bool, error)
+	// GetDeviceSigningKey returns the encrypted request signing key of a device, empty if it has none
+	GetDeviceSigningKey(ctx context.Context, deviceID string) (string, error)
+	RevokeDevice(ctx context.Context, deviceID string) error
+	// ResetDevice clears the credential and revocation of a device so it can enroll again
+	ResetDevice(ctx context.Context, deviceID string) error
+	// UseNonce records a request nonce until it expires, false means it was used before
+	UseNonce(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+	DeleteExpiredNonces(ctx context.Context, now time.Time) error
+	GetCompromisedPasswords(ctx context.Context) (map[string]string, error)
+	// GetCompromisedAccounts returns the accounts submitted by a device whose latest password is breached
+	GetCompromisedAccounts(ctx context.Context, deviceID string) ([]models.CompromisedAccount, error)
+	GetEnrolledUsers(ctx context.Context, query models.Query) (models.Page[models.EnrolledUser], error)
+	GetDashboardStats(ctx context.Context) (models.DashboardStats, error)
+	GetUsersWithoutMFA(ctx context.Context, query models.Query) (models.Page[string], error)
 	// HIBP-related methods
-	StoreHIBPResult(passwordHash string, breachCount int) error
-	GetHIBPResult(passwordHash string) (int, bool, error)
-	GetAllPasswordHashes() ([]string, error)
+	StoreHIBPResult(ctx context.Context, passwordHash string, breachCount int) error
+	GetHIBPResult(ctx context.Context, passwordHash string) (models.HIBPResult, bool, error)
+	// GetStalePasswordHashes returns the passwords that can be checked against HIBP and were never checked
+	// or last checked before checkedBefore
+	GetStalePasswordHashes(ctx context.Context, checkedBefore time.Time) ([]models.StalePasswordHash, error)
+	AddBreachEvent(ctx context.Context, event events.BreachEvent) error
+	// Breach verdicts of asynchronous checks, taking them removes them so they are reported once
+	AddHIBPVerdict(ctx context.Context, verdict models.HIBPVerdict) error
+	TakeHIBPVerdicts(ctx context.Context, deviceID string) ([]models.HIBPVerdict, error)
+	// Approval workflow of discovered apps, keyed on AppReview.App
+	GetAppReviews(ctx context.Context) (map[string]models.AppReview, error)
+	SetAppReview(ctx context.Context, review models.AppReview) error
+	// Enforcement decisions on logins, including the logins that were allowed.
+	// An empty action returns the events of every action, most recent first.
+	AddEnforcementEvent(ctx context.Context, event events.EnforcementEvent) error
+	GetEnforcementEvents(ctx context.Context, query models.Query, action models.EnforcementAction) (models.Page[events.EnforcementEvent], error)
+	// Extension policy, GetPolicy returns the latest version and StorePolicy returns the version it was stored as
+	GetPolicy(ctx context.Context) (models.Policy, bool, error)
+	StorePolicy(ctx context.Context, policy models.Policy) (int, error)
+	// HIBP range cache, shared by all replicas. Getting a range marks it as recently used.
+	GetHIBPRange(ctx context.Context, prefix string) (models.HIBPRange, bool, error)
+	StoreHIBPRange(ctx context.Context, prefix, body string) error
+	// PruneHIBPRanges removes ranges fetched before the given time and the least recently used ones
+	// beyond maxEntries, returning the number of removed ranges
+	PruneHIBPRanges(ctx context.Context, fetchedBefore time.Time, maxEntries int) (int, error)
+	// RekeyPasswordHashes rewrites every stored fingerprint and breach hash after a fingerprint key rotation,
+	// returning the number of values that changed
+	RekeyPasswordHashes(ctx context.Context, rekeyFingerprint, rekeyBreachHash func(string) (string, error)) (int, error)
+	// NormalizeDomains recomputes the host, registrable domain and tenant of every stored host,
+	// returning the number of login events that changed
+	NormalizeDomains(ctx context.Context, normalize func(host string) domainname.Name) (int, error)
 }
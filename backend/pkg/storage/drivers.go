@@ -3,6 +3,8 @@ package storage
 import (
 	"fmt"
 	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/hazcod/shade/pkg/storage/postgres"
+	"github.com/hazcod/shade/pkg/storage/sqlite"
 	"github.com/sirupsen/logrus"
 	"strings"
 )
@@ -15,6 +17,18 @@ func GetDriver(logger *logrus.Logger, driverName string, properties map[string]s
 			return nil, fmt.Errorf("failed to create memory driver: %v", err)
 		}
 		return driver, nil
+	case "sqlite":
+		driver := &sqlite.SQLiteStore{}
+		if err := driver.Init(logger, properties); err != nil {
+			return nil, fmt.Errorf("failed to create sqlite driver: %v", err)
+		}
+		return driver, nil
+	case "postgres":
+		driver := &postgres.PostgresStore{}
+		if err := driver.Init(logger, properties); err != nil {
+			return nil, fmt.Errorf("failed to create postgres driver: %v", err)
+		}
+		return driver, nil
 	default:
 		return nil, fmt.Errorf("unknown driver: %s", driverName)
 	}
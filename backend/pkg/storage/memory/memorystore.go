@@ -1,12 +1,18 @@
 package memory
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/hazcod/shade/pkg/domainname"
 	"github.com/hazcod/shade/pkg/events"
 	"github.com/hazcod/shade/pkg/models"
 	"github.com/sirupsen/logrus"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,15 +21,40 @@ import (
 type InMemoryStore struct {
 	logger *logrus.Logger
 
-	mutex       sync.RWMutex
-	data        map[string][]events.LoginEvent
-	hibpResults map[string]int // passwordHash -> breachCount
-	token       string
+	mutex        sync.RWMutex
+	data         map[string][]events.LoginEvent
+	hibpResults  map[string]models.HIBPResult // passwordHash -> last result
+	breachEvents []events.BreachEvent
+	hibpRanges   map[string]*hibpRange           // prefix -> cached range
+	hibpVerdicts map[string][]models.HIBPVerdict // deviceID -> verdicts not yet reported
+	appReviews   map[string]models.AppReview     // app -> review
+	policies     []models.Policy                 // every version, the latest last
+	enforcement  []events.EnforcementEvent       // oldest first
+	devices      map[string]*device
+	nonces       map[string]time.Time // nonce -> expiry
+	token        string
+}
+
+type hibpRange struct {
+	models.HIBPRange
+	lastUsedAt time.Time
+}
+
+type device struct {
+	credentialHash string
+	signingKey     string
+	enrolledAt     time.Time
+	revoked        bool
 }
 
 func (s *InMemoryStore) Init(logger *logrus.Logger, settings map[string]string) error {
 	s.data = make(map[string][]events.LoginEvent)
-	s.hibpResults = make(map[string]int)
+	s.hibpResults = make(map[string]models.HIBPResult)
+	s.hibpRanges = make(map[string]*hibpRange)
+	s.hibpVerdicts = make(map[string][]models.HIBPVerdict)
+	s.appReviews = make(map[string]models.AppReview)
+	s.devices = make(map[string]*device)
+	s.nonces = make(map[string]time.Time)
 	s.logger = logger
 
 	token, ok := settings["token"]
@@ -36,15 +67,45 @@ func (s *InMemoryStore) Init(logger *logrus.Logger, settings map[string]string)
 	return nil
 }
 
-func (s *InMemoryStore) GetAllDomains() ([]string, error) {
+// Close is a no-op, everything stored in memory is lost on exit
+func (s *InMemoryStore) Close() error {
+	return nil
+}
+
+// matchesSearch returns true if value contains the search text of the query
+func matchesSearch(value string, query models.Query) bool {
+	if query.Search == "" {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(value), strings.ToLower(query.Search))
+}
+
+// timeKey formats a timestamp as a sort key, a fixed width keeps the text order chronological
+func timeKey(timestamp time.Time) string {
+	return timestamp.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// domainAt returns the domain of an event at the given level
+func domainAt(event events.LoginEvent, level models.DomainLevel) string {
+	if level == models.DomainLevelHost && event.Host != "" {
+		return strings.ToLower(event.Host)
+	}
+
+	return strings.ToLower(event.Domain)
+}
+
+func (s *InMemoryStore) GetAllDomains(_ context.Context, query models.Query) (models.Page[string], error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	domains := make(map[string]struct{})
 
 	for _, deviceID := range s.data {
 		for _, eventEntry := range deviceID {
-			domain := strings.ToLower(eventEntry.Domain)
+			domain := domainAt(eventEntry, query.Level)
 
-			_, found := domains[domain]
-			if found {
+			if !matchesSearch(domain, query) {
 				continue
 			}
 
@@ -52,15 +113,18 @@ func (s *InMemoryStore) GetAllDomains() ([]string, error) {
 		}
 	}
 
-	var allDomains []string
+	allDomains := make([]string, 0, len(domains))
 	for k := range domains {
 		allDomains = append(allDomains, k)
 	}
 
-	return allDomains, nil
+	return models.Paginate(allDomains, query, func(i int) []string { return []string{allDomains[i]} })
 }
 
-func (s *InMemoryStore) GetDomainsForUser(username string) ([]string, error) {
+func (s *InMemoryStore) GetDomainsForUser(_ context.Context, username string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	domains := make(map[string]struct{})
 
 	for _, deviceID := range s.data {
@@ -87,55 +151,97 @@ func (s *InMemoryStore) GetDomainsForUser(username string) ([]string, error) {
 		allDomains = append(allDomains, k)
 	}
 
+	sort.Strings(allDomains)
+
 	return allDomains, nil
 }
 
-func (s *InMemoryStore) GetDuplicatePasswordsForUser(username string) ([][]string, error) {
-	// Map of password hash -> domain
-	domainMap := make(map[string][]string)
+// GetDuplicatePasswordsForDevice returns the groups of domains whose current password submitted by the device
+// is the same. Only groups of two or more domains are returned, limited to the one of passwordHash if set.
+// Accounts are told apart by host, their domains are grouped at the given level.
+func (s *InMemoryStore) GetDuplicatePasswordsForDevice(_ context.Context, deviceID, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	for _, deviceData := range s.data {
-		for _, eventEntry := range deviceData {
-			eventDomain := strings.ToLower(eventEntry.Domain)
-			passwordHash := eventEntry.Hash
+	type account struct{ host, username string }
 
-			if _, ok := domainMap[passwordHash]; !ok {
-				domainMap[passwordHash] = make([]string, 0)
-			}
+	// only the latest password of an account counts, older ones were changed.
+	// Tenants of an app are separate accounts, so accounts are always keyed on the host.
+	latest := make(map[account]events.LoginEvent)
+	for _, event := range s.data[deviceID] {
+		key := account{domainAt(event, models.DomainLevelHost), strings.ToLower(event.User)}
+		if current, exists := latest[key]; !exists || !event.Timestamp.Before(current.Timestamp) {
+			latest[key] = event
+		}
+	}
 
-			found := false
-			for _, domain := range domainMap[passwordHash] {
-				if domain == eventDomain {
-					found = true
-					break
-				}
-			}
+	// Map of password hash -> domains
+	domainMap := make(map[string]map[string]struct{})
+	for _, event := range latest {
+		if passwordHash != "" && event.Hash != passwordHash {
+			continue
+		}
 
-			if !found {
-				domainMap[passwordHash] = append(domainMap[passwordHash], eventDomain)
-			}
+		if _, ok := domainMap[event.Hash]; !ok {
+			domainMap[event.Hash] = make(map[string]struct{})
 		}
+		domainMap[event.Hash][domainAt(event, level)] = struct{}{}
 	}
 
 	dupes := make([][]string, 0)
-	for _, userDomains := range domainMap {
-		dupes = append(dupes, userDomains)
+	for _, domainSet := range domainMap {
+		if len(domainSet) < 2 {
+			continue
+		}
+
+		domains := make([]string, 0, len(domainSet))
+		for domain := range domainSet {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+
+		dupes = append(dupes, domains)
 	}
 
+	sort.Slice(dupes, func(i, j int) bool {
+		return dupes[i][0] < dupes[j][0]
+	})
+
 	return dupes, nil
 }
 
-func (s *InMemoryStore) GetDuplicatePasswords() (map[string]map[string]string, error) {
+func (s *InMemoryStore) GetDuplicatePasswords(_ context.Context, query models.Query) (models.Page[models.DuplicatePasswordEntry], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	entries := make([]models.DuplicatePasswordEntry, 0)
+	ids := make([]string, 0)
+	for password, entry := range s.duplicatePasswords(query.Level) {
+		if !matchesSearch(entry.User, query) {
+			continue
+		}
+
+		// a digest of the password hash tells apart passwords of a user used on the same domains
+		digest := sha256.Sum256([]byte(password))
+		entries = append(entries, entry)
+		ids = append(ids, hex.EncodeToString(digest[:8]))
+	}
+
+	return models.Paginate(entries, query, func(i int) []string {
+		return []string{entries[i].User, strings.Join(entries[i].Domains, ","), ids[i]}
+	})
+}
+
+// duplicatePasswords returns every password hash used by a user on more than one domain at the given level,
+// by username and password hash. The caller must hold the read lock.
+func (s *InMemoryStore) duplicatePasswords(level models.DomainLevel) map[string]models.DuplicatePasswordEntry {
 	// user -> password hash -> set of domains
 	userPasswordDomains := make(map[string]map[string]map[string]struct{})
 
 	for _, deviceData := range s.data {
 		for _, eventEntry := range deviceData {
 			user := strings.ToLower(eventEntry.User)
-			domain := strings.ToLower(eventEntry.Domain)
+			domain := domainAt(eventEntry, level)
 			hash := eventEntry.Hash
 
 			if _, ok := userPasswordDomains[user]; !ok {
@@ -148,8 +254,7 @@ func (s *InMemoryStore) GetDuplicatePasswords() (map[string]map[string]string, e
 		}
 	}
 
-	// Build result: user -> hash -> comma-separated list of domains
-	result := make(map[string]map[string]string)
+	result := make(map[string]models.DuplicatePasswordEntry)
 
 	for user, hashMap := range userPasswordDomains {
 		for hash, domainSet := range hashMap {
@@ -157,49 +262,664 @@ func (s *InMemoryStore) GetDuplicatePasswords() (map[string]map[string]string, e
 				continue // not a duplicate use
 			}
 
-			if _, ok := result[user]; !ok {
-				result[user] = make(map[string]string)
-			}
-
 			domains := make([]string, 0, len(domainSet))
 			for d := range domainSet {
 				domains = append(domains, d)
 			}
 
-			sort.Strings(domains) // optional, for consistency
-			result[user][hash] = strings.Join(domains, ", ")
+			sort.Strings(domains)
+			result[user+"\n"+hash] = models.DuplicatePasswordEntry{
+				User:    user,
+				Domains: domains,
+			}
 		}
 	}
 
-	return result, nil
+	return result
+}
+
+func (s *InMemoryStore) IsValidToken(_ context.Context, token string) (bool, error) {
+	return subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) == 1, nil
 }
 
-func (s *InMemoryStore) IsValidToken(token string) (bool, error) {
-	if s.token != token {
+func (s *InMemoryStore) EnrollDevice(_ context.Context, deviceID, credentialHash, signingKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, ok := s.devices[deviceID]; ok {
+		if existing.revoked {
+			return models.ErrDeviceRevoked
+		}
+
+		// only an admin reset clears the credential of an enrolled device
+		if existing.credentialHash != "" {
+			return models.ErrDeviceEnrolled
+		}
+	}
+
+	s.devices[deviceID] = &device{
+		credentialHash: credentialHash,
+		signingKey:     signingKey,
+		enrolledAt:     time.Now(),
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("enrolled device")
+
+	return nil
+}
+
+func (s *InMemoryStore) GetDeviceForCredential(_ context.Context, credentialHash string) (string, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for deviceID, d := range s.devices {
+		if !d.revoked && d.credentialHash == credentialHash {
+			return deviceID, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func (s *InMemoryStore) GetDeviceSigningKey(_ context.Context, deviceID string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	d, ok := s.devices[deviceID]
+	if !ok || d.revoked {
+		return "", nil
+	}
+
+	return d.signingKey, nil
+}
+
+func (s *InMemoryStore) RevokeDevice(_ context.Context, deviceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// devices that never enrolled are recorded as well, so they cannot enroll later on
+	s.devices[deviceID] = &device{revoked: true}
+
+	s.logger.WithField("device_id", deviceID).Debug("revoked device")
+
+	return nil
+}
+
+func (s *InMemoryStore) ResetDevice(_ context.Context, deviceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.devices[deviceID]; ok {
+		s.devices[deviceID] = &device{}
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("reset device")
+
+	return nil
+}
+
+func (s *InMemoryStore) UseNonce(_ context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if expiry, ok := s.nonces[nonce]; ok && time.Now().Before(expiry) {
 		return false, nil
 	}
 
+	s.nonces[nonce] = expiresAt
+
 	return true, nil
 }
 
-func (s *InMemoryStore) GetCompromisedPasswords() (map[string]string, error) {
+func (s *InMemoryStore) DeleteExpiredNonces(_ context.Context, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for nonce, expiry := range s.nonces {
+		if now.After(expiry) {
+			delete(s.nonces, nonce)
+		}
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) GetCompromisedPasswords(_ context.Context) (map[string]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.compromisedPasswords(), nil
+}
+
+// GetDuplicatePasswordsForUser returns the groups of domains whose current password of the user is the same.
+// Only groups of two or more domains are returned, limited to the one of passwordHash if set.
+// Accounts are told apart by host, their domains are grouped at the given level.
+func (s *InMemoryStore) GetDuplicatePasswordsForUser(_ context.Context, username, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Map of password hash -> domains
+	domainMap := make(map[string]map[string]struct{})
+	for _, event := range s.userPasswords(username) {
+		if passwordHash != "" && event.Hash != passwordHash {
+			continue
+		}
+
+		if _, ok := domainMap[event.Hash]; !ok {
+			domainMap[event.Hash] = make(map[string]struct{})
+		}
+		domainMap[event.Hash][domainAt(event, level)] = struct{}{}
+	}
+
+	dupes := make([][]string, 0)
+	for _, domainSet := range domainMap {
+		if len(domainSet) < 2 {
+			continue
+		}
+
+		domains := make([]string, 0, len(domainSet))
+		for domain := range domainSet {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+
+		dupes = append(dupes, domains)
+	}
+
+	sort.Slice(dupes, func(i, j int) bool {
+		return dupes[i][0] < dupes[j][0]
+	})
+
+	return dupes, nil
+}
+
+// userPasswords returns the latest login of the user on every host, holding their current password
+func (s *InMemoryStore) userPasswords(username string) map[string]events.LoginEvent {
+	latest := make(map[string]events.LoginEvent)
+
+	for _, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			if !strings.EqualFold(event.User, username) {
+				continue
+			}
+
+			host := domainAt(event, models.DomainLevelHost)
+			if current, exists := latest[host]; !exists || !event.Timestamp.Before(current.Timestamp) {
+				latest[host] = event
+			}
+		}
+	}
+
+	return latest
+}
+
+// GetUserAccounts returns an account per registrable domain the user logged in on, sorted on domain
+func (s *InMemoryStore) GetUserAccounts(_ context.Context, username string) ([]models.UserAccount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	accounts := make(map[string]*models.UserAccount)
+
+	for _, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			if !strings.EqualFold(event.User, username) {
+				continue
+			}
+
+			domain := domainAt(event, models.DomainLevelRegistrable)
+
+			account, exists := accounts[domain]
+			if !exists {
+				account = &models.UserAccount{Domain: domain, FirstSeen: event.Timestamp, LastSeen: event.Timestamp}
+				accounts[domain] = account
+			}
+
+			account.Logins++
+			account.HasMFA = account.HasMFA || event.HasMFA
+			if event.Timestamp.Before(account.FirstSeen) {
+				account.FirstSeen = event.Timestamp
+			}
+			if event.Timestamp.After(account.LastSeen) {
+				account.LastSeen = event.Timestamp
+			}
+		}
+	}
+
+	for _, event := range s.userPasswords(username) {
+		account := accounts[domainAt(event, models.DomainLevelRegistrable)]
+		if result, checked := s.hibpResults[event.Hash]; checked && result.BreachCount > account.BreachCount {
+			account.BreachCount = result.BreachCount
+		}
+	}
+
+	result := make([]models.UserAccount, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, *account)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Domain < result[j].Domain
+	})
+
+	return result, nil
+}
+
+// GetUserDevices returns every device and IP address the user logged in from, most recently used first
+func (s *InMemoryStore) GetUserDevices(_ context.Context, username string) ([]models.UserDevice, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	type deviceIP struct{ deviceID, ip string }
+
+	userDevices := make(map[deviceIP]*models.UserDevice)
+
+	for deviceID, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			if !strings.EqualFold(event.User, username) {
+				continue
+			}
+
+			key := deviceIP{deviceID, event.IP}
+
+			userDevice, exists := userDevices[key]
+			if !exists {
+				userDevice = &models.UserDevice{DeviceID: deviceID, IP: event.IP, FirstSeen: event.Timestamp}
+				userDevices[key] = userDevice
+			}
+
+			userDevice.Logins++
+			if event.Timestamp.Before(userDevice.FirstSeen) {
+				userDevice.FirstSeen = event.Timestamp
+			}
+			if !event.Timestamp.Before(userDevice.LastSeen) {
+				userDevice.Hostname = event.Hostname
+				userDevice.LastSeen = event.Timestamp
+			}
+		}
+	}
+
+	result := make([]models.UserDevice, 0, len(userDevices))
+	for _, userDevice := range userDevices {
+		result = append(result, *userDevice)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeen.Equal(result[j].LastSeen) {
+			return result[i].LastSeen.After(result[j].LastSeen)
+		}
+		if result[i].DeviceID != result[j].DeviceID {
+			return result[i].DeviceID < result[j].DeviceID
+		}
+		return result[i].IP < result[j].IP
+	})
+
+	return result, nil
+}
+
+// GetUserEvents returns the logins, enforcement decisions and breached passwords of the user, oldest first
+// unless the query is descending
+func (s *InMemoryStore) GetUserEvents(_ context.Context, username string, query models.Query) (models.Page[models.UserEvent], error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	userEvents := make([]models.UserEvent, 0)
+	// the position of every event in its list tells apart events of the same time, type and domain
+	ids := make([]string, 0)
+	// the hosts the user used a password on, a breach is listed once for every domain it affects
+	passwordHosts := make(map[string]map[string]string)
+
+	for deviceID, deviceEvents := range s.data {
+		for i, event := range deviceEvents {
+			if !strings.EqualFold(event.User, username) {
+				continue
+			}
+
+			userEvents = append(userEvents, models.UserEvent{
+				Timestamp: event.Timestamp,
+				Type:      models.UserEventLogin,
+				Domain:    domainAt(event, models.DomainLevelRegistrable),
+				Host:      domainAt(event, models.DomainLevelHost),
+				DeviceID:  deviceID,
+				HasMFA:    event.HasMFA,
+				Detail:    event.MFAType,
+			})
+			ids = append(ids, fmt.Sprintf("%s/%d", deviceID, i))
+
+			if passwordHosts[event.Hash] == nil {
+				passwordHosts[event.Hash] = make(map[string]string)
+			}
+
+			// the first host of the domain in alphabetical order, like the SQL stores
+			domain := domainAt(event, models.DomainLevelRegistrable)
+			host := domainAt(event, models.DomainLevelHost)
+			if current, exists := passwordHosts[event.Hash][domain]; !exists || host < current {
+				passwordHosts[event.Hash][domain] = host
+			}
+		}
+	}
+
+	for i, event := range s.enforcement {
+		// the login itself is listed already, only warnings and blocks add to it
+		if !strings.EqualFold(event.User, username) || event.Action == models.EnforcementAllow {
+			continue
+		}
+
+		userEvents = append(userEvents, models.UserEvent{
+			Timestamp: event.Timestamp,
+			Type:      models.UserEventType(event.Action),
+			Domain:    event.Domain,
+			Host:      event.Host,
+			DeviceID:  event.DeviceID,
+			Detail:    event.Reason,
+		})
+		ids = append(ids, strconv.Itoa(i))
+	}
+
+	for i, event := range s.breachEvents {
+		for domain, host := range passwordHosts[event.Hash] {
+			userEvents = append(userEvents, models.UserEvent{
+				Timestamp:   event.Timestamp,
+				Type:        models.UserEventBreach,
+				Domain:      domain,
+				Host:        host,
+				BreachCount: event.BreachCount,
+			})
+			ids = append(ids, strconv.Itoa(i))
+		}
+	}
+
+	return models.Paginate(userEvents, query, func(i int) []string {
+		event := userEvents[i]
+		return []string{timeKey(event.Timestamp), event.Domain, string(event.Type), ids[i]}
+	})
+}
+
+// GetCompromisedAccounts returns the accounts submitted by a device whose latest password is breached
+// GetDomainSummary aggregates the logins on a registrable domain or host
+func (s *InMemoryStore) GetDomainSummary(_ context.Context, domain string, level models.DomainLevel) (models.DomainSummary, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	domain = strings.ToLower(domain)
+
+	users := s.domainUsers(domain, level)
+	if len(users) == 0 {
+		return models.DomainSummary{}, false, nil
+	}
+
+	summary := models.DomainSummary{
+		FirstSeen: users[0].FirstSeen,
+		LastSeen:  users[0].LastSeen,
+		Users:     len(users),
+	}
+
+	for _, user := range users {
+		summary.Logins += user.Logins
+		if user.FirstSeen.Before(summary.FirstSeen) {
+			summary.FirstSeen = user.FirstSeen
+		}
+		if user.LastSeen.After(summary.LastSeen) {
+			summary.LastSeen = user.LastSeen
+		}
+		if user.HasMFA {
+			summary.UsersWithMFA++
+		}
+		if user.ReusesPassword {
+			summary.ReusingUsers++
+		}
+		if user.BreachCount > 0 {
+			summary.BreachedUsers++
+		}
+	}
+
+	for _, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			if domainAt(event, level) == domain {
+				summary.Devices++
+				break
+			}
+		}
+	}
+
+	return summary, true, nil
+}
+
+// GetDomainUsers returns the users that logged in on a registrable domain or host
+func (s *InMemoryStore) GetDomainUsers(_ context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainUser], error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]models.DomainUser, 0)
+	for _, user := range s.domainUsers(strings.ToLower(domain), level) {
+		if matchesSearch(user.Username, query) {
+			users = append(users, user)
+		}
+	}
+
+	return models.Paginate(users, query, func(i int) []string { return []string{users[i].Username} })
+}
+
+// domainUsers aggregates the logins on a domain per user, sorted on username. The caller must hold the read lock.
+func (s *InMemoryStore) domainUsers(domain string, level models.DomainLevel) []models.DomainUser {
+	type account struct{ host, username string }
+	type password struct{ username, hash string }
+
+	users := make(map[string]*models.DomainUser)
+	userDevices := make(map[string]map[string]struct{})
+	latest := make(map[account]events.LoginEvent)
+	passwordDomains := make(map[password]map[string]struct{})
+
+	for deviceID, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			username := strings.ToLower(event.User)
+			eventDomain := domainAt(event, level)
+
+			// every domain a password is used on, to tell whether the current passwords are reused
+			key := password{username, event.Hash}
+			if passwordDomains[key] == nil {
+				passwordDomains[key] = make(map[string]struct{})
+			}
+			passwordDomains[key][eventDomain] = struct{}{}
+
+			if eventDomain != domain {
+				continue
+			}
+
+			user, exists := users[username]
+			if !exists {
+				user = &models.DomainUser{Username: username, FirstSeen: event.Timestamp, LastSeen: event.Timestamp}
+				users[username] = user
+				userDevices[username] = make(map[string]struct{})
+			}
+
+			user.Logins++
+			user.HasMFA = user.HasMFA || event.HasMFA
+			if event.Timestamp.Before(user.FirstSeen) {
+				user.FirstSeen = event.Timestamp
+			}
+			if event.Timestamp.After(user.LastSeen) {
+				user.LastSeen = event.Timestamp
+			}
+			userDevices[username][deviceID] = struct{}{}
+
+			host := account{domainAt(event, models.DomainLevelHost), username}
+			if current, exists := latest[host]; !exists || event.Timestamp.After(current.Timestamp) {
+				latest[host] = event
+			}
+		}
+	}
+
+	for key, event := range latest {
+		user := users[key.username]
+
+		if len(passwordDomains[password{key.username, event.Hash}]) > 1 {
+			user.ReusesPassword = true
+		}
+
+		if result, checked := s.hibpResults[event.Hash]; checked && result.BreachCount > user.BreachCount {
+			user.BreachCount = result.BreachCount
+		}
+	}
+
+	result := make([]models.DomainUser, 0, len(users))
+	for username, user := range users {
+		user.Devices = len(userDevices[username])
+		result = append(result, *user)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Username < result[j].Username
+	})
+
+	return result
+}
+
+// GetDomainDevices returns the devices used to log in on a registrable domain or host, most recently used first
+func (s *InMemoryStore) GetDomainDevices(_ context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainDevice], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	domain = strings.ToLower(domain)
+
+	devices := make([]models.DomainDevice, 0)
+	for deviceID, deviceEvents := range s.data {
+		var domainDevice models.DomainDevice
+
+		for _, event := range deviceEvents {
+			if domainAt(event, level) != domain {
+				continue
+			}
+
+			domainDevice.Logins++
+			if !event.Timestamp.Before(domainDevice.LastSeen) {
+				domainDevice.Username = strings.ToLower(event.User)
+				domainDevice.Hostname = event.Hostname
+				domainDevice.IP = event.IP
+				domainDevice.LastSeen = event.Timestamp
+			}
+		}
+
+		if domainDevice.Logins == 0 || (!matchesSearch(domainDevice.Username, query) && !matchesSearch(domainDevice.Hostname, query)) {
+			continue
+		}
+
+		domainDevice.DeviceID = deviceID
+		devices = append(devices, domainDevice)
+	}
+
+	// most recently used first, whatever the order of the query
+	query.Descending = true
+
+	return models.Paginate(devices, query, func(i int) []string {
+		return []string{timeKey(devices[i].LastSeen), devices[i].DeviceID}
+	})
+}
+
+// GetDomainTimeline returns the days since the given time with logins on a registrable domain or host, oldest first
+func (s *InMemoryStore) GetDomainTimeline(_ context.Context, domain string, level models.DomainLevel, since time.Time) ([]models.TimelineDay, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	domain = strings.ToLower(domain)
+
+	days := make(map[time.Time]*models.TimelineDay)
+	dayUsers := make(map[time.Time]map[string]struct{})
+
+	for _, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			if domainAt(event, level) != domain || event.Timestamp.Before(since) {
+				continue
+			}
+
+			day := event.Timestamp.UTC().Truncate(24 * time.Hour)
+			if days[day] == nil {
+				days[day] = &models.TimelineDay{Day: day}
+				dayUsers[day] = make(map[string]struct{})
+			}
+
+			days[day].Logins++
+			dayUsers[day][strings.ToLower(event.User)] = struct{}{}
+		}
+	}
+
+	timeline := make([]models.TimelineDay, 0, len(days))
+	for day, timelineDay := range days {
+		timelineDay.Users = len(dayUsers[day])
+		timeline = append(timeline, *timelineDay)
+	}
+
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].Day.Before(timeline[j].Day)
+	})
+
+	return timeline, nil
+}
+
+func (s *InMemoryStore) GetCompromisedAccounts(_ context.Context, deviceID string) ([]models.CompromisedAccount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	type account struct{ domain, username string }
+
+	latest := make(map[account]events.LoginEvent)
+	for _, event := range s.data[deviceID] {
+		latest[account{event.Domain, event.User}] = event
+	}
+
+	// the password may have been changed on another device since
+	for _, deviceEvents := range s.data {
+		for _, event := range deviceEvents {
+			key := account{event.Domain, event.User}
+			if current, exists := latest[key]; exists && event.Timestamp.After(current.Timestamp) {
+				latest[key] = event
+			}
+		}
+	}
+
+	accounts := make([]models.CompromisedAccount, 0)
+	for key, event := range latest {
+		result, checked := s.hibpResults[event.Hash]
+		if !checked || result.BreachCount == 0 {
+			continue
+		}
+
+		accounts = append(accounts, models.CompromisedAccount{
+			Domain:      key.domain,
+			Username:    key.username,
+			BreachCount: result.BreachCount,
+			CheckedAt:   result.CheckedAt,
+		})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].BreachCount != accounts[j].BreachCount {
+			return accounts[i].BreachCount > accounts[j].BreachCount
+		}
+		return accounts[i].Domain < accounts[j].Domain
+	})
+
+	return accounts, nil
+}
+
+// compromisedPasswords returns the hashes with a breach count. The caller must hold the read lock.
+func (s *InMemoryStore) compromisedPasswords() map[string]string {
 	compromised := make(map[string]string)
 
 	// Return password hashes that have breach counts > 0
-	for hash, breachCount := range s.hibpResults {
-		if breachCount > 0 {
+	for hash, result := range s.hibpResults {
+		if result.BreachCount > 0 {
 			// Map hash to breach count as string
-			compromised[hash] = fmt.Sprintf("%d", breachCount)
+			compromised[hash] = fmt.Sprintf("%d", result.BreachCount)
 		}
 	}
 
-	return compromised, nil
+	return compromised
 }
 
-func (s *InMemoryStore) IsDuplicatePassword(username, passwordHash string) ([]string, error) {
+func (s *InMemoryStore) IsDuplicatePassword(_ context.Context, username, passwordHash string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	domains := make(map[string]struct{})
 
 	for _, deviceID := range s.data {
@@ -230,14 +950,16 @@ func (s *InMemoryStore) IsDuplicatePassword(username, passwordHash string) ([]st
 		allDomains = append(allDomains, k)
 	}
 
+	sort.Strings(allDomains)
+
 	return allDomains, nil
 }
 
-func (s *InMemoryStore) AddLoginEvent(data events.LoginEvent) error {
+func (s *InMemoryStore) AddLoginEvent(_ context.Context, data events.LoginEvent) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.data[data.DeviceID] = append(s.data[data.User], data)
+	s.data[data.DeviceID] = append(s.data[data.DeviceID], data)
 
 	s.logger.WithFields(logrus.Fields{
 		"device_id": data.DeviceID,
@@ -249,11 +971,12 @@ func (s *InMemoryStore) AddLoginEvent(data events.LoginEvent) error {
 	return nil
 }
 
-func (s *InMemoryStore) GetEnrolledUsers() ([]models.EnrolledUser, error) {
+func (s *InMemoryStore) GetEnrolledUsers(_ context.Context, query models.Query) (models.Page[models.EnrolledUser], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	userMap := make(map[string]models.EnrolledUser)
+	users := make([]models.EnrolledUser, 0, len(s.data))
+	lastSeen := make(map[string]time.Time, len(s.data))
 
 	for deviceID, deviceEvents := range s.data {
 		if len(deviceEvents) == 0 {
@@ -264,6 +987,10 @@ func (s *InMemoryStore) GetEnrolledUsers() ([]models.EnrolledUser, error) {
 		latestEvent := deviceEvents[len(deviceEvents)-1]
 		user := strings.ToLower(latestEvent.User)
 
+		if !matchesSearch(user, query) && !matchesSearch(latestEvent.Hostname, query) {
+			continue
+		}
+
 		// Use real IP and hostname from the event data
 		ip := latestEvent.IP
 		hostname := latestEvent.Hostname
@@ -272,29 +999,43 @@ func (s *InMemoryStore) GetEnrolledUsers() ([]models.EnrolledUser, error) {
 		if ip == "" {
 			ip = "Unknown"
 		}
-		
+
 		if hostname == "" {
 			hostname = "Unknown"
 		}
 
-		userMap[user] = models.EnrolledUser{
+		enrolledUser := models.EnrolledUser{
 			Username: user,
 			ID:       deviceID,
 			Hostname: hostname,
 			IP:       ip,
 			LastSeen: latestEvent.Timestamp.Format("2006-01-02 15:04:05"),
 		}
-	}
 
-	users := make([]models.EnrolledUser, 0, len(userMap))
-	for _, user := range userMap {
-		users = append(users, user)
+		if d, ok := s.devices[deviceID]; ok {
+			enrolledUser.Enrolled = d.credentialHash != ""
+			enrolledUser.Revoked = d.revoked
+		}
+
+		users = append(users, enrolledUser)
+		lastSeen[deviceID] = latestEvent.Timestamp
 	}
 
-	return users, nil
+	return models.Paginate(users, query, func(i int) []string {
+		user := users[i]
+
+		switch query.Sort {
+		case models.SortLastSeen:
+			return []string{timeKey(lastSeen[user.ID]), user.ID}
+		case models.SortHostname:
+			return []string{user.Hostname, user.ID}
+		default:
+			return []string{user.Username, user.ID}
+		}
+	})
 }
 
-func (s *InMemoryStore) GetDashboardStats() (models.DashboardStats, error) {
+func (s *InMemoryStore) GetDashboardStats(_ context.Context) (models.DashboardStats, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
@@ -308,29 +1049,39 @@ func (s *InMemoryStore) GetDashboardStats() (models.DashboardStats, error) {
 		}
 	}
 
-	duplicatePasswords, _ := s.GetDuplicatePasswords()
-	duplicateCount := 0
-	for _, userDupes := range duplicatePasswords {
-		duplicateCount += len(userDupes)
+	blockedLogins := 0
+	for _, event := range s.enforcement {
+		if event.Action == models.EnforcementBlock {
+			blockedLogins++
+		}
 	}
 
-	compromisedPasswords, _ := s.GetCompromisedPasswords()
-
-	usersWithoutMFA, _ := s.GetUsersWithoutMFA()
-
 	return models.DashboardStats{
 		TotalUsers:           len(userSet),
 		TotalDomains:         len(domainSet),
-		DuplicatePasswords:   duplicateCount,
-		CompromisedPasswords: len(compromisedPasswords),
-		UsersWithoutMFA:      len(usersWithoutMFA),
+		DuplicatePasswords:   len(s.duplicatePasswords(models.DomainLevelRegistrable)),
+		CompromisedPasswords: len(s.compromisedPasswords()),
+		UsersWithoutMFA:      len(s.usersWithoutMFA()),
+		BlockedLogins:        blockedLogins,
 	}, nil
 }
 
-func (s *InMemoryStore) GetUsersWithoutMFA() ([]string, error) {
+func (s *InMemoryStore) GetUsersWithoutMFA(_ context.Context, query models.Query) (models.Page[string], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	users := make([]string, 0)
+	for _, user := range s.usersWithoutMFA() {
+		if matchesSearch(user, query) {
+			users = append(users, user)
+		}
+	}
+
+	return models.Paginate(users, query, func(i int) []string { return []string{users[i]} })
+}
+
+// usersWithoutMFA returns the users that never logged in using MFA. The caller must hold the read lock.
+func (s *InMemoryStore) usersWithoutMFA() []string {
 	userMFAStatus := make(map[string]bool)
 
 	// Check all events to determine MFA status for each user
@@ -355,7 +1106,7 @@ func (s *InMemoryStore) GetUsersWithoutMFA() ([]string, error) {
 		}
 	}
 
-	return users, nil
+	return users
 }
 
 func min(a, b int) int {
@@ -366,46 +1117,327 @@ func min(a, b int) int {
 }
 
 // StoreHIBPResult stores a HIBP breach count for a password hash
-func (s *InMemoryStore) StoreHIBPResult(passwordHash string, breachCount int) error {
+func (s *InMemoryStore) StoreHIBPResult(_ context.Context, passwordHash string, breachCount int) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.hibpResults[passwordHash] = breachCount
+	s.hibpResults[passwordHash] = models.HIBPResult{
+		BreachCount: breachCount,
+		CheckedAt:   time.Now(),
+	}
 
-	s.logger.WithFields(logrus.Fields{
-		"hash_prefix":  passwordHash[:5],
-		"breach_count": breachCount,
-	}).Debug("stored HIBP result")
+	s.logger.WithField("breach_count", breachCount).Debug("stored HIBP result")
 
 	return nil
 }
 
 // GetHIBPResult retrieves a HIBP breach count for a password hash
-func (s *InMemoryStore) GetHIBPResult(passwordHash string) (int, bool, error) {
+func (s *InMemoryStore) GetHIBPResult(_ context.Context, passwordHash string) (models.HIBPResult, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result, exists := s.hibpResults[passwordHash]
+	return result, exists, nil
+}
+
+// AddHIBPVerdict stores a breach verdict until the device picks it up
+func (s *InMemoryStore) AddHIBPVerdict(_ context.Context, verdict models.HIBPVerdict) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.hibpVerdicts[verdict.DeviceID] = append(s.hibpVerdicts[verdict.DeviceID], verdict)
+
+	return nil
+}
+
+// TakeHIBPVerdicts returns and removes the breach verdicts of a device
+func (s *InMemoryStore) TakeHIBPVerdicts(_ context.Context, deviceID string) ([]models.HIBPVerdict, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	verdicts := s.hibpVerdicts[deviceID]
+	delete(s.hibpVerdicts, deviceID)
+
+	return verdicts, nil
+}
+
+// GetAppReviews returns the review of every app that has one, by app
+func (s *InMemoryStore) GetAppReviews(_ context.Context) (map[string]models.AppReview, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	reviews := make(map[string]models.AppReview, len(s.appReviews))
+	for app, review := range s.appReviews {
+		reviews[app] = review
+	}
+
+	return reviews, nil
+}
+
+// SetAppReview creates or replaces the review of an app
+func (s *InMemoryStore) SetAppReview(_ context.Context, review models.AppReview) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.appReviews[review.App] = review
+
+	return nil
+}
+
+// AddEnforcementEvent records whether a login was allowed, warned about or blocked
+func (s *InMemoryStore) AddEnforcementEvent(_ context.Context, event events.EnforcementEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.enforcement = append(s.enforcement, event)
+
+	return nil
+}
+
+// GetEnforcementEvents returns the recorded enforcement decisions, most recent first
+func (s *InMemoryStore) GetEnforcementEvents(_ context.Context, query models.Query, action models.EnforcementAction) (models.Page[events.EnforcementEvent], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	breachCount, exists := s.hibpResults[passwordHash]
-	return breachCount, exists, nil
+	enforcementEvents := make([]events.EnforcementEvent, 0)
+	positions := make([]int, 0)
+	for i, event := range s.enforcement {
+		if action != "" && event.Action != action {
+			continue
+		}
+
+		if matchesSearch(event.User, query) || matchesSearch(event.Host, query) || matchesSearch(event.App, query) {
+			enforcementEvents = append(enforcementEvents, event)
+			positions = append(positions, i)
+		}
+	}
+
+	// most recent first, whatever the order of the query
+	query.Descending = true
+
+	return models.Paginate(enforcementEvents, query, func(i int) []string {
+		return []string{fmt.Sprintf("%012d", positions[i])}
+	})
 }
 
-// GetAllPasswordHashes returns all unique password hashes from login events
-func (s *InMemoryStore) GetAllPasswordHashes() ([]string, error) {
+// GetPolicy returns the latest version of the extension policy
+func (s *InMemoryStore) GetPolicy(_ context.Context) (models.Policy, bool, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	hashSet := make(map[string]struct{})
+	if len(s.policies) == 0 {
+		return models.Policy{}, false, nil
+	}
+
+	return s.policies[len(s.policies)-1], true, nil
+}
+
+// StorePolicy stores the policy as the next version, earlier versions are kept
+func (s *InMemoryStore) StorePolicy(_ context.Context, policy models.Policy) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	policy.Version = len(s.policies) + 1
+	s.policies = append(s.policies, policy)
+
+	return policy.Version, nil
+}
+
+// GetHIBPRange retrieves a cached HIBP range and marks it as recently used
+func (s *InMemoryStore) GetHIBPRange(_ context.Context, prefix string) (models.HIBPRange, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cached, exists := s.hibpRanges[prefix]
+	if !exists {
+		return models.HIBPRange{}, false, nil
+	}
+
+	cached.lastUsedAt = time.Now()
+
+	return cached.HIBPRange, true, nil
+}
+
+// StoreHIBPRange caches a HIBP range response
+func (s *InMemoryStore) StoreHIBPRange(_ context.Context, prefix, body string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.hibpRanges[prefix] = &hibpRange{
+		HIBPRange:  models.HIBPRange{Body: body, FetchedAt: now},
+		lastUsedAt: now,
+	}
+
+	return nil
+}
+
+// PruneHIBPRanges removes expired ranges and the least recently used ones beyond maxEntries
+func (s *InMemoryStore) PruneHIBPRanges(_ context.Context, fetchedBefore time.Time, maxEntries int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+
+	prefixes := make([]string, 0, len(s.hibpRanges))
+	for prefix, cached := range s.hibpRanges {
+		if cached.FetchedAt.Before(fetchedBefore) {
+			delete(s.hibpRanges, prefix)
+			removed++
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	if len(prefixes) <= maxEntries {
+		return removed, nil
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		return s.hibpRanges[prefixes[i]].lastUsedAt.After(s.hibpRanges[prefixes[j]].lastUsedAt)
+	})
+
+	for _, prefix := range prefixes[maxEntries:] {
+		delete(s.hibpRanges, prefix)
+		removed++
+	}
+
+	return removed, nil
+}
+
+// GetStalePasswordHashes returns the unique password hashes from login events that carry a breach hash
+// and were never checked or last checked before checkedBefore.
+// Breach hashes are encrypted with a random nonce, so any one of them is returned per password hash.
+func (s *InMemoryStore) GetStalePasswordHashes(_ context.Context, checkedBefore time.Time) ([]models.StalePasswordHash, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	breachHashes := make(map[string]string)
 
 	for _, events := range s.data {
 		for _, event := range events {
-			hashSet[event.Hash] = struct{}{}
+			if event.BreachHash != "" {
+				breachHashes[event.Hash] = event.BreachHash
+			}
 		}
 	}
 
-	hashes := make([]string, 0, len(hashSet))
-	for hash := range hashSet {
-		hashes = append(hashes, hash)
+	hashes := make([]models.StalePasswordHash, 0, len(breachHashes))
+	for hash, breachHash := range breachHashes {
+		result, checked := s.hibpResults[hash]
+		if checked && !result.CheckedAt.Before(checkedBefore) {
+			continue
+		}
+
+		hashes = append(hashes, models.StalePasswordHash{
+			PasswordHash: models.PasswordHash{Hash: hash, BreachHash: breachHash},
+			Checked:      checked,
+			BreachCount:  result.BreachCount,
+		})
 	}
 
 	return hashes, nil
 }
+
+// AddBreachEvent records that a previously clean password was found in a breach
+func (s *InMemoryStore) AddBreachEvent(_ context.Context, event events.BreachEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.breachEvents = append(s.breachEvents, event)
+
+	return nil
+}
+
+// RekeyPasswordHashes rewrites every stored fingerprint and breach hash after a fingerprint key rotation
+func (s *InMemoryStore) RekeyPasswordHashes(_ context.Context, rekeyFingerprint, rekeyBreachHash func(string) (string, error)) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := 0
+
+	for deviceID := range s.data {
+		for i := range s.data[deviceID] {
+			event := &s.data[deviceID][i]
+
+			hash, err := rekeyFingerprint(event.Hash)
+			if err != nil {
+				return changed, err
+			}
+			if hash != event.Hash {
+				event.Hash = hash
+				changed++
+			}
+
+			if event.BreachHash == "" {
+				continue
+			}
+
+			breachHash, err := rekeyBreachHash(event.BreachHash)
+			if err != nil {
+				return changed, err
+			}
+			if breachHash != event.BreachHash {
+				event.BreachHash = breachHash
+				changed++
+			}
+		}
+	}
+
+	hibpResults := make(map[string]models.HIBPResult, len(s.hibpResults))
+	for hash, result := range s.hibpResults {
+		newHash, err := rekeyFingerprint(hash)
+		if err != nil {
+			return changed, err
+		}
+		if newHash != hash {
+			changed++
+		}
+
+		// keep the newest result if two values end up with the same fingerprint
+		if existing, exists := hibpResults[newHash]; !exists || result.CheckedAt.After(existing.CheckedAt) {
+			hibpResults[newHash] = result
+		}
+	}
+	s.hibpResults = hibpResults
+
+	for i := range s.breachEvents {
+		hash, err := rekeyFingerprint(s.breachEvents[i].Hash)
+		if err != nil {
+			return changed, err
+		}
+		if hash != s.breachEvents[i].Hash {
+			s.breachEvents[i].Hash = hash
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+// NormalizeDomains recomputes the host, registrable domain and tenant of every stored host
+func (s *InMemoryStore) NormalizeDomains(_ context.Context, normalize func(host string) domainname.Name) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	changed := 0
+
+	for _, deviceEvents := range s.data {
+		for i := range deviceEvents {
+			event := &deviceEvents[i]
+			if event.Host == "" {
+				event.Host = event.Domain
+			}
+
+			name := normalize(event.Host)
+			if event.Host == name.Host && event.Domain == name.Domain && event.Tenant == name.Tenant {
+				continue
+			}
+
+			event.Host, event.Domain, event.Tenant = name.Host, name.Domain, name.Tenant
+			changed++
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,24 @@
+package memory_test
+
+import (
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/memory"
+	"github.com/hazcod/shade/pkg/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storagetest.Run(t, func(t *testing.T) storage.Driver {
+		store := &memory.InMemoryStore{}
+		if err := store.Init(logger, map[string]string{"token": storagetest.Token}); err != nil {
+			t.Fatalf("failed to init store: %v", err)
+		}
+
+		return store
+	})
+}
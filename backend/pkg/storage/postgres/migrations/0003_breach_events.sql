@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS breach_events (
+    id                    BIGSERIAL PRIMARY KEY,
+    timestamp             TIMESTAMPTZ NOT NULL,
+    hash                  TEXT NOT NULL,
+    breach_count          INTEGER NOT NULL,
+    previous_breach_count INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_breach_events_hash ON breach_events (hash);
@@ -0,0 +1,7 @@
+ALTER TABLE login_events ADD COLUMN host TEXT NOT NULL DEFAULT '';
+ALTER TABLE login_events ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+-- events recorded before normalization stored the visited host as domain
+UPDATE login_events SET host = domain WHERE host = '';
+
+CREATE INDEX IF NOT EXISTS idx_login_events_host ON login_events (host);
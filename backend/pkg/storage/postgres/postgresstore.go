@@ -0,0 +1,1426 @@
+package postgres
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryTimeout = 10 * time.Second
+)
+
+type PostgresStore struct {
+	logger *logrus.Logger
+
+	pool  *pgxpool.Pool
+	token string
+}
+
+// Init connects to the database and runs pending migrations.
+// Supported settings are dsn (required), token (required), max_connections and min_connections.
+func (s *PostgresStore) Init(logger *logrus.Logger, settings map[string]string) error {
+	s.logger = logger
+
+	token, ok := settings["token"]
+	if !ok || token == "" {
+		return errors.New("token required for postgres store")
+	}
+
+	s.token = token
+
+	dsn, ok := settings["dsn"]
+	if !ok || dsn == "" {
+		return errors.New("dsn required for postgres store")
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse postgres dsn: %w", err)
+	}
+
+	if maxConns := settings["max_connections"]; maxConns != "" {
+		value, err := strconv.ParseInt(maxConns, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid max_connections: %w", err)
+		}
+		poolConfig.MaxConns = int32(value)
+	}
+
+	if minConns := settings["min_connections"]; minConns != "" {
+		value, err := strconv.ParseInt(minConns, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid min_connections: %w", err)
+		}
+		poolConfig.MinConns = int32(value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create postgres pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	s.pool = pool
+
+	if err := s.migrate(ctx); err != nil {
+		return fmt.Errorf("failed to migrate postgres database: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"host":            poolConfig.ConnConfig.Host,
+		"database":        poolConfig.ConnConfig.Database,
+		"max_connections": poolConfig.MaxConns,
+	}).Debug("connected to postgres database")
+
+	return nil
+}
+
+// Close waits for queries in flight and closes all pooled connections
+func (s *PostgresStore) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// queryContext bounds a single storage call in case the caller did not set a deadline
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, defaultQueryTimeout)
+}
+
+// likePattern returns a LIKE pattern matching values that contain search
+func likePattern(search string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(search) + "%"
+}
+
+// afterCursor returns the condition selecting the rows sorting after the cursor of the query on the key columns,
+// numbering its parameters from first on. Every row matches when the query has no cursor.
+func afterCursor(query models.Query, descending bool, first int, columns ...string) (string, []interface{}, error) {
+	after, err := query.After()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if after == nil {
+		return "TRUE", nil, nil
+	}
+
+	if len(after) != len(columns) {
+		return "", nil, fmt.Errorf("invalid cursor: %s", query.Cursor)
+	}
+
+	operator := ">"
+	if descending {
+		operator = "<"
+	}
+
+	// cursor values are sent as text, the server converts them to the type of their column
+	params := make([]string, len(after))
+	args := make([]interface{}, len(after))
+	for i, value := range after {
+		params[i] = "$" + strconv.Itoa(first+i)
+		args[i] = value
+	}
+
+	return "(" + strings.Join(columns, ", ") + ") " + operator + " (" + strings.Join(params, ", ") + ")", args, nil
+}
+
+// queryStrings runs a query returning a single string column
+func (s *PostgresStore) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *PostgresStore) GetAllDomains(ctx context.Context, query models.Query) (models.Page[string], error) {
+	column := query.Level.Column()
+
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, column)
+	if err != nil {
+		return models.Page[string]{}, err
+	}
+
+	domains, err := s.queryStrings(ctx, `
+		SELECT DISTINCT `+column+` FROM login_events
+		WHERE `+column+` ILIKE $1 AND `+after+`
+		ORDER BY `+column+` `+query.SortDirection()+`
+		LIMIT $2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[string]{}, fmt.Errorf("failed to query domains: %w", err)
+	}
+
+	return models.NewPage(domains, query.PageLimit(), func(i int) []string { return []string{domains[i]} }), nil
+}
+
+func (s *PostgresStore) GetDomainsForUser(ctx context.Context, username string) ([]string, error) {
+	domains, err := s.queryStrings(ctx, `SELECT DISTINCT domain FROM login_events WHERE username = $1 ORDER BY domain`,
+		strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domains for user: %w", err)
+	}
+
+	return domains, nil
+}
+
+func (s *PostgresStore) GetDuplicatePasswordsForDevice(ctx context.Context, deviceID, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	column := level.Column()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH latest AS (
+			SELECT host, username, MAX(timestamp) AS timestamp FROM login_events
+			WHERE device_id = $1 GROUP BY host, username
+		), current_passwords AS (
+			SELECT DISTINCT e.hash, e.`+column+` AS domain FROM login_events e
+			JOIN latest l ON e.host = l.host AND e.username = l.username AND e.timestamp = l.timestamp
+			WHERE e.device_id = $1 AND ($2 = '' OR e.hash = $2)
+		)
+		SELECT array_agg(domain ORDER BY domain)
+		FROM current_passwords
+		GROUP BY hash
+		HAVING COUNT(*) > 1
+		ORDER BY MIN(domain)`, deviceID, passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate passwords for device: %w", err)
+	}
+
+	dupes, err := pgx.CollectRows(rows, pgx.RowTo[[]string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+	}
+
+	return dupes, nil
+}
+
+func (s *PostgresStore) GetDuplicatePasswords(ctx context.Context, query models.Query) (models.Page[models.DuplicatePasswordEntry], error) {
+	column := query.Level.Column()
+
+	// the first login with a password tells apart passwords of a user used on the same domains
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "username", "array_to_string(domains, ',')", "id")
+	if err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT username, domains, id
+		FROM (
+			SELECT username, array_agg(DISTINCT `+column+` ORDER BY `+column+`) AS domains, MIN(id) AS id
+			FROM login_events
+			WHERE username ILIKE $1
+			GROUP BY username, hash
+			HAVING COUNT(DISTINCT `+column+`) > 1
+		) duplicates
+		WHERE `+after+`
+		ORDER BY username `+query.SortDirection()+`, array_to_string(domains, ',') `+query.SortDirection()+`,
+			id `+query.SortDirection()+`
+		LIMIT $2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, fmt.Errorf("failed to query duplicate passwords: %w", err)
+	}
+
+	ids := make([]int64, 0)
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.DuplicatePasswordEntry, error) {
+		var entry models.DuplicatePasswordEntry
+		var id int64
+		err := row.Scan(&entry.User, &entry.Domains, &id)
+		ids = append(ids, id)
+		return entry, err
+	})
+	if err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+	}
+
+	return models.NewPage(entries, query.PageLimit(), func(i int) []string {
+		return []string{entries[i].User, strings.Join(entries[i].Domains, ","), strconv.FormatInt(ids[i], 10)}
+	}), nil
+}
+
+func (s *PostgresStore) IsValidToken(_ context.Context, token string) (bool, error) {
+	return subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) == 1, nil
+}
+
+func (s *PostgresStore) EnrollDevice(ctx context.Context, deviceID, credentialHash, signingKey string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// enrolled and revoked devices keep their row, so the conflict update only takes over reset devices
+	result, err := s.pool.Exec(ctx, `
+		INSERT INTO devices (device_id, credential_hash, signing_key, enrolled_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id) DO UPDATE SET credential_hash = excluded.credential_hash,
+			signing_key = excluded.signing_key, enrolled_at = excluded.enrolled_at
+		WHERE devices.revoked_at IS NULL AND devices.credential_hash IS NULL`,
+		deviceID, credentialHash, signingKey, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to enroll device: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		var revoked bool
+		if err := s.pool.QueryRow(ctx, `SELECT revoked_at IS NOT NULL FROM devices WHERE device_id = $1`,
+			deviceID).Scan(&revoked); err != nil {
+			return fmt.Errorf("failed to enroll device: %w", err)
+		}
+
+		if revoked {
+			return models.ErrDeviceRevoked
+		}
+
+		return models.ErrDeviceEnrolled
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("enrolled device")
+
+	return nil
+}
+
+func (s *PostgresStore) GetDeviceForCredential(ctx context.Context, credentialHash string) (string, bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var deviceID string
+
+	err := s.pool.QueryRow(ctx, `SELECT device_id FROM devices WHERE credential_hash = $1 AND revoked_at IS NULL`,
+		credentialHash).Scan(&deviceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query device credential: %w", err)
+	}
+
+	return deviceID, true, nil
+}
+
+func (s *PostgresStore) GetDeviceSigningKey(ctx context.Context, deviceID string) (string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var signingKey *string
+
+	err := s.pool.QueryRow(ctx, `SELECT signing_key FROM devices WHERE device_id = $1 AND revoked_at IS NULL`,
+		deviceID).Scan(&signingKey)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to get device signing key: %w", err)
+	}
+
+	if signingKey == nil {
+		return "", nil
+	}
+
+	return *signingKey, nil
+}
+
+func (s *PostgresStore) RevokeDevice(ctx context.Context, deviceID string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// devices that never enrolled are recorded as well, so they cannot enroll later on
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO devices (device_id, revoked_at) VALUES ($1, $2)
+		ON CONFLICT (device_id) DO UPDATE SET credential_hash = NULL, signing_key = NULL, revoked_at = excluded.revoked_at`,
+		deviceID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("revoked device")
+
+	return nil
+}
+
+func (s *PostgresStore) ResetDevice(ctx context.Context, deviceID string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `UPDATE devices SET credential_hash = NULL, signing_key = NULL, revoked_at = NULL WHERE device_id = $1`,
+		deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to reset device: %w", err)
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("reset device")
+
+	return nil
+}
+
+func (s *PostgresStore) UseNonce(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// an expired nonce can be taken over, its timestamp would be rejected by now anyway
+	result, err := s.pool.Exec(ctx, `
+		INSERT INTO request_nonces (nonce, expires_at) VALUES ($1, $2)
+		ON CONFLICT (nonce) DO UPDATE SET expires_at = excluded.expires_at WHERE request_nonces.expires_at < $3`,
+		nonce, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to store nonce: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) DeleteExpiredNonces(ctx context.Context, now time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	if _, err := s.pool.Exec(ctx, `DELETE FROM request_nonces WHERE expires_at < $1`, now.UTC()); err != nil {
+		return fmt.Errorf("failed to delete expired nonces: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetCompromisedPasswords(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `SELECT hash, breach_count FROM hibp_results WHERE breach_count > 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compromised passwords: %w", err)
+	}
+	defer rows.Close()
+
+	compromised := make(map[string]string)
+	for rows.Next() {
+		var hash string
+		var breachCount int
+		if err := rows.Scan(&hash, &breachCount); err != nil {
+			return nil, fmt.Errorf("failed to scan compromised passwords: %w", err)
+		}
+		compromised[hash] = fmt.Sprintf("%d", breachCount)
+	}
+
+	return compromised, rows.Err()
+}
+
+// GetCompromisedAccounts returns the accounts submitted by a device whose latest password is breached.
+// The latest login of an account is taken from any device, the password may have been changed elsewhere.
+func (s *PostgresStore) GetCompromisedAccounts(ctx context.Context, deviceID string) ([]models.CompromisedAccount, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH accounts AS (
+			SELECT DISTINCT domain, username FROM login_events WHERE device_id = $1
+		), latest AS (
+			SELECT e.domain, e.username, MAX(e.timestamp) AS timestamp
+			FROM login_events e JOIN accounts a ON e.domain = a.domain AND e.username = a.username
+			GROUP BY e.domain, e.username
+		)
+		SELECT DISTINCT e.domain, e.username, r.breach_count, r.checked_at
+		FROM login_events e
+		JOIN latest l ON e.domain = l.domain AND e.username = l.username AND e.timestamp = l.timestamp
+		JOIN hibp_results r ON r.hash = e.hash
+		WHERE r.breach_count > 0
+		ORDER BY r.breach_count DESC, e.domain`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compromised accounts: %w", err)
+	}
+
+	accounts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.CompromisedAccount])
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compromised accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// domainUsersQuery returns the common table expressions aggregating the logins on the domain $1 per user.
+// The current passwords of a user are the last ones submitted for every host of the domain.
+func domainUsersQuery(column string) string {
+	return `
+		WITH domain_events AS (
+			SELECT * FROM login_events WHERE ` + column + ` = $1
+		), latest AS (
+			SELECT DISTINCT ON (host, username) username, hash
+			FROM domain_events
+			ORDER BY host, username, timestamp DESC, id DESC
+		), passwords AS (
+			SELECT l.username,
+				bool_or(EXISTS (
+					SELECT 1 FROM login_events o
+					WHERE o.username = l.username AND o.hash = l.hash AND o.` + column + ` != $1
+				)) AS reuses,
+				MAX(COALESCE(r.breach_count, 0)) AS breach_count
+			FROM latest l
+			LEFT JOIN hibp_results r ON r.hash = l.hash
+			GROUP BY l.username
+		), domain_users AS (
+			SELECT username, COUNT(*) AS logins, COUNT(DISTINCT device_id) AS devices,
+				MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen, bool_or(has_mfa) AS has_mfa
+			FROM domain_events
+			GROUP BY username
+		)`
+}
+
+func (s *PostgresStore) GetDomainSummary(ctx context.Context, domain string, level models.DomainLevel) (models.DomainSummary, bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var summary models.DomainSummary
+	var firstSeen, lastSeen *time.Time
+
+	err := s.pool.QueryRow(ctx, domainUsersQuery(level.Column())+`
+		SELECT COUNT(*), COALESCE(SUM(u.logins), 0)::BIGINT, MIN(u.first_seen), MAX(u.last_seen),
+			COUNT(*) FILTER (WHERE u.has_mfa), COUNT(*) FILTER (WHERE p.reuses), COUNT(*) FILTER (WHERE p.breach_count > 0),
+			(SELECT COUNT(DISTINCT device_id) FROM domain_events)
+		FROM domain_users u
+		JOIN passwords p ON p.username = u.username`, strings.ToLower(domain)).
+		Scan(&summary.Users, &summary.Logins, &firstSeen, &lastSeen,
+			&summary.UsersWithMFA, &summary.ReusingUsers, &summary.BreachedUsers, &summary.Devices)
+	if err != nil {
+		return models.DomainSummary{}, false, fmt.Errorf("failed to query domain summary: %w", err)
+	}
+
+	if summary.Users == 0 || firstSeen == nil || lastSeen == nil {
+		return models.DomainSummary{}, false, nil
+	}
+
+	summary.FirstSeen = *firstSeen
+	summary.LastSeen = *lastSeen
+
+	return summary, true, nil
+}
+
+func (s *PostgresStore) GetDomainUsers(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainUser], error) {
+	after, afterArgs, err := afterCursor(query, query.Descending, 4, "u.username")
+	if err != nil {
+		return models.Page[models.DomainUser]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, domainUsersQuery(level.Column())+`
+		SELECT u.username, u.logins, u.devices, u.first_seen, u.last_seen, u.has_mfa, p.reuses, p.breach_count
+		FROM domain_users u
+		JOIN passwords p ON p.username = u.username
+		WHERE u.username ILIKE $2 AND `+after+`
+		ORDER BY u.username `+query.SortDirection()+`
+		LIMIT $3`,
+		append([]interface{}{strings.ToLower(domain), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DomainUser]{}, fmt.Errorf("failed to query domain users: %w", err)
+	}
+
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.DomainUser, error) {
+		var user models.DomainUser
+		err := row.Scan(&user.Username, &user.Logins, &user.Devices, &user.FirstSeen, &user.LastSeen,
+			&user.HasMFA, &user.ReusesPassword, &user.BreachCount)
+		return user, err
+	})
+	if err != nil {
+		return models.Page[models.DomainUser]{}, fmt.Errorf("failed to scan domain users: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string { return []string{users[i].Username} }), nil
+}
+
+func (s *PostgresStore) GetDomainDevices(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainDevice], error) {
+	after, afterArgs, err := afterCursor(query, true, 4, "latest.timestamp", "latest.device_id")
+	if err != nil {
+		return models.Page[models.DomainDevice]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// the most recent login of every device on the domain
+	rows, err := s.pool.Query(ctx, `
+		SELECT latest.device_id, latest.username, latest.hostname, latest.ip, latest.timestamp, latest.logins,
+			latest.timestamp::text
+		FROM (
+			SELECT DISTINCT ON (device_id) device_id, username, hostname, ip, timestamp,
+				COUNT(*) OVER (PARTITION BY device_id) AS logins
+			FROM login_events
+			WHERE `+level.Column()+` = $1
+			ORDER BY device_id, id DESC
+		) latest
+		WHERE (latest.username ILIKE $2 OR latest.hostname ILIKE $2) AND `+after+`
+		ORDER BY latest.timestamp DESC, latest.device_id DESC
+		LIMIT $3`,
+		append([]interface{}{strings.ToLower(domain), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DomainDevice]{}, fmt.Errorf("failed to query domain devices: %w", err)
+	}
+
+	lastSeen := make([]string, 0)
+	devices, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.DomainDevice, error) {
+		var device models.DomainDevice
+		var timestamp string
+		err := row.Scan(&device.DeviceID, &device.Username, &device.Hostname, &device.IP, &device.LastSeen, &device.Logins,
+			&timestamp)
+		lastSeen = append(lastSeen, timestamp)
+		return device, err
+	})
+	if err != nil {
+		return models.Page[models.DomainDevice]{}, fmt.Errorf("failed to scan domain devices: %w", err)
+	}
+
+	return models.NewPage(devices, query.PageLimit(), func(i int) []string {
+		return []string{lastSeen[i], devices[i].DeviceID}
+	}), nil
+}
+
+func (s *PostgresStore) GetDomainTimeline(ctx context.Context, domain string, level models.DomainLevel, since time.Time) ([]models.TimelineDay, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT (timestamp AT TIME ZONE 'UTC')::date AS day, COUNT(*), COUNT(DISTINCT username)
+		FROM login_events
+		WHERE `+level.Column()+` = $1 AND timestamp >= $2
+		GROUP BY day
+		ORDER BY day`,
+		strings.ToLower(domain), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain timeline: %w", err)
+	}
+
+	timeline, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.TimelineDay, error) {
+		var day models.TimelineDay
+		err := row.Scan(&day.Day, &day.Logins, &day.Users)
+		return day, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan domain timeline: %w", err)
+	}
+
+	return timeline, nil
+}
+
+func (s *PostgresStore) GetDuplicatePasswordsForUser(ctx context.Context, username, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	column := level.Column()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH latest AS (
+			SELECT host, MAX(timestamp) AS timestamp FROM login_events
+			WHERE username = $1 GROUP BY host
+		), current_passwords AS (
+			SELECT DISTINCT e.hash, e.`+column+` AS domain FROM login_events e
+			JOIN latest l ON e.host = l.host AND e.timestamp = l.timestamp
+			WHERE e.username = $1 AND ($2 = '' OR e.hash = $2)
+		)
+		SELECT array_agg(domain ORDER BY domain)
+		FROM current_passwords
+		GROUP BY hash
+		HAVING COUNT(*) > 1
+		ORDER BY MIN(domain)`, strings.ToLower(username), passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate passwords for user: %w", err)
+	}
+
+	dupes, err := pgx.CollectRows(rows, pgx.RowTo[[]string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+	}
+
+	return dupes, nil
+}
+
+func (s *PostgresStore) GetUserAccounts(ctx context.Context, username string) ([]models.UserAccount, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH latest AS (
+			SELECT DISTINCT ON (host) domain, hash FROM login_events
+			WHERE username = $1
+			ORDER BY host, timestamp DESC, id DESC
+		), breaches AS (
+			SELECT l.domain, MAX(COALESCE(r.breach_count, 0)) AS breach_count
+			FROM latest l
+			LEFT JOIN hibp_results r ON r.hash = l.hash
+			GROUP BY l.domain
+		)
+		SELECT e.domain, COUNT(*), MIN(e.timestamp), MAX(e.timestamp), bool_or(e.has_mfa), COALESCE(MAX(b.breach_count), 0)
+		FROM login_events e
+		LEFT JOIN breaches b ON b.domain = e.domain
+		WHERE e.username = $1
+		GROUP BY e.domain
+		ORDER BY e.domain`, strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user accounts: %w", err)
+	}
+
+	accounts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.UserAccount, error) {
+		var account models.UserAccount
+		err := row.Scan(&account.Domain, &account.Logins, &account.FirstSeen, &account.LastSeen,
+			&account.HasMFA, &account.BreachCount)
+		return account, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+func (s *PostgresStore) GetUserDevices(ctx context.Context, username string) ([]models.UserDevice, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// the most recent login of every device and IP address, holding the current hostname
+	rows, err := s.pool.Query(ctx, `
+		SELECT latest.device_id, latest.hostname, latest.ip, latest.logins, latest.first_seen, latest.timestamp
+		FROM (
+			SELECT DISTINCT ON (device_id, ip) device_id, hostname, ip, timestamp,
+				COUNT(*) OVER (PARTITION BY device_id, ip) AS logins,
+				MIN(timestamp) OVER (PARTITION BY device_id, ip) AS first_seen
+			FROM login_events
+			WHERE username = $1
+			ORDER BY device_id, ip, id DESC
+		) latest
+		ORDER BY latest.timestamp DESC, latest.device_id, latest.ip`, strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user devices: %w", err)
+	}
+
+	devices, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.UserDevice, error) {
+		var device models.UserDevice
+		err := row.Scan(&device.DeviceID, &device.Hostname, &device.IP, &device.Logins, &device.FirstSeen, &device.LastSeen)
+		return device, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (s *PostgresStore) GetUserEvents(ctx context.Context, username string, query models.Query) (models.Page[models.UserEvent], error) {
+	// the type and row id tell apart events of the same time and domain
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "timestamp", "domain", "type", "id")
+	if err != nil {
+		return models.Page[models.UserEvent]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	direction := query.SortDirection()
+
+	// a breach is listed once for every domain the password was used on
+	rows, err := s.pool.Query(ctx, `
+		SELECT timestamp, type, domain, host, device_id, has_mfa, detail, breach_count, timestamp::text, id
+		FROM (
+			SELECT timestamp, 'login' AS type, domain, host, device_id, has_mfa, mfa_type AS detail, 0 AS breach_count, id
+			FROM login_events
+			WHERE username = $1
+			UNION ALL
+			SELECT timestamp, action, domain, host, device_id, FALSE, reason, 0, id
+			FROM enforcement_events
+			WHERE lower(username) = $1 AND action <> 'allow'
+			UNION ALL
+			SELECT b.timestamp, 'breach', e.domain, MIN(e.host), '', FALSE, '', b.breach_count, b.id
+			FROM breach_events b
+			JOIN login_events e ON e.hash = b.hash
+			WHERE e.username = $1
+			GROUP BY b.id, e.domain
+		) user_events
+		WHERE `+after+`
+		ORDER BY timestamp `+direction+`, domain `+direction+`, type `+direction+`, id `+direction+`
+		LIMIT $2`,
+		append([]interface{}{strings.ToLower(username), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.UserEvent]{}, fmt.Errorf("failed to query user events: %w", err)
+	}
+
+	keys := make([][]string, 0)
+	userEvents, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.UserEvent, error) {
+		var event models.UserEvent
+		var eventType, timestamp string
+		var id int64
+		err := row.Scan(&event.Timestamp, &eventType, &event.Domain, &event.Host, &event.DeviceID,
+			&event.HasMFA, &event.Detail, &event.BreachCount, &timestamp, &id)
+		event.Type = models.UserEventType(eventType)
+		keys = append(keys, []string{timestamp, event.Domain, eventType, strconv.FormatInt(id, 10)})
+		return event, err
+	})
+	if err != nil {
+		return models.Page[models.UserEvent]{}, fmt.Errorf("failed to scan user events: %w", err)
+	}
+
+	return models.NewPage(userEvents, query.PageLimit(), func(i int) []string { return keys[i] }), nil
+}
+
+func (s *PostgresStore) IsDuplicatePassword(ctx context.Context, username, passwordHash string) ([]string, error) {
+	domains, err := s.queryStrings(ctx, `SELECT DISTINCT domain FROM login_events WHERE username = $1 AND hash = $2 ORDER BY domain`,
+		strings.ToLower(username), passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate password: %w", err)
+	}
+
+	return domains, nil
+}
+
+func (s *PostgresStore) AddLoginEvent(ctx context.Context, data events.LoginEvent) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO login_events (timestamp, username, domain, host, tenant, hash, breach_hash, device_id, ip, hostname, has_mfa, mfa_type)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		data.Timestamp.UTC(), strings.ToLower(data.User), strings.ToLower(data.Domain), strings.ToLower(data.Host), data.Tenant,
+		data.Hash, data.BreachHash, data.DeviceID, data.IP, data.Hostname, data.HasMFA, data.MFAType)
+	if err != nil {
+		return fmt.Errorf("failed to insert login event: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"device_id": data.DeviceID,
+		"username":  data.User,
+		"timestamp": data.Timestamp.Format(time.DateTime),
+		"domain":    data.Domain,
+	}).Debug("captured login event")
+
+	return nil
+}
+
+// enrolledUserSortColumns maps query sort fields to the columns of the enrolled users query
+var enrolledUserSortColumns = map[string]string{
+	models.SortUsername: "username",
+	models.SortHostname: "hostname",
+	models.SortLastSeen: "timestamp",
+}
+
+func (s *PostgresStore) GetEnrolledUsers(ctx context.Context, query models.Query) (models.Page[models.EnrolledUser], error) {
+	sortColumn, ok := enrolledUserSortColumns[query.Sort]
+	if !ok {
+		sortColumn = enrolledUserSortColumns[models.SortUsername]
+	}
+
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "latest."+sortColumn, "latest.device_id")
+	if err != nil {
+		return models.Page[models.EnrolledUser]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// the most recent event of every device
+	rows, err := s.pool.Query(ctx, `
+		SELECT latest.username, latest.device_id, latest.hostname, latest.ip, latest.timestamp,
+			d.credential_hash IS NOT NULL, d.revoked_at IS NOT NULL, latest.`+sortColumn+`::text
+		FROM (
+			SELECT DISTINCT ON (device_id) username, device_id, hostname, ip, timestamp
+			FROM login_events
+			ORDER BY device_id, id DESC
+		) latest
+		LEFT JOIN devices d ON d.device_id = latest.device_id
+		WHERE (latest.username ILIKE $1 OR latest.hostname ILIKE $1) AND `+after+`
+		ORDER BY latest.`+sortColumn+` `+query.SortDirection()+`, latest.device_id `+query.SortDirection()+`
+		LIMIT $2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to query enrolled users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.EnrolledUser, 0)
+	sortValues := make([]string, 0)
+	for rows.Next() {
+		var user models.EnrolledUser
+		var lastSeen time.Time
+		var sortValue string
+		if err := rows.Scan(&user.Username, &user.ID, &user.Hostname, &user.IP, &lastSeen,
+			&user.Enrolled, &user.Revoked, &sortValue); err != nil {
+			return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to scan enrolled user: %w", err)
+		}
+
+		if user.IP == "" {
+			user.IP = "Unknown"
+		}
+
+		if user.Hostname == "" {
+			user.Hostname = "Unknown"
+		}
+
+		user.LastSeen = lastSeen.Format("2006-01-02 15:04:05")
+		users = append(users, user)
+		sortValues = append(sortValues, sortValue)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to read enrolled users: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string {
+		return []string{sortValues[i], users[i].ID}
+	}), nil
+}
+
+func (s *PostgresStore) GetDashboardStats(ctx context.Context) (models.DashboardStats, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var stats models.DashboardStats
+
+	err := s.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT username) FROM login_events),
+			(SELECT COUNT(DISTINCT domain) FROM login_events),
+			(SELECT COUNT(*) FROM (
+				SELECT 1 FROM login_events
+				GROUP BY username, hash
+				HAVING COUNT(DISTINCT domain) > 1
+			) dupes),
+			(SELECT COUNT(*) FROM hibp_results WHERE breach_count > 0),
+			(SELECT COUNT(*) FROM (
+				SELECT 1 FROM login_events
+				GROUP BY username
+				HAVING NOT bool_or(has_mfa)
+			) no_mfa),
+			(SELECT COUNT(*) FROM enforcement_events WHERE action = $1)`,
+		string(models.EnforcementBlock)).
+		Scan(&stats.TotalUsers, &stats.TotalDomains, &stats.DuplicatePasswords,
+			&stats.CompromisedPasswords, &stats.UsersWithoutMFA, &stats.BlockedLogins)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query dashboard stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *PostgresStore) GetUsersWithoutMFA(ctx context.Context, query models.Query) (models.Page[string], error) {
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "username")
+	if err != nil {
+		return models.Page[string]{}, err
+	}
+
+	users, err := s.queryStrings(ctx, `
+		SELECT username FROM login_events
+		WHERE username ILIKE $1 AND `+after+`
+		GROUP BY username
+		HAVING NOT bool_or(has_mfa)
+		ORDER BY username `+query.SortDirection()+`
+		LIMIT $2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[string]{}, fmt.Errorf("failed to query users without MFA: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string { return []string{users[i]} }), nil
+}
+
+// StoreHIBPResult stores a HIBP breach count for a password hash
+func (s *PostgresStore) StoreHIBPResult(ctx context.Context, passwordHash string, breachCount int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO hibp_results (hash, breach_count, checked_at) VALUES ($1, $2, $3)
+		ON CONFLICT (hash) DO UPDATE SET breach_count = excluded.breach_count, checked_at = excluded.checked_at`,
+		passwordHash, breachCount, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store HIBP result: %w", err)
+	}
+
+	s.logger.WithField("breach_count", breachCount).Debug("stored HIBP result")
+
+	return nil
+}
+
+// GetHIBPResult retrieves a HIBP breach count for a password hash
+func (s *PostgresStore) GetHIBPResult(ctx context.Context, passwordHash string) (models.HIBPResult, bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var result models.HIBPResult
+
+	err := s.pool.QueryRow(ctx, `SELECT breach_count, checked_at FROM hibp_results WHERE hash = $1`, passwordHash).
+		Scan(&result.BreachCount, &result.CheckedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.HIBPResult{}, false, nil
+	}
+	if err != nil {
+		return models.HIBPResult{}, false, fmt.Errorf("failed to query HIBP result: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// AddHIBPVerdict stores a breach verdict until the device picks it up
+func (s *PostgresStore) AddHIBPVerdict(ctx context.Context, verdict models.HIBPVerdict) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO hibp_verdicts (device_id, domain, username, breach_count, checked_at) VALUES ($1, $2, $3, $4, $5)`,
+		verdict.DeviceID, verdict.Domain, verdict.Username, verdict.BreachCount, verdict.CheckedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert HIBP verdict: %w", err)
+	}
+
+	return nil
+}
+
+// TakeHIBPVerdicts returns and removes the breach verdicts of a device
+func (s *PostgresStore) TakeHIBPVerdicts(ctx context.Context, deviceID string) ([]models.HIBPVerdict, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		DELETE FROM hibp_verdicts WHERE device_id = $1
+		RETURNING device_id, domain, username, breach_count, checked_at`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take HIBP verdicts: %w", err)
+	}
+
+	verdicts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.HIBPVerdict])
+	if err != nil {
+		return nil, fmt.Errorf("failed to take HIBP verdicts: %w", err)
+	}
+
+	return verdicts, nil
+}
+
+// GetAppReviews returns the review of every app that has one, by app
+func (s *PostgresStore) GetAppReviews(ctx context.Context) (map[string]models.AppReview, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT app, status, owner, justification, review_date, updated_by, updated_at FROM app_reviews`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query app reviews: %w", err)
+	}
+
+	stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.AppReview, error) {
+		var review models.AppReview
+		var reviewDate *time.Time
+		err := row.Scan(&review.App, &review.Status, &review.Owner, &review.Justification, &reviewDate,
+			&review.UpdatedBy, &review.UpdatedAt)
+		if reviewDate != nil {
+			review.ReviewDate = *reviewDate
+		}
+		return review, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan app reviews: %w", err)
+	}
+
+	reviews := make(map[string]models.AppReview, len(stored))
+	for _, review := range stored {
+		reviews[review.App] = review
+	}
+
+	return reviews, nil
+}
+
+// SetAppReview creates or replaces the review of an app
+func (s *PostgresStore) SetAppReview(ctx context.Context, review models.AppReview) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var reviewDate *time.Time
+	if !review.ReviewDate.IsZero() {
+		utc := review.ReviewDate.UTC()
+		reviewDate = &utc
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO app_reviews (app, status, owner, justification, review_date, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (app) DO UPDATE SET status = excluded.status, owner = excluded.owner,
+			justification = excluded.justification, review_date = excluded.review_date,
+			updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		review.App, string(review.Status), review.Owner, review.Justification, reviewDate, review.UpdatedBy, review.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store app review: %w", err)
+	}
+
+	return nil
+}
+
+// AddEnforcementEvent records whether a login was allowed, warned about or blocked
+func (s *PostgresStore) AddEnforcementEvent(ctx context.Context, event events.EnforcementEvent) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO enforcement_events (timestamp, username, domain, host, app, device_id, action, reason, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		event.Timestamp.UTC(), event.User, event.Domain, event.Host, event.App, event.DeviceID,
+		string(event.Action), event.Reason, event.Message)
+	if err != nil {
+		return fmt.Errorf("failed to insert enforcement event: %w", err)
+	}
+
+	return nil
+}
+
+// GetEnforcementEvents returns the recorded enforcement decisions, most recent first
+func (s *PostgresStore) GetEnforcementEvents(ctx context.Context, query models.Query, action models.EnforcementAction) (models.Page[events.EnforcementEvent], error) {
+	after, afterArgs, err := afterCursor(query, true, 4, "id")
+	if err != nil {
+		return models.Page[events.EnforcementEvent]{}, err
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT timestamp, username, domain, host, app, device_id, action, reason, message, id
+		FROM enforcement_events
+		WHERE ($1 = '' OR action = $1)
+			AND (username ILIKE $2 OR host ILIKE $2 OR app ILIKE $2)
+			AND `+after+`
+		ORDER BY id DESC
+		LIMIT $3`,
+		append([]interface{}{string(action), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[events.EnforcementEvent]{}, fmt.Errorf("failed to query enforcement events: %w", err)
+	}
+
+	ids := make([]string, 0)
+	enforcementEvents, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (events.EnforcementEvent, error) {
+		var event events.EnforcementEvent
+		var eventAction string
+		var id int64
+		err := row.Scan(&event.Timestamp, &event.User, &event.Domain, &event.Host, &event.App, &event.DeviceID,
+			&eventAction, &event.Reason, &event.Message, &id)
+		event.Action = models.EnforcementAction(eventAction)
+		ids = append(ids, strconv.FormatInt(id, 10))
+		return event, err
+	})
+	if err != nil {
+		return models.Page[events.EnforcementEvent]{}, fmt.Errorf("failed to read enforcement events: %w", err)
+	}
+
+	return models.NewPage(enforcementEvents, query.PageLimit(), func(i int) []string { return []string{ids[i]} }), nil
+}
+
+// GetPolicy returns the latest version of the extension policy
+func (s *PostgresStore) GetPolicy(ctx context.Context) (models.Policy, bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var policy models.Policy
+	var version int
+	var document, updatedBy string
+	var updatedAt time.Time
+
+	err := s.pool.QueryRow(ctx, `
+		SELECT version, document, updated_by, updated_at FROM policies ORDER BY version DESC LIMIT 1`).
+		Scan(&version, &document, &updatedBy, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Policy{}, false, nil
+	}
+	if err != nil {
+		return models.Policy{}, false, fmt.Errorf("failed to query policy: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return models.Policy{}, false, fmt.Errorf("failed to parse policy %d: %w", version, err)
+	}
+
+	// the columns are authoritative, the document only holds the settings
+	policy.Version = version
+	policy.UpdatedBy = updatedBy
+	policy.UpdatedAt = updatedAt
+
+	return policy, true, nil
+}
+
+// StorePolicy stores the policy as the next version, earlier versions are kept
+func (s *PostgresStore) StorePolicy(ctx context.Context, policy models.Policy) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	document, err := json.Marshal(policy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode policy: %w", err)
+	}
+
+	var version int
+	err = s.pool.QueryRow(ctx, `
+		INSERT INTO policies (version, document, updated_by, updated_at)
+		SELECT COALESCE(MAX(version), 0) + 1, $1, $2, $3 FROM policies
+		RETURNING version`,
+		string(document), policy.UpdatedBy, policy.UpdatedAt.UTC()).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store policy: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetHIBPRange retrieves a cached HIBP range and marks it as recently used
+func (s *PostgresStore) GetHIBPRange(ctx context.Context, prefix string) (models.HIBPRange, bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var result models.HIBPRange
+
+	err := s.pool.QueryRow(ctx, `
+		UPDATE hibp_ranges SET last_used_at = $2 WHERE prefix = $1 RETURNING body, fetched_at`,
+		prefix, time.Now().UTC()).
+		Scan(&result.Body, &result.FetchedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.HIBPRange{}, false, nil
+	}
+	if err != nil {
+		return models.HIBPRange{}, false, fmt.Errorf("failed to query HIBP range: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// StoreHIBPRange caches a HIBP range response
+func (s *PostgresStore) StoreHIBPRange(ctx context.Context, prefix, body string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO hibp_ranges (prefix, body, fetched_at, last_used_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (prefix) DO UPDATE SET body = excluded.body, fetched_at = excluded.fetched_at,
+			last_used_at = excluded.last_used_at`,
+		prefix, body, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to store HIBP range: %w", err)
+	}
+
+	return nil
+}
+
+// PruneHIBPRanges removes expired ranges and the least recently used ones beyond maxEntries
+func (s *PostgresStore) PruneHIBPRanges(ctx context.Context, fetchedBefore time.Time, maxEntries int) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	expired, err := s.pool.Exec(ctx, `DELETE FROM hibp_ranges WHERE fetched_at < $1`, fetchedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune expired HIBP ranges: %w", err)
+	}
+
+	evicted, err := s.pool.Exec(ctx, `
+		DELETE FROM hibp_ranges WHERE prefix IN (
+			SELECT prefix FROM hibp_ranges ORDER BY last_used_at DESC OFFSET $1
+		)`, maxEntries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict HIBP ranges: %w", err)
+	}
+
+	return int(expired.RowsAffected() + evicted.RowsAffected()), nil
+}
+
+// GetStalePasswordHashes returns the unique password hashes from login events that carry a breach hash
+// and were never checked or last checked before checkedBefore.
+// Breach hashes are encrypted with a random nonce, so any one of them is returned per password hash.
+func (s *PostgresStore) GetStalePasswordHashes(ctx context.Context, checkedBefore time.Time) ([]models.StalePasswordHash, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT e.hash, e.breach_hash, r.hash IS NOT NULL, COALESCE(r.breach_count, 0)
+		FROM (
+			SELECT hash, MIN(breach_hash) AS breach_hash FROM login_events WHERE breach_hash <> '' GROUP BY hash
+		) e
+		LEFT JOIN hibp_results r ON r.hash = e.hash
+		WHERE r.hash IS NULL OR r.checked_at < $1`, checkedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query password hashes: %w", err)
+	}
+
+	hashes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.StalePasswordHash, error) {
+		var hash models.StalePasswordHash
+		err := row.Scan(&hash.Hash, &hash.BreachHash, &hash.Checked, &hash.BreachCount)
+		return hash, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query password hashes: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// AddBreachEvent records that a previously clean password was found in a breach
+func (s *PostgresStore) AddBreachEvent(ctx context.Context, event events.BreachEvent) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO breach_events (timestamp, hash, breach_count, previous_breach_count) VALUES ($1, $2, $3, $4)`,
+		event.Timestamp.UTC(), event.Hash, event.BreachCount, event.PreviousBreachCount)
+	if err != nil {
+		return fmt.Errorf("failed to insert breach event: %w", err)
+	}
+
+	return nil
+}
+
+// RekeyPasswordHashes rewrites every stored fingerprint and breach hash after a fingerprint key rotation.
+// Everything is rewritten in a single transaction so a failure leaves the old values intact.
+func (s *PostgresStore) RekeyPasswordHashes(ctx context.Context, rekeyFingerprint, rekeyBreachHash func(string) (string, error)) (int, error) {
+	// rekeying touches every row, so it is not bound by the default query timeout
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT hash FROM login_events
+		UNION SELECT hash FROM hibp_results
+		UNION SELECT hash FROM breach_events`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query fingerprints: %w", err)
+	}
+
+	hashes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to query fingerprints: %w", err)
+	}
+
+	changed := 0
+
+	for _, hash := range hashes {
+		newHash, err := rekeyFingerprint(hash)
+		if err != nil {
+			return 0, err
+		}
+		if newHash == hash {
+			continue
+		}
+
+		statements := []string{
+			`UPDATE login_events SET hash = $1 WHERE hash = $2`,
+			`UPDATE breach_events SET hash = $1 WHERE hash = $2`,
+			// another value may already have been stored under the new fingerprint, keep that result
+			`UPDATE hibp_results SET hash = $1 WHERE hash = $2 AND NOT EXISTS (SELECT 1 FROM hibp_results WHERE hash = $1)`,
+			`DELETE FROM hibp_results WHERE hash = $2`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement, newHash, hash); err != nil {
+				return 0, fmt.Errorf("failed to rekey fingerprint: %w", err)
+			}
+		}
+
+		changed++
+	}
+
+	type breachHashRow struct {
+		ID         int64
+		BreachHash string
+	}
+
+	rows, err = tx.Query(ctx, `SELECT id, breach_hash FROM login_events WHERE breach_hash <> ''`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query breach hashes: %w", err)
+	}
+
+	breachHashes, err := pgx.CollectRows(rows, pgx.RowToStructByPos[breachHashRow])
+	if err != nil {
+		return 0, fmt.Errorf("failed to query breach hashes: %w", err)
+	}
+
+	for _, row := range breachHashes {
+		newBreachHash, err := rekeyBreachHash(row.BreachHash)
+		if err != nil {
+			return 0, err
+		}
+		if newBreachHash == row.BreachHash {
+			continue
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE login_events SET breach_hash = $1 WHERE id = $2`, newBreachHash, row.ID); err != nil {
+			return 0, fmt.Errorf("failed to rekey breach hash: %w", err)
+		}
+
+		changed++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit rekeyed password hashes: %w", err)
+	}
+
+	return changed, nil
+}
+
+// NormalizeDomains recomputes the host, registrable domain and tenant of every stored host
+func (s *PostgresStore) NormalizeDomains(ctx context.Context, normalize func(host string) domainname.Name) (int, error) {
+	// like rekeying this touches every row, so it is not bound by the default query timeout
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT DISTINCT host FROM login_events`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query hosts: %w", err)
+	}
+
+	hosts, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to query hosts: %w", err)
+	}
+
+	changed := 0
+
+	for _, host := range hosts {
+		name := normalize(host)
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE login_events SET host = $1, domain = $2, tenant = $3
+			WHERE host = $4 AND (host <> $1 OR domain <> $2 OR tenant <> $3)`,
+			name.Host, name.Domain, name.Tenant, host)
+		if err != nil {
+			return 0, fmt.Errorf("failed to normalize domain: %w", err)
+		}
+
+		changed += int(tag.RowsAffected())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit normalized domains: %w", err)
+	}
+
+	return changed, nil
+}
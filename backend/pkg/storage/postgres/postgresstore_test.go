@@ -0,0 +1,57 @@
+package postgres_test
+
+import (
+	"context"
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/postgres"
+	"github.com/hazcod/shade/pkg/storage/storagetest"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"testing"
+)
+
+// dsnEnv is the environment variable holding the database to test against, the tests are skipped without it.
+// Every table is emptied before each test, so never point it at a database holding data you want to keep.
+const dsnEnv = "SHADE_TEST_POSTGRES_DSN"
+
+// truncate empties every table the migrations created
+func truncate(t *testing.T, dsn string) {
+	t.Helper()
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+
+	_, err = conn.Exec(ctx, `TRUNCATE login_events, hibp_results, devices, breach_events, hibp_ranges, hibp_verdicts,
+		app_reviews, policies, enforcement_events, request_nonces RESTART IDENTITY`)
+	if err != nil {
+		t.Fatalf("failed to truncate tables: %v", err)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", dsnEnv)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storagetest.Run(t, func(t *testing.T) storage.Driver {
+		store := &postgres.PostgresStore{}
+		if err := store.Init(logger, map[string]string{"token": storagetest.Token, "dsn": dsn}); err != nil {
+			t.Fatalf("failed to init store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+
+		truncate(t, dsn)
+
+		return store
+	})
+}
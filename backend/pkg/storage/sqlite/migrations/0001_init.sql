@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS login_events (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp DATETIME NOT NULL,
+    username  TEXT NOT NULL,
+    domain    TEXT NOT NULL,
+    hash      TEXT NOT NULL,
+    device_id TEXT NOT NULL,
+    ip        TEXT NOT NULL DEFAULT '',
+    hostname  TEXT NOT NULL DEFAULT '',
+    has_mfa   BOOLEAN NOT NULL DEFAULT 0,
+    mfa_type  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_username ON login_events (username);
+CREATE INDEX IF NOT EXISTS idx_login_events_domain ON login_events (domain);
+CREATE INDEX IF NOT EXISTS idx_login_events_hash ON login_events (hash);
+CREATE INDEX IF NOT EXISTS idx_login_events_device_id ON login_events (device_id);
+CREATE INDEX IF NOT EXISTS idx_login_events_username_hash ON login_events (username, hash);
+
+CREATE TABLE IF NOT EXISTS hibp_results (
+    hash         TEXT PRIMARY KEY,
+    breach_count INTEGER NOT NULL,
+    checked_at   DATETIME NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS devices (
+    device_id       TEXT PRIMARY KEY,
+    credential_hash TEXT UNIQUE,
+    enrolled_at     DATETIME,
+    revoked_at      DATETIME
+);
@@ -0,0 +1,3 @@
+ALTER TABLE login_events ADD COLUMN breach_hash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_login_events_breach_hash ON login_events (breach_hash);
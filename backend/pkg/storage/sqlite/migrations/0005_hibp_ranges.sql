@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS hibp_ranges (
+    prefix       TEXT PRIMARY KEY,
+    body         TEXT NOT NULL,
+    fetched_at   DATETIME NOT NULL,
+    last_used_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_hibp_ranges_last_used_at ON hibp_ranges (last_used_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS hibp_verdicts (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id    TEXT NOT NULL,
+    domain       TEXT NOT NULL,
+    username     TEXT NOT NULL,
+    breach_count INTEGER NOT NULL,
+    checked_at   DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_hibp_verdicts_device_id ON hibp_verdicts (device_id);
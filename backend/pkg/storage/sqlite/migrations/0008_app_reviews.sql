@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS app_reviews (
+    app           TEXT PRIMARY KEY,
+    status        TEXT NOT NULL,
+    owner         TEXT NOT NULL DEFAULT '',
+    justification TEXT NOT NULL DEFAULT '',
+    review_date   DATETIME,
+    updated_by    TEXT NOT NULL,
+    updated_at    DATETIME NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS policies (
+    version    INTEGER PRIMARY KEY,
+    document   TEXT NOT NULL,
+    updated_by TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+);
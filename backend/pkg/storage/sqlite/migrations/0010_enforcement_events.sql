@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS enforcement_events (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp DATETIME NOT NULL,
+    username  TEXT NOT NULL,
+    domain    TEXT NOT NULL,
+    host      TEXT NOT NULL,
+    app       TEXT NOT NULL,
+    device_id TEXT NOT NULL,
+    action    TEXT NOT NULL,
+    reason    TEXT NOT NULL DEFAULT '',
+    message   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_enforcement_events_action ON enforcement_events (action, id);
@@ -0,0 +1,11 @@
+ALTER TABLE devices ADD COLUMN signing_key TEXT;
+
+-- credentials from before signing keys double as the signing key, enrolled devices have to enroll again
+UPDATE devices SET credential_hash = NULL WHERE revoked_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS request_nonces (
+    nonce      TEXT PRIMARY KEY,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_nonces_expires_at ON request_nonces (expires_at);
@@ -0,0 +1,1485 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/shade/pkg/domainname"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPath = "shade.db"
+)
+
+type SQLiteStore struct {
+	logger *logrus.Logger
+
+	db    *sql.DB
+	token string
+}
+
+func (s *SQLiteStore) Init(logger *logrus.Logger, settings map[string]string) error {
+	s.logger = logger
+
+	token, ok := settings["token"]
+	if !ok || token == "" {
+		return errors.New("token required for sqlite store")
+	}
+
+	s.token = token
+
+	dbPath := settings["path"]
+	if dbPath == "" {
+		dbPath = defaultPath
+	}
+
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", dbPath)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// sqlite only supports a single writer, so serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to sqlite database: %w", err)
+	}
+
+	s.db = db
+
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate sqlite database: %w", err)
+	}
+
+	s.logger.WithField("path", dbPath).Debug("opened sqlite database")
+
+	return nil
+}
+
+// Close checkpoints the write-ahead log into the database file and closes it
+func (s *SQLiteStore) Close() error {
+	if _, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		s.logger.WithError(err).Warn("failed to checkpoint sqlite database")
+	}
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed to close sqlite database: %w", err)
+	}
+
+	return nil
+}
+
+// likePattern returns a LIKE pattern matching values that contain search
+func likePattern(search string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(search) + "%"
+}
+
+// afterCursor returns the condition selecting the rows sorting after the cursor of the query on the key columns,
+// numbering its parameters from first on. Every row matches when the query has no cursor.
+// Integer key columns must have integer affinity, e.g. by casting aggregates, as cursor values are text.
+func afterCursor(query models.Query, descending bool, first int, columns ...string) (string, []interface{}, error) {
+	after, err := query.After()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if after == nil {
+		return "1 = 1", nil, nil
+	}
+
+	if len(after) != len(columns) {
+		return "", nil, fmt.Errorf("invalid cursor: %s", query.Cursor)
+	}
+
+	operator := ">"
+	if descending {
+		operator = "<"
+	}
+
+	params := make([]string, len(after))
+	args := make([]interface{}, len(after))
+	for i, value := range after {
+		params[i] = "?" + strconv.Itoa(first+i)
+		args[i] = value
+	}
+
+	return "(" + strings.Join(columns, ", ") + ") " + operator + " (" + strings.Join(params, ", ") + ")", args, nil
+}
+
+// parseTimestamp parses a timestamp returned by an aggregate, the driver only converts columns declared as DATETIME
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if timestamp, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return timestamp, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", value)
+}
+
+// queryStrings runs a query returning a single string column
+func (s *SQLiteStore) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+
+	return results, rows.Err()
+}
+
+func (s *SQLiteStore) GetAllDomains(ctx context.Context, query models.Query) (models.Page[string], error) {
+	column := query.Level.Column()
+
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, column)
+	if err != nil {
+		return models.Page[string]{}, err
+	}
+
+	domains, err := s.queryStrings(ctx, `
+		SELECT DISTINCT `+column+` FROM login_events
+		WHERE `+column+` LIKE ?1 ESCAPE '\' AND `+after+`
+		ORDER BY `+column+` `+query.SortDirection()+`
+		LIMIT ?2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[string]{}, fmt.Errorf("failed to query domains: %w", err)
+	}
+
+	return models.NewPage(domains, query.PageLimit(), func(i int) []string { return []string{domains[i]} }), nil
+}
+
+func (s *SQLiteStore) GetDomainsForUser(ctx context.Context, username string) ([]string, error) {
+	domains, err := s.queryStrings(ctx, `SELECT DISTINCT domain FROM login_events WHERE username = ? ORDER BY domain`,
+		strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domains for user: %w", err)
+	}
+
+	return domains, nil
+}
+
+// GetDuplicatePasswordsForDevice returns the groups of domains whose current password submitted by the device
+// is the same. Only groups of two or more domains are returned, limited to the one of passwordHash if set.
+// Accounts are told apart by host, their domains are grouped at the given level.
+func (s *SQLiteStore) GetDuplicatePasswordsForDevice(ctx context.Context, deviceID, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	column := level.Column()
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH latest AS (
+			SELECT host, username, MAX(timestamp) AS timestamp FROM login_events
+			WHERE device_id = ? GROUP BY host, username
+		), current_passwords AS (
+			SELECT DISTINCT e.hash, e.`+column+` AS domain FROM login_events e
+			JOIN latest l ON e.host = l.host AND e.username = l.username AND e.timestamp = l.timestamp
+			WHERE e.device_id = ? AND (? = '' OR e.hash = ?)
+		)
+		SELECT group_concat(domain, ',')
+		FROM (SELECT hash, domain FROM current_passwords ORDER BY domain)
+		GROUP BY hash
+		HAVING COUNT(*) > 1
+		ORDER BY MIN(domain)`, deviceID, deviceID, passwordHash, passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate passwords for device: %w", err)
+	}
+	defer rows.Close()
+
+	dupes := make([][]string, 0)
+	for rows.Next() {
+		var domains string
+		if err := rows.Scan(&domains); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+		}
+		dupes = append(dupes, strings.Split(domains, ","))
+	}
+
+	return dupes, rows.Err()
+}
+
+func (s *SQLiteStore) GetDuplicatePasswords(ctx context.Context, query models.Query) (models.Page[models.DuplicatePasswordEntry], error) {
+	column := query.Level.Column()
+
+	// the first login with a password tells apart passwords of a user used on the same domains
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "username", "domains", "CAST(id AS INTEGER)")
+	if err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT username, domains, id
+		FROM (
+			SELECT username, group_concat(domain, ',') AS domains, MIN(id) AS id
+			FROM (
+				SELECT username, hash, `+column+` AS domain, MIN(id) AS id FROM login_events
+				WHERE username LIKE ?1 ESCAPE '\'
+				GROUP BY username, hash, `+column+`
+				ORDER BY `+column+`
+			)
+			GROUP BY username, hash
+			HAVING COUNT(*) > 1
+		)
+		WHERE `+after+`
+		ORDER BY username `+query.SortDirection()+`, domains `+query.SortDirection()+`, id `+query.SortDirection()+`
+		LIMIT ?2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, fmt.Errorf("failed to query duplicate passwords: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]models.DuplicatePasswordEntry, 0)
+	keys := make([][]string, 0)
+	for rows.Next() {
+		var user, domains string
+		var id int64
+		if err := rows.Scan(&user, &domains, &id); err != nil {
+			return models.Page[models.DuplicatePasswordEntry]{}, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+		}
+
+		entries = append(entries, models.DuplicatePasswordEntry{
+			User:    user,
+			Domains: strings.Split(domains, ","),
+		})
+		keys = append(keys, []string{user, domains, strconv.FormatInt(id, 10)})
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.DuplicatePasswordEntry]{}, fmt.Errorf("failed to read duplicate passwords: %w", err)
+	}
+
+	return models.NewPage(entries, query.PageLimit(), func(i int) []string { return keys[i] }), nil
+}
+
+func (s *SQLiteStore) IsValidToken(_ context.Context, token string) (bool, error) {
+	return subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) == 1, nil
+}
+
+func (s *SQLiteStore) EnrollDevice(ctx context.Context, deviceID, credentialHash, signingKey string) error {
+	// enrolled and revoked devices keep their row, so the conflict update only takes over reset devices
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO devices (device_id, credential_hash, signing_key, enrolled_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET credential_hash = excluded.credential_hash,
+			signing_key = excluded.signing_key, enrolled_at = excluded.enrolled_at
+		WHERE devices.revoked_at IS NULL AND devices.credential_hash IS NULL`,
+		deviceID, credentialHash, signingKey, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to enroll device: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to enroll device: %w", err)
+	}
+
+	if affected == 0 {
+		var revoked bool
+		if err := s.db.QueryRowContext(ctx, `SELECT revoked_at IS NOT NULL FROM devices WHERE device_id = ?`,
+			deviceID).Scan(&revoked); err != nil {
+			return fmt.Errorf("failed to enroll device: %w", err)
+		}
+
+		if revoked {
+			return models.ErrDeviceRevoked
+		}
+
+		return models.ErrDeviceEnrolled
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("enrolled device")
+
+	return nil
+}
+
+func (s *SQLiteStore) GetDeviceForCredential(ctx context.Context, credentialHash string) (string, bool, error) {
+	var deviceID string
+
+	err := s.db.QueryRowContext(ctx, `SELECT device_id FROM devices WHERE credential_hash = ? AND revoked_at IS NULL`,
+		credentialHash).Scan(&deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query device credential: %w", err)
+	}
+
+	return deviceID, true, nil
+}
+
+func (s *SQLiteStore) GetDeviceSigningKey(ctx context.Context, deviceID string) (string, error) {
+	var signingKey sql.NullString
+
+	err := s.db.QueryRowContext(ctx, `SELECT signing_key FROM devices WHERE device_id = ? AND revoked_at IS NULL`,
+		deviceID).Scan(&signingKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to get device signing key: %w", err)
+	}
+
+	return signingKey.String, nil
+}
+
+func (s *SQLiteStore) RevokeDevice(ctx context.Context, deviceID string) error {
+	// devices that never enrolled are recorded as well, so they cannot enroll later on
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO devices (device_id, revoked_at) VALUES (?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET credential_hash = NULL, signing_key = NULL, revoked_at = excluded.revoked_at`,
+		deviceID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("revoked device")
+
+	return nil
+}
+
+func (s *SQLiteStore) ResetDevice(ctx context.Context, deviceID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE devices SET credential_hash = NULL, signing_key = NULL, revoked_at = NULL WHERE device_id = ?`,
+		deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to reset device: %w", err)
+	}
+
+	s.logger.WithField("device_id", deviceID).Debug("reset device")
+
+	return nil
+}
+
+func (s *SQLiteStore) UseNonce(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	// an expired nonce can be taken over, its timestamp would be rejected by now anyway
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO request_nonces (nonce, expires_at) VALUES (?1, ?2)
+		ON CONFLICT (nonce) DO UPDATE SET expires_at = excluded.expires_at WHERE request_nonces.expires_at < ?3`,
+		nonce, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to store nonce: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store nonce: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (s *SQLiteStore) DeleteExpiredNonces(ctx context.Context, now time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM request_nonces WHERE expires_at < ?`, now.UTC()); err != nil {
+		return fmt.Errorf("failed to delete expired nonces: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetCompromisedPasswords(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT hash, breach_count FROM hibp_results WHERE breach_count > 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compromised passwords: %w", err)
+	}
+	defer rows.Close()
+
+	compromised := make(map[string]string)
+	for rows.Next() {
+		var hash string
+		var breachCount int
+		if err := rows.Scan(&hash, &breachCount); err != nil {
+			return nil, fmt.Errorf("failed to scan compromised passwords: %w", err)
+		}
+		compromised[hash] = fmt.Sprintf("%d", breachCount)
+	}
+
+	return compromised, rows.Err()
+}
+
+// GetCompromisedAccounts returns the accounts submitted by a device whose latest password is breached.
+// The latest login of an account is taken from any device, the password may have been changed elsewhere.
+func (s *SQLiteStore) GetCompromisedAccounts(ctx context.Context, deviceID string) ([]models.CompromisedAccount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH accounts AS (
+			SELECT DISTINCT domain, username FROM login_events WHERE device_id = ?
+		), latest AS (
+			SELECT e.domain, e.username, MAX(e.timestamp) AS timestamp
+			FROM login_events e JOIN accounts a ON e.domain = a.domain AND e.username = a.username
+			GROUP BY e.domain, e.username
+		)
+		SELECT DISTINCT e.domain, e.username, r.breach_count, r.checked_at
+		FROM login_events e
+		JOIN latest l ON e.domain = l.domain AND e.username = l.username AND e.timestamp = l.timestamp
+		JOIN hibp_results r ON r.hash = e.hash
+		WHERE r.breach_count > 0
+		ORDER BY r.breach_count DESC, e.domain`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compromised accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := make([]models.CompromisedAccount, 0)
+	for rows.Next() {
+		var account models.CompromisedAccount
+		if err := rows.Scan(&account.Domain, &account.Username, &account.BreachCount, &account.CheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan compromised account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query compromised accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// domainUsersQuery returns the common table expressions aggregating the logins on the domain ?1 per user.
+// The current passwords of a user are the last ones submitted for every host of the domain.
+func domainUsersQuery(column string) string {
+	return `
+		WITH domain_events AS (
+			SELECT * FROM login_events WHERE ` + column + ` = ?1
+		), latest AS (
+			SELECT e.username, e.hash FROM domain_events e
+			JOIN (
+				SELECT host, username, MAX(timestamp) AS timestamp FROM domain_events GROUP BY host, username
+			) l ON e.host = l.host AND e.username = l.username AND e.timestamp = l.timestamp
+		), passwords AS (
+			SELECT l.username,
+				MAX(EXISTS (
+					SELECT 1 FROM login_events o
+					WHERE o.username = l.username AND o.hash = l.hash AND o.` + column + ` != ?1
+				)) AS reuses,
+				MAX(COALESCE(r.breach_count, 0)) AS breach_count
+			FROM latest l
+			LEFT JOIN hibp_results r ON r.hash = l.hash
+			GROUP BY l.username
+		), domain_users AS (
+			SELECT username, COUNT(*) AS logins, COUNT(DISTINCT device_id) AS devices,
+				MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen, MAX(has_mfa) AS has_mfa
+			FROM domain_events
+			GROUP BY username
+		)`
+}
+
+// GetDomainSummary aggregates the logins on a registrable domain or host
+func (s *SQLiteStore) GetDomainSummary(ctx context.Context, domain string, level models.DomainLevel) (models.DomainSummary, bool, error) {
+	var summary models.DomainSummary
+	var firstSeen, lastSeen sql.NullString
+
+	err := s.db.QueryRowContext(ctx, domainUsersQuery(level.Column())+`
+		SELECT COUNT(*), COALESCE(SUM(u.logins), 0), MIN(u.first_seen), MAX(u.last_seen),
+			COALESCE(SUM(u.has_mfa), 0), COALESCE(SUM(p.reuses), 0), COALESCE(SUM(p.breach_count > 0), 0),
+			(SELECT COUNT(DISTINCT device_id) FROM domain_events)
+		FROM domain_users u
+		JOIN passwords p ON p.username = u.username`, strings.ToLower(domain)).
+		Scan(&summary.Users, &summary.Logins, &firstSeen, &lastSeen,
+			&summary.UsersWithMFA, &summary.ReusingUsers, &summary.BreachedUsers, &summary.Devices)
+	if err != nil {
+		return models.DomainSummary{}, false, fmt.Errorf("failed to query domain summary: %w", err)
+	}
+
+	if summary.Users == 0 {
+		return models.DomainSummary{}, false, nil
+	}
+
+	if summary.FirstSeen, err = parseTimestamp(firstSeen.String); err != nil {
+		return models.DomainSummary{}, false, err
+	}
+	if summary.LastSeen, err = parseTimestamp(lastSeen.String); err != nil {
+		return models.DomainSummary{}, false, err
+	}
+
+	return summary, true, nil
+}
+
+// GetDomainUsers returns the users that logged in on a registrable domain or host
+func (s *SQLiteStore) GetDomainUsers(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainUser], error) {
+	after, afterArgs, err := afterCursor(query, query.Descending, 4, "u.username")
+	if err != nil {
+		return models.Page[models.DomainUser]{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, domainUsersQuery(level.Column())+`
+		SELECT u.username, u.logins, u.devices, u.first_seen, u.last_seen, u.has_mfa, p.reuses, p.breach_count
+		FROM domain_users u
+		JOIN passwords p ON p.username = u.username
+		WHERE u.username LIKE ?2 ESCAPE '\' AND `+after+`
+		ORDER BY u.username `+query.SortDirection()+`
+		LIMIT ?3`,
+		append([]interface{}{strings.ToLower(domain), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DomainUser]{}, fmt.Errorf("failed to query domain users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.DomainUser, 0)
+	for rows.Next() {
+		var user models.DomainUser
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&user.Username, &user.Logins, &user.Devices, &firstSeen, &lastSeen,
+			&user.HasMFA, &user.ReusesPassword, &user.BreachCount); err != nil {
+			return models.Page[models.DomainUser]{}, fmt.Errorf("failed to scan domain user: %w", err)
+		}
+
+		if user.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return models.Page[models.DomainUser]{}, err
+		}
+		if user.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return models.Page[models.DomainUser]{}, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.DomainUser]{}, fmt.Errorf("failed to read domain users: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string { return []string{users[i].Username} }), nil
+}
+
+// GetDomainDevices returns the devices used to log in on a registrable domain or host, most recently used first
+func (s *SQLiteStore) GetDomainDevices(ctx context.Context, domain string, level models.DomainLevel, query models.Query) (models.Page[models.DomainDevice], error) {
+	after, afterArgs, err := afterCursor(query, true, 4, "e.timestamp", "e.device_id")
+	if err != nil {
+		return models.Page[models.DomainDevice]{}, err
+	}
+
+	// the most recent login of every device on the domain
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.device_id, e.username, e.hostname, e.ip, e.timestamp, d.logins, CAST(e.timestamp AS TEXT)
+		FROM login_events e
+		JOIN (
+			SELECT device_id, COUNT(*) AS logins, MAX(id) AS id FROM login_events
+			WHERE `+level.Column()+` = ?1 GROUP BY device_id
+		) d ON d.id = e.id
+		WHERE (e.username LIKE ?2 ESCAPE '\' OR e.hostname LIKE ?2 ESCAPE '\') AND `+after+`
+		ORDER BY e.timestamp DESC, e.device_id DESC
+		LIMIT ?3`,
+		append([]interface{}{strings.ToLower(domain), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.DomainDevice]{}, fmt.Errorf("failed to query domain devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := make([]models.DomainDevice, 0)
+	lastSeen := make([]string, 0)
+	for rows.Next() {
+		var device models.DomainDevice
+		var timestamp string
+		if err := rows.Scan(&device.DeviceID, &device.Username, &device.Hostname, &device.IP,
+			&device.LastSeen, &device.Logins, &timestamp); err != nil {
+			return models.Page[models.DomainDevice]{}, fmt.Errorf("failed to scan domain device: %w", err)
+		}
+		devices = append(devices, device)
+		lastSeen = append(lastSeen, timestamp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.DomainDevice]{}, fmt.Errorf("failed to read domain devices: %w", err)
+	}
+
+	return models.NewPage(devices, query.PageLimit(), func(i int) []string {
+		return []string{lastSeen[i], devices[i].DeviceID}
+	}), nil
+}
+
+// GetDomainTimeline returns the days since the given time with logins on a registrable domain or host, oldest first
+func (s *SQLiteStore) GetDomainTimeline(ctx context.Context, domain string, level models.DomainLevel, since time.Time) ([]models.TimelineDay, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date(timestamp) AS day, COUNT(*), COUNT(DISTINCT username)
+		FROM login_events
+		WHERE `+level.Column()+` = ? AND timestamp >= ?
+		GROUP BY day
+		ORDER BY day`,
+		strings.ToLower(domain), since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain timeline: %w", err)
+	}
+	defer rows.Close()
+
+	timeline := make([]models.TimelineDay, 0)
+	for rows.Next() {
+		var timelineDay models.TimelineDay
+		var day string
+		if err := rows.Scan(&day, &timelineDay.Logins, &timelineDay.Users); err != nil {
+			return nil, fmt.Errorf("failed to scan domain timeline: %w", err)
+		}
+
+		if timelineDay.Day, err = time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("invalid timeline day %q: %w", day, err)
+		}
+
+		timeline = append(timeline, timelineDay)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain timeline: %w", err)
+	}
+
+	return timeline, nil
+}
+
+// GetDuplicatePasswordsForUser returns the groups of domains whose current password of the user is the same.
+// Only groups of two or more domains are returned, limited to the one of passwordHash if set.
+// Accounts are told apart by host, their domains are grouped at the given level.
+func (s *SQLiteStore) GetDuplicatePasswordsForUser(ctx context.Context, username, passwordHash string, level models.DomainLevel) ([][]string, error) {
+	column := level.Column()
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH latest AS (
+			SELECT host, MAX(timestamp) AS timestamp FROM login_events
+			WHERE username = ?1 GROUP BY host
+		), current_passwords AS (
+			SELECT DISTINCT e.hash, e.`+column+` AS domain FROM login_events e
+			JOIN latest l ON e.host = l.host AND e.timestamp = l.timestamp
+			WHERE e.username = ?1 AND (?2 = '' OR e.hash = ?2)
+		)
+		SELECT group_concat(domain, ',')
+		FROM (SELECT hash, domain FROM current_passwords ORDER BY domain)
+		GROUP BY hash
+		HAVING COUNT(*) > 1
+		ORDER BY MIN(domain)`, strings.ToLower(username), passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate passwords for user: %w", err)
+	}
+	defer rows.Close()
+
+	dupes := make([][]string, 0)
+	for rows.Next() {
+		var domains string
+		if err := rows.Scan(&domains); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate passwords: %w", err)
+		}
+		dupes = append(dupes, strings.Split(domains, ","))
+	}
+
+	return dupes, rows.Err()
+}
+
+// GetUserAccounts returns an account per registrable domain the user logged in on, sorted on domain
+func (s *SQLiteStore) GetUserAccounts(ctx context.Context, username string) ([]models.UserAccount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH latest AS (
+			SELECT e.domain, e.hash FROM login_events e
+			JOIN (
+				SELECT host, MAX(timestamp) AS timestamp FROM login_events WHERE username = ?1 GROUP BY host
+			) l ON e.host = l.host AND e.timestamp = l.timestamp
+			WHERE e.username = ?1
+		), breaches AS (
+			SELECT l.domain, MAX(COALESCE(r.breach_count, 0)) AS breach_count
+			FROM latest l
+			LEFT JOIN hibp_results r ON r.hash = l.hash
+			GROUP BY l.domain
+		)
+		SELECT e.domain, COUNT(*), MIN(e.timestamp), MAX(e.timestamp), MAX(e.has_mfa), COALESCE(b.breach_count, 0)
+		FROM login_events e
+		LEFT JOIN breaches b ON b.domain = e.domain
+		WHERE e.username = ?1
+		GROUP BY e.domain
+		ORDER BY e.domain`, strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := make([]models.UserAccount, 0)
+	for rows.Next() {
+		var account models.UserAccount
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&account.Domain, &account.Logins, &firstSeen, &lastSeen,
+			&account.HasMFA, &account.BreachCount); err != nil {
+			return nil, fmt.Errorf("failed to scan user account: %w", err)
+		}
+
+		if account.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, err
+		}
+		if account.LastSeen, err = parseTimestamp(lastSeen); err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read user accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// GetUserDevices returns every device and IP address the user logged in from, most recently used first
+func (s *SQLiteStore) GetUserDevices(ctx context.Context, username string) ([]models.UserDevice, error) {
+	// the most recent login of every device and IP address, holding the current hostname
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.device_id, e.hostname, e.ip, d.logins, d.first_seen, e.timestamp
+		FROM login_events e
+		JOIN (
+			SELECT COUNT(*) AS logins, MIN(timestamp) AS first_seen, MAX(id) AS id FROM login_events
+			WHERE username = ? GROUP BY device_id, ip
+		) d ON d.id = e.id
+		ORDER BY e.timestamp DESC, e.device_id, e.ip`, strings.ToLower(username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := make([]models.UserDevice, 0)
+	for rows.Next() {
+		var device models.UserDevice
+		var firstSeen string
+		if err := rows.Scan(&device.DeviceID, &device.Hostname, &device.IP, &device.Logins, &firstSeen,
+			&device.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan user device: %w", err)
+		}
+
+		if device.FirstSeen, err = parseTimestamp(firstSeen); err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read user devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// GetUserEvents returns the logins, enforcement decisions and breached passwords of the user, oldest first
+// unless the query is descending. A breach is listed once for every domain the password was used on.
+func (s *SQLiteStore) GetUserEvents(ctx context.Context, username string, query models.Query) (models.Page[models.UserEvent], error) {
+	// the type and row id tell apart events of the same time and domain
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "timestamp", "domain", "type", "CAST(id AS INTEGER)")
+	if err != nil {
+		return models.Page[models.UserEvent]{}, err
+	}
+
+	direction := query.SortDirection()
+
+	// timestamps are compared as text, so they are formatted alike in every table
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT timestamp, type, domain, host, device_id, has_mfa, detail, breach_count, id
+		FROM (
+			SELECT strftime('%Y-%m-%d %H:%M:%f', timestamp) AS timestamp, type, domain, host, device_id, has_mfa,
+				detail, breach_count, id
+			FROM (
+				SELECT timestamp, 'login' AS type, domain, host, device_id, has_mfa, mfa_type AS detail, 0 AS breach_count, id
+				FROM login_events
+				WHERE username = ?1
+				UNION ALL
+				SELECT timestamp, action, domain, host, device_id, 0, reason, 0, id
+				FROM enforcement_events
+				WHERE lower(username) = ?1 AND action <> 'allow'
+				UNION ALL
+				SELECT b.timestamp, 'breach', e.domain, MIN(e.host), '', 0, '', b.breach_count, b.id
+				FROM breach_events b
+				JOIN login_events e ON e.hash = b.hash
+				WHERE e.username = ?1
+				GROUP BY b.id, e.domain
+			)
+		)
+		WHERE `+after+`
+		ORDER BY timestamp `+direction+`, domain `+direction+`, type `+direction+`, id `+direction+`
+		LIMIT ?2`,
+		append([]interface{}{strings.ToLower(username), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.UserEvent]{}, fmt.Errorf("failed to query user events: %w", err)
+	}
+	defer rows.Close()
+
+	userEvents := make([]models.UserEvent, 0)
+	keys := make([][]string, 0)
+	for rows.Next() {
+		var event models.UserEvent
+		var timestamp, eventType string
+		var id int64
+		if err := rows.Scan(&timestamp, &eventType, &event.Domain, &event.Host, &event.DeviceID,
+			&event.HasMFA, &event.Detail, &event.BreachCount, &id); err != nil {
+			return models.Page[models.UserEvent]{}, fmt.Errorf("failed to scan user event: %w", err)
+		}
+
+		if event.Timestamp, err = parseTimestamp(timestamp); err != nil {
+			return models.Page[models.UserEvent]{}, err
+		}
+
+		event.Type = models.UserEventType(eventType)
+		userEvents = append(userEvents, event)
+		keys = append(keys, []string{timestamp, event.Domain, eventType, strconv.FormatInt(id, 10)})
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.UserEvent]{}, fmt.Errorf("failed to read user events: %w", err)
+	}
+
+	return models.NewPage(userEvents, query.PageLimit(), func(i int) []string { return keys[i] }), nil
+}
+
+func (s *SQLiteStore) IsDuplicatePassword(ctx context.Context, username, passwordHash string) ([]string, error) {
+	domains, err := s.queryStrings(ctx, `SELECT DISTINCT domain FROM login_events WHERE username = ? AND hash = ? ORDER BY domain`,
+		strings.ToLower(username), passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicate password: %w", err)
+	}
+
+	return domains, nil
+}
+
+func (s *SQLiteStore) AddLoginEvent(ctx context.Context, data events.LoginEvent) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_events (timestamp, username, domain, host, tenant, hash, breach_hash, device_id, ip, hostname, has_mfa, mfa_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		data.Timestamp.UTC(), strings.ToLower(data.User), strings.ToLower(data.Domain), strings.ToLower(data.Host), data.Tenant,
+		data.Hash, data.BreachHash, data.DeviceID, data.IP, data.Hostname, data.HasMFA, data.MFAType)
+	if err != nil {
+		return fmt.Errorf("failed to insert login event: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"device_id": data.DeviceID,
+		"username":  data.User,
+		"timestamp": data.Timestamp.Format(time.DateTime),
+		"domain":    data.Domain,
+	}).Debug("captured login event")
+
+	return nil
+}
+
+// enrolledUserSortColumns maps query sort fields to the columns of the enrolled users query
+var enrolledUserSortColumns = map[string]string{
+	models.SortUsername: "e.username",
+	models.SortHostname: "e.hostname",
+	models.SortLastSeen: "e.timestamp",
+}
+
+func (s *SQLiteStore) GetEnrolledUsers(ctx context.Context, query models.Query) (models.Page[models.EnrolledUser], error) {
+	sortColumn, ok := enrolledUserSortColumns[query.Sort]
+	if !ok {
+		sortColumn = enrolledUserSortColumns[models.SortUsername]
+	}
+
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, sortColumn, "e.device_id")
+	if err != nil {
+		return models.Page[models.EnrolledUser]{}, err
+	}
+
+	// the most recent event of every device
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.username, e.device_id, e.hostname, e.ip, e.timestamp,
+			d.credential_hash IS NOT NULL, d.revoked_at IS NOT NULL, CAST(`+sortColumn+` AS TEXT)
+		FROM login_events e
+		JOIN (SELECT device_id, MAX(id) AS id FROM login_events GROUP BY device_id) latest ON latest.id = e.id
+		LEFT JOIN devices d ON d.device_id = e.device_id
+		WHERE (e.username LIKE ?1 ESCAPE '\' OR e.hostname LIKE ?1 ESCAPE '\') AND `+after+`
+		ORDER BY `+sortColumn+` `+query.SortDirection()+`, e.device_id `+query.SortDirection()+`
+		LIMIT ?2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to query enrolled users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.EnrolledUser, 0)
+	sortValues := make([]string, 0)
+	for rows.Next() {
+		var user models.EnrolledUser
+		var lastSeen time.Time
+		var sortValue string
+		if err := rows.Scan(&user.Username, &user.ID, &user.Hostname, &user.IP, &lastSeen,
+			&user.Enrolled, &user.Revoked, &sortValue); err != nil {
+			return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to scan enrolled user: %w", err)
+		}
+
+		if user.IP == "" {
+			user.IP = "Unknown"
+		}
+
+		if user.Hostname == "" {
+			user.Hostname = "Unknown"
+		}
+
+		user.LastSeen = lastSeen.Format("2006-01-02 15:04:05")
+		users = append(users, user)
+		sortValues = append(sortValues, sortValue)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[models.EnrolledUser]{}, fmt.Errorf("failed to read enrolled users: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string {
+		return []string{sortValues[i], users[i].ID}
+	}), nil
+}
+
+func (s *SQLiteStore) GetDashboardStats(ctx context.Context) (models.DashboardStats, error) {
+	var stats models.DashboardStats
+
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT username), COUNT(DISTINCT domain) FROM login_events`).
+		Scan(&stats.TotalUsers, &stats.TotalDomains); err != nil {
+		return stats, fmt.Errorf("failed to count users and domains: %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT username, hash FROM login_events
+			GROUP BY username, hash
+			HAVING COUNT(DISTINCT domain) > 1
+		)`).Scan(&stats.DuplicatePasswords); err != nil {
+		return stats, fmt.Errorf("failed to count duplicate passwords: %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM hibp_results WHERE breach_count > 0`).
+		Scan(&stats.CompromisedPasswords); err != nil {
+		return stats, fmt.Errorf("failed to count compromised passwords: %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT username FROM login_events
+			GROUP BY username
+			HAVING MAX(has_mfa) = 0
+		)`).Scan(&stats.UsersWithoutMFA); err != nil {
+		return stats, fmt.Errorf("failed to count users without MFA: %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM enforcement_events WHERE action = ?`,
+		string(models.EnforcementBlock)).Scan(&stats.BlockedLogins); err != nil {
+		return stats, fmt.Errorf("failed to count blocked logins: %w", err)
+	}
+
+	return stats, nil
+}
+
+func (s *SQLiteStore) GetUsersWithoutMFA(ctx context.Context, query models.Query) (models.Page[string], error) {
+	after, afterArgs, err := afterCursor(query, query.Descending, 3, "username")
+	if err != nil {
+		return models.Page[string]{}, err
+	}
+
+	users, err := s.queryStrings(ctx, `
+		SELECT username FROM login_events
+		WHERE username LIKE ?1 ESCAPE '\' AND `+after+`
+		GROUP BY username
+		HAVING MAX(has_mfa) = 0
+		ORDER BY username `+query.SortDirection()+`
+		LIMIT ?2`,
+		append([]interface{}{likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[string]{}, fmt.Errorf("failed to query users without MFA: %w", err)
+	}
+
+	return models.NewPage(users, query.PageLimit(), func(i int) []string { return []string{users[i]} }), nil
+}
+
+// StoreHIBPResult stores a HIBP breach count for a password hash
+func (s *SQLiteStore) StoreHIBPResult(ctx context.Context, passwordHash string, breachCount int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO hibp_results (hash, breach_count, checked_at) VALUES (?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET breach_count = excluded.breach_count, checked_at = excluded.checked_at`,
+		passwordHash, breachCount, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store HIBP result: %w", err)
+	}
+
+	s.logger.WithField("breach_count", breachCount).Debug("stored HIBP result")
+
+	return nil
+}
+
+// GetHIBPResult retrieves a HIBP breach count for a password hash
+func (s *SQLiteStore) GetHIBPResult(ctx context.Context, passwordHash string) (models.HIBPResult, bool, error) {
+	var result models.HIBPResult
+
+	err := s.db.QueryRowContext(ctx, `SELECT breach_count, checked_at FROM hibp_results WHERE hash = ?`, passwordHash).
+		Scan(&result.BreachCount, &result.CheckedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.HIBPResult{}, false, nil
+	}
+	if err != nil {
+		return models.HIBPResult{}, false, fmt.Errorf("failed to query HIBP result: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// AddHIBPVerdict stores a breach verdict until the device picks it up
+func (s *SQLiteStore) AddHIBPVerdict(ctx context.Context, verdict models.HIBPVerdict) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO hibp_verdicts (device_id, domain, username, breach_count, checked_at) VALUES (?, ?, ?, ?, ?)`,
+		verdict.DeviceID, verdict.Domain, verdict.Username, verdict.BreachCount, verdict.CheckedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert HIBP verdict: %w", err)
+	}
+
+	return nil
+}
+
+// TakeHIBPVerdicts returns and removes the breach verdicts of a device
+func (s *SQLiteStore) TakeHIBPVerdicts(ctx context.Context, deviceID string) ([]models.HIBPVerdict, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT device_id, domain, username, breach_count, checked_at FROM hibp_verdicts
+		WHERE device_id = ? ORDER BY id`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query HIBP verdicts: %w", err)
+	}
+	defer rows.Close()
+
+	var verdicts []models.HIBPVerdict
+	for rows.Next() {
+		var verdict models.HIBPVerdict
+		if err := rows.Scan(&verdict.DeviceID, &verdict.Domain, &verdict.Username, &verdict.BreachCount, &verdict.CheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan HIBP verdict: %w", err)
+		}
+		verdicts = append(verdicts, verdict)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query HIBP verdicts: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM hibp_verdicts WHERE device_id = ?`, deviceID); err != nil {
+		return nil, fmt.Errorf("failed to delete HIBP verdicts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return verdicts, nil
+}
+
+// GetAppReviews returns the review of every app that has one, by app
+func (s *SQLiteStore) GetAppReviews(ctx context.Context) (map[string]models.AppReview, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT app, status, owner, justification, review_date, updated_by, updated_at FROM app_reviews`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query app reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make(map[string]models.AppReview)
+	for rows.Next() {
+		var review models.AppReview
+		var reviewDate sql.NullTime
+		if err := rows.Scan(&review.App, &review.Status, &review.Owner, &review.Justification, &reviewDate,
+			&review.UpdatedBy, &review.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan app review: %w", err)
+		}
+		review.ReviewDate = reviewDate.Time
+		reviews[review.App] = review
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query app reviews: %w", err)
+	}
+
+	return reviews, nil
+}
+
+// SetAppReview creates or replaces the review of an app
+func (s *SQLiteStore) SetAppReview(ctx context.Context, review models.AppReview) error {
+	reviewDate := sql.NullTime{Time: review.ReviewDate.UTC(), Valid: !review.ReviewDate.IsZero()}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO app_reviews (app, status, owner, justification, review_date, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (app) DO UPDATE SET status = excluded.status, owner = excluded.owner,
+			justification = excluded.justification, review_date = excluded.review_date,
+			updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		review.App, review.Status, review.Owner, review.Justification, reviewDate, review.UpdatedBy, review.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store app review: %w", err)
+	}
+
+	return nil
+}
+
+// AddEnforcementEvent records whether a login was allowed, warned about or blocked
+func (s *SQLiteStore) AddEnforcementEvent(ctx context.Context, event events.EnforcementEvent) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO enforcement_events (timestamp, username, domain, host, app, device_id, action, reason, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Timestamp.UTC(), event.User, event.Domain, event.Host, event.App, event.DeviceID,
+		string(event.Action), event.Reason, event.Message)
+	if err != nil {
+		return fmt.Errorf("failed to insert enforcement event: %w", err)
+	}
+
+	return nil
+}
+
+// GetEnforcementEvents returns the recorded enforcement decisions, most recent first
+func (s *SQLiteStore) GetEnforcementEvents(ctx context.Context, query models.Query, action models.EnforcementAction) (models.Page[events.EnforcementEvent], error) {
+	after, afterArgs, err := afterCursor(query, true, 4, "id")
+	if err != nil {
+		return models.Page[events.EnforcementEvent]{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT timestamp, username, domain, host, app, device_id, action, reason, message, id
+		FROM enforcement_events
+		WHERE (?1 = '' OR action = ?1)
+			AND (username LIKE ?2 ESCAPE '\' OR host LIKE ?2 ESCAPE '\' OR app LIKE ?2 ESCAPE '\')
+			AND `+after+`
+		ORDER BY id DESC
+		LIMIT ?3`,
+		append([]interface{}{string(action), likePattern(query.Search), query.PageLimit() + 1}, afterArgs...)...)
+	if err != nil {
+		return models.Page[events.EnforcementEvent]{}, fmt.Errorf("failed to query enforcement events: %w", err)
+	}
+	defer rows.Close()
+
+	enforcementEvents := make([]events.EnforcementEvent, 0)
+	ids := make([]string, 0)
+	for rows.Next() {
+		var event events.EnforcementEvent
+		var eventAction string
+		var id int64
+		if err := rows.Scan(&event.Timestamp, &event.User, &event.Domain, &event.Host, &event.App, &event.DeviceID,
+			&eventAction, &event.Reason, &event.Message, &id); err != nil {
+			return models.Page[events.EnforcementEvent]{}, fmt.Errorf("failed to scan enforcement event: %w", err)
+		}
+
+		event.Action = models.EnforcementAction(eventAction)
+		enforcementEvents = append(enforcementEvents, event)
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Page[events.EnforcementEvent]{}, fmt.Errorf("failed to read enforcement events: %w", err)
+	}
+
+	return models.NewPage(enforcementEvents, query.PageLimit(), func(i int) []string { return []string{ids[i]} }), nil
+}
+
+// GetPolicy returns the latest version of the extension policy
+func (s *SQLiteStore) GetPolicy(ctx context.Context) (models.Policy, bool, error) {
+	var policy models.Policy
+	var version int
+	var document, updatedBy string
+	var updatedAt time.Time
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT version, document, updated_by, updated_at FROM policies ORDER BY version DESC LIMIT 1`).
+		Scan(&version, &document, &updatedBy, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Policy{}, false, nil
+	}
+	if err != nil {
+		return models.Policy{}, false, fmt.Errorf("failed to query policy: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return models.Policy{}, false, fmt.Errorf("failed to parse policy %d: %w", version, err)
+	}
+
+	// the columns are authoritative, the document only holds the settings
+	policy.Version = version
+	policy.UpdatedBy = updatedBy
+	policy.UpdatedAt = updatedAt
+
+	return policy, true, nil
+}
+
+// StorePolicy stores the policy as the next version, earlier versions are kept
+func (s *SQLiteStore) StorePolicy(ctx context.Context, policy models.Policy) (int, error) {
+	document, err := json.Marshal(policy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode policy: %w", err)
+	}
+
+	var version int
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO policies (version, document, updated_by, updated_at)
+		SELECT COALESCE(MAX(version), 0) + 1, ?, ?, ? FROM policies
+		RETURNING version`,
+		string(document), policy.UpdatedBy, policy.UpdatedAt.UTC()).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store policy: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetHIBPRange retrieves a cached HIBP range and marks it as recently used
+func (s *SQLiteStore) GetHIBPRange(ctx context.Context, prefix string) (models.HIBPRange, bool, error) {
+	var result models.HIBPRange
+
+	err := s.db.QueryRowContext(ctx, `SELECT body, fetched_at FROM hibp_ranges WHERE prefix = ?`, prefix).
+		Scan(&result.Body, &result.FetchedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.HIBPRange{}, false, nil
+	}
+	if err != nil {
+		return models.HIBPRange{}, false, fmt.Errorf("failed to query HIBP range: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE hibp_ranges SET last_used_at = ? WHERE prefix = ?`,
+		time.Now().UTC(), prefix); err != nil {
+		return models.HIBPRange{}, false, fmt.Errorf("failed to update HIBP range: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// StoreHIBPRange caches a HIBP range response
+func (s *SQLiteStore) StoreHIBPRange(ctx context.Context, prefix, body string) error {
+	now := time.Now().UTC()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO hibp_ranges (prefix, body, fetched_at, last_used_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (prefix) DO UPDATE SET body = excluded.body, fetched_at = excluded.fetched_at,
+			last_used_at = excluded.last_used_at`,
+		prefix, body, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to store HIBP range: %w", err)
+	}
+
+	return nil
+}
+
+// PruneHIBPRanges removes expired ranges and the least recently used ones beyond maxEntries
+func (s *SQLiteStore) PruneHIBPRanges(ctx context.Context, fetchedBefore time.Time, maxEntries int) (int, error) {
+	expired, err := s.db.ExecContext(ctx, `DELETE FROM hibp_ranges WHERE fetched_at < ?`, fetchedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune expired HIBP ranges: %w", err)
+	}
+
+	evicted, err := s.db.ExecContext(ctx, `
+		DELETE FROM hibp_ranges WHERE prefix IN (
+			SELECT prefix FROM hibp_ranges ORDER BY last_used_at DESC LIMIT -1 OFFSET ?
+		)`, maxEntries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict HIBP ranges: %w", err)
+	}
+
+	expiredCount, _ := expired.RowsAffected()
+	evictedCount, _ := evicted.RowsAffected()
+
+	return int(expiredCount + evictedCount), nil
+}
+
+// GetStalePasswordHashes returns the unique password hashes from login events that carry a breach hash
+// and were never checked or last checked before checkedBefore.
+// Breach hashes are encrypted with a random nonce, so any one of them is returned per password hash.
+func (s *SQLiteStore) GetStalePasswordHashes(ctx context.Context, checkedBefore time.Time) ([]models.StalePasswordHash, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.hash, e.breach_hash, r.hash IS NOT NULL, COALESCE(r.breach_count, 0)
+		FROM (
+			SELECT hash, MIN(breach_hash) AS breach_hash FROM login_events WHERE breach_hash <> '' GROUP BY hash
+		) e
+		LEFT JOIN hibp_results r ON r.hash = e.hash
+		WHERE r.hash IS NULL OR r.checked_at < ?`, checkedBefore.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query password hashes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []models.StalePasswordHash
+	for rows.Next() {
+		var hash models.StalePasswordHash
+		if err := rows.Scan(&hash.Hash, &hash.BreachHash, &hash.Checked, &hash.BreachCount); err != nil {
+			return nil, fmt.Errorf("failed to scan password hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query password hashes: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// AddBreachEvent records that a previously clean password was found in a breach
+func (s *SQLiteStore) AddBreachEvent(ctx context.Context, event events.BreachEvent) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO breach_events (timestamp, hash, breach_count, previous_breach_count) VALUES (?, ?, ?, ?)`,
+		event.Timestamp.UTC(), event.Hash, event.BreachCount, event.PreviousBreachCount)
+	if err != nil {
+		return fmt.Errorf("failed to insert breach event: %w", err)
+	}
+
+	return nil
+}
+
+// RekeyPasswordHashes rewrites every stored fingerprint and breach hash after a fingerprint key rotation.
+// Everything is rewritten in a single transaction so a failure leaves the old values intact.
+func (s *SQLiteStore) RekeyPasswordHashes(ctx context.Context, rekeyFingerprint, rekeyBreachHash func(string) (string, error)) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	hashes, err := queryTxStrings(ctx, tx, `
+		SELECT hash FROM login_events
+		UNION SELECT hash FROM hibp_results
+		UNION SELECT hash FROM breach_events`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query fingerprints: %w", err)
+	}
+
+	changed := 0
+
+	for _, hash := range hashes {
+		newHash, err := rekeyFingerprint(hash)
+		if err != nil {
+			return 0, err
+		}
+		if newHash == hash {
+			continue
+		}
+
+		statements := []string{
+			`UPDATE login_events SET hash = ? WHERE hash = ?`,
+			`UPDATE breach_events SET hash = ? WHERE hash = ?`,
+			// another value may already have been stored under the new fingerprint, keep that result
+			`UPDATE hibp_results SET hash = ?1 WHERE hash = ?2 AND NOT EXISTS (SELECT 1 FROM hibp_results WHERE hash = ?1)`,
+			`DELETE FROM hibp_results WHERE hash = ?2`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement, newHash, hash); err != nil {
+				return 0, fmt.Errorf("failed to rekey fingerprint: %w", err)
+			}
+		}
+
+		changed++
+	}
+
+	breachHashes, err := queryTxIDValues(ctx, tx, `SELECT id, breach_hash FROM login_events WHERE breach_hash <> ''`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query breach hashes: %w", err)
+	}
+
+	for id, breachHash := range breachHashes {
+		newBreachHash, err := rekeyBreachHash(breachHash)
+		if err != nil {
+			return 0, err
+		}
+		if newBreachHash == breachHash {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE login_events SET breach_hash = ? WHERE id = ?`, newBreachHash, id); err != nil {
+			return 0, fmt.Errorf("failed to rekey breach hash: %w", err)
+		}
+
+		changed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rekeyed password hashes: %w", err)
+	}
+
+	return changed, nil
+}
+
+// NormalizeDomains recomputes the host, registrable domain and tenant of every stored host
+func (s *SQLiteStore) NormalizeDomains(ctx context.Context, normalize func(host string) domainname.Name) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	hosts, err := queryTxStrings(ctx, tx, `SELECT DISTINCT host FROM login_events`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query hosts: %w", err)
+	}
+
+	changed := 0
+
+	for _, host := range hosts {
+		name := normalize(host)
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE login_events SET host = ?1, domain = ?2, tenant = ?3
+			WHERE host = ?4 AND (host <> ?1 OR domain <> ?2 OR tenant <> ?3)`,
+			name.Host, name.Domain, name.Tenant, host)
+		if err != nil {
+			return 0, fmt.Errorf("failed to normalize domain: %w", err)
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to normalize domain: %w", err)
+		}
+
+		changed += int(affected)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit normalized domains: %w", err)
+	}
+
+	return changed, nil
+}
+
+// queryTxStrings runs a query returning a single string column within a transaction
+func queryTxStrings(ctx context.Context, tx *sql.Tx, query string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+
+	return results, rows.Err()
+}
+
+// queryTxIDValues runs a query returning an id and a string column within a transaction
+func queryTxIDValues(ctx context.Context, tx *sql.Tx, query string) (map[int64]string, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		results[id] = value
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,30 @@
+package sqlite_test
+
+import (
+	"github.com/hazcod/shade/pkg/storage"
+	"github.com/hazcod/shade/pkg/storage/sqlite"
+	"github.com/hazcod/shade/pkg/storage/storagetest"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storagetest.Run(t, func(t *testing.T) storage.Driver {
+		store := &sqlite.SQLiteStore{}
+		settings := map[string]string{
+			"token": storagetest.Token,
+			"path":  filepath.Join(t.TempDir(), "shade.db"),
+		}
+		if err := store.Init(logger, settings); err != nil {
+			t.Fatalf("failed to init store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+
+		return store
+	})
+}
@@ -0,0 +1,623 @@
+// Package storagetest holds the tests every storage driver has to pass, so the drivers behave alike
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/shade/pkg/events"
+	"github.com/hazcod/shade/pkg/models"
+	"github.com/hazcod/shade/pkg/storage"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Token is the bootstrap token drivers under test must be initialized with
+const Token = "bootstrap"
+
+// base is the time of the first login of the fixtures
+var base = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// logins are the fixtures of the queries on login events:
+// alice reuses a password on slack.com and github.com and has used MFA once, bob never used MFA
+var logins = []events.LoginEvent{
+	{Timestamp: base, User: "alice", Domain: "slack.com", Host: "app.slack.com", Hash: "h1", DeviceID: "dev1", HasMFA: true, MFAType: "totp"},
+	{Timestamp: base.Add(time.Minute), User: "alice", Domain: "github.com", Host: "github.com", Hash: "h1", DeviceID: "dev1"},
+	{Timestamp: base.Add(2 * time.Minute), User: "alice", Domain: "atlassian.net", Host: "acme.atlassian.net", Hash: "h2", DeviceID: "dev2"},
+	{Timestamp: base.Add(3 * time.Minute), User: "bob", Domain: "github.com", Host: "github.com", Hash: "h3", DeviceID: "dev3"},
+	{Timestamp: base.Add(4 * time.Minute), User: "bob", Domain: "slack.com", Host: "app.slack.com", Hash: "h4", DeviceID: "dev3"},
+	{Timestamp: base.Add(5 * time.Minute), User: "carol", Domain: "gitlab.com", Host: "gitlab.com", Hash: "h5", DeviceID: "dev4", HasMFA: true, MFAType: "webauthn"},
+}
+
+// Run runs the driver tests, newDriver returns an initialized driver without any data for every test
+func Run(t *testing.T, newDriver func(t *testing.T) storage.Driver) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Driver)
+	}{
+		{"Enrollment", testEnrollment},
+		{"Nonces", testNonces},
+		{"Domains", testDomains},
+		{"Paging", testPaging},
+		{"DuplicatePasswords", testDuplicatePasswords},
+		{"DeviceDuplicatePasswords", testDeviceDuplicatePasswords},
+		{"DomainAggregates", testDomainAggregates},
+		{"EnrolledUsers", testEnrolledUsers},
+		{"Enforcement", testEnforcement},
+		{"DashboardStats", testDashboardStats},
+		{"Policies", testPolicies},
+		{"AppReviews", testAppReviews},
+		{"HIBP", testHIBP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newDriver(t))
+		})
+	}
+}
+
+// addLogins stores the login fixtures
+func addLogins(t *testing.T, store storage.Driver) {
+	t.Helper()
+
+	for _, login := range logins {
+		if err := store.AddLoginEvent(context.Background(), login); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+}
+
+// collect returns the items of every page of a query, following the cursors
+func collect[T any](t *testing.T, query models.Query, get func(models.Query) (models.Page[T], error)) []T {
+	t.Helper()
+
+	items := make([]T, 0)
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("paging does not end")
+		}
+
+		page, err := get(query)
+		if err != nil {
+			t.Fatalf("failed to get page: %v", err)
+		}
+
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func testEnrollment(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+
+	if valid, err := store.IsValidToken(ctx, Token); err != nil || !valid {
+		t.Fatalf("bootstrap token not valid: %v", err)
+	}
+	for _, wrong := range []string{"wrong", "", Token[:3], Token + "x"} {
+		if valid, _ := store.IsValidToken(ctx, wrong); valid {
+			t.Fatalf("wrong bootstrap token %q is valid", wrong)
+		}
+	}
+
+	steps := []struct {
+		name       string
+		action     func() error
+		enroll     string
+		wantErr    error
+		credential string
+		wantDevice bool
+	}{
+		{name: "enroll", enroll: "c1", credential: "c1", wantDevice: true},
+		{name: "enroll again", enroll: "c2", wantErr: models.ErrDeviceEnrolled, credential: "c1", wantDevice: true},
+		{name: "revoke", action: func() error { return store.RevokeDevice(ctx, "dev1") }, credential: "c1"},
+		{name: "enroll revoked", enroll: "c3", wantErr: models.ErrDeviceRevoked, credential: "c3"},
+		{name: "reset", action: func() error { return store.ResetDevice(ctx, "dev1") }, credential: "c1"},
+		{name: "enroll after reset", enroll: "c4", credential: "c4", wantDevice: true},
+	}
+
+	for _, step := range steps {
+		if step.action != nil {
+			if err := step.action(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+
+		if step.enroll != "" {
+			err := store.EnrollDevice(ctx, "dev1", step.enroll, "key-"+step.enroll)
+			if !errors.Is(err, step.wantErr) {
+				t.Fatalf("%s: got error %v, want %v", step.name, err, step.wantErr)
+			}
+		}
+
+		deviceID, found, err := store.GetDeviceForCredential(ctx, step.credential)
+		if err != nil {
+			t.Fatalf("%s: failed to get device: %v", step.name, err)
+		}
+		if found != step.wantDevice || (found && deviceID != "dev1") {
+			t.Fatalf("%s: credential %s resolves to %q, %v", step.name, step.credential, deviceID, found)
+		}
+	}
+
+	if key, err := store.GetDeviceSigningKey(ctx, "dev1"); err != nil || key != "key-c4" {
+		t.Fatalf("got signing key %q, %v", key, err)
+	}
+
+	if err := store.RevokeDevice(ctx, "dev1"); err != nil {
+		t.Fatalf("failed to revoke device: %v", err)
+	}
+	if key, err := store.GetDeviceSigningKey(ctx, "dev1"); err != nil || key != "" {
+		t.Fatalf("revoked device has signing key %q, %v", key, err)
+	}
+}
+
+func testNonces(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	now := time.Now()
+
+	steps := []struct {
+		nonce     string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"fresh", now.Add(time.Minute), true},
+		{"fresh", now.Add(time.Minute), false},
+		{"expired", now.Add(-time.Minute), true},
+		// an expired nonce can no longer be replayed, so it can be used again
+		{"expired", now.Add(time.Minute), true},
+		{"expired", now.Add(time.Minute), false},
+	}
+
+	for i, step := range steps {
+		fresh, err := store.UseNonce(ctx, step.nonce, step.expiresAt)
+		if err != nil {
+			t.Fatalf("step %d: failed to use nonce: %v", i, err)
+		}
+		if fresh != step.want {
+			t.Fatalf("step %d: nonce %s fresh %v, want %v", i, step.nonce, fresh, step.want)
+		}
+	}
+
+	if err := store.DeleteExpiredNonces(ctx, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("failed to delete expired nonces: %v", err)
+	}
+	if fresh, err := store.UseNonce(ctx, "fresh", now.Add(time.Minute)); err != nil || !fresh {
+		t.Fatalf("deleted nonce is not fresh: %v", err)
+	}
+}
+
+func testDomains(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	domains, err := store.GetDomainsForUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("failed to get domains for user: %v", err)
+	}
+	if want := []string{"atlassian.net", "github.com", "slack.com"}; !slices.Equal(domains, want) {
+		t.Errorf("got domains %v, want %v", domains, want)
+	}
+
+	withoutMFA := collect(t, models.Query{}, func(query models.Query) (models.Page[string], error) {
+		return store.GetUsersWithoutMFA(ctx, query)
+	})
+	if want := []string{"bob"}; !slices.Equal(withoutMFA, want) {
+		t.Errorf("got users without MFA %v, want %v", withoutMFA, want)
+	}
+}
+
+func testPaging(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	tests := []struct {
+		name  string
+		query models.Query
+		want  []string
+	}{
+		{
+			name:  "registrable domains",
+			query: models.Query{Limit: 1},
+			want:  []string{"atlassian.net", "github.com", "gitlab.com", "slack.com"},
+		},
+		{
+			name:  "registrable domains descending",
+			query: models.Query{Limit: 3, Descending: true},
+			want:  []string{"slack.com", "gitlab.com", "github.com", "atlassian.net"},
+		},
+		{
+			name:  "hosts",
+			query: models.Query{Limit: 2, Level: models.DomainLevelHost},
+			want:  []string{"acme.atlassian.net", "app.slack.com", "github.com", "gitlab.com"},
+		},
+		{
+			name:  "search",
+			query: models.Query{Limit: 1, Search: "GIT"},
+			want:  []string{"github.com", "gitlab.com"},
+		},
+		{
+			name:  "single page",
+			query: models.Query{Limit: 4},
+			want:  []string{"atlassian.net", "github.com", "gitlab.com", "slack.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains := collect(t, tt.query, func(query models.Query) (models.Page[string], error) {
+				return store.GetAllDomains(ctx, query)
+			})
+			if !slices.Equal(domains, tt.want) {
+				t.Errorf("got domains %v, want %v", domains, tt.want)
+			}
+		})
+	}
+
+	if _, err := store.GetAllDomains(ctx, models.Query{Cursor: "not a cursor"}); err == nil {
+		t.Error("invalid cursor accepted")
+	}
+}
+
+func testDuplicatePasswords(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	// a second password alice uses on the same domains, which only the unique key of the entry tells apart
+	for _, host := range []string{"slack.com", "github.com"} {
+		login := events.LoginEvent{Timestamp: base.Add(time.Hour), User: "alice", Domain: host, Host: host, Hash: "h6", DeviceID: "dev2"}
+		if err := store.AddLoginEvent(ctx, login); err != nil {
+			t.Fatalf("failed to add login event: %v", err)
+		}
+	}
+
+	entries := collect(t, models.Query{Limit: 1}, func(query models.Query) (models.Page[models.DuplicatePasswordEntry], error) {
+		return store.GetDuplicatePasswords(ctx, query)
+	})
+	if len(entries) != 2 {
+		t.Fatalf("got %d duplicate passwords, want 2: %v", len(entries), entries)
+	}
+	for _, entry := range entries {
+		if entry.User != "alice" || !slices.Equal(entry.Domains, []string{"github.com", "slack.com"}) {
+			t.Errorf("unexpected duplicate password %v", entry)
+		}
+	}
+
+	tests := []struct {
+		name string
+		user string
+		hash string
+		want int
+	}{
+		// only current passwords count, h6 replaced h1 on both domains
+		{"every password of the user", "alice", "", 1},
+		{"current password", "alice", "h6", 1},
+		{"replaced password", "alice", "h1", 0},
+		{"password used once", "alice", "h2", 0},
+		{"other user", "bob", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := store.GetDuplicatePasswordsForUser(ctx, tt.user, tt.hash, models.DomainLevelRegistrable)
+			if err != nil {
+				t.Fatalf("failed to get duplicate passwords: %v", err)
+			}
+			if len(groups) != tt.want {
+				t.Errorf("got %d groups, want %d: %v", len(groups), tt.want, groups)
+			}
+		})
+	}
+}
+
+func testDeviceDuplicatePasswords(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	// usernames are not authenticated, a device submitting a login as alice must not see her other devices
+	forged := events.LoginEvent{Timestamp: base.Add(time.Hour), User: "alice", Domain: "gitlab.com", Host: "gitlab.com", Hash: "h4", DeviceID: "dev3"}
+	if err := store.AddLoginEvent(ctx, forged); err != nil {
+		t.Fatalf("failed to add login event: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		deviceID string
+		hash     string
+		level    models.DomainLevel
+		want     [][]string
+	}{
+		{"every password of the device", "dev1", "", models.DomainLevelRegistrable, [][]string{{"github.com", "slack.com"}}},
+		{"reused password", "dev1", "h1", models.DomainLevelRegistrable, [][]string{{"github.com", "slack.com"}}},
+		{"password of another device", "dev1", "h2", models.DomainLevelRegistrable, [][]string{}},
+		{"hosts", "dev1", "", models.DomainLevelHost, [][]string{{"app.slack.com", "github.com"}}},
+		{"only the own logins of the device", "dev3", "", models.DomainLevelRegistrable, [][]string{{"gitlab.com", "slack.com"}}},
+		{"device without logins", "dev9", "", models.DomainLevelRegistrable, [][]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := store.GetDuplicatePasswordsForDevice(ctx, tt.deviceID, tt.hash, tt.level)
+			if err != nil {
+				t.Fatalf("failed to get duplicate passwords: %v", err)
+			}
+			if !slices.EqualFunc(groups, tt.want, slices.Equal[[]string]) {
+				t.Errorf("got groups %v, want %v", groups, tt.want)
+			}
+		})
+	}
+}
+
+func testDomainAggregates(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	summary, found, err := store.GetDomainSummary(ctx, "slack.com", models.DomainLevelRegistrable)
+	if err != nil || !found {
+		t.Fatalf("failed to get domain summary: %v", err)
+	}
+	if summary.Users != 2 || summary.Logins != 2 || summary.Devices != 2 || summary.UsersWithMFA != 1 || summary.ReusingUsers != 1 {
+		t.Errorf("unexpected domain summary %+v", summary)
+	}
+
+	if _, found, _ := store.GetDomainSummary(ctx, "unknown.com", models.DomainLevelRegistrable); found {
+		t.Error("summary found for unknown domain")
+	}
+
+	users := collect(t, models.Query{Limit: 1, Descending: true}, func(query models.Query) (models.Page[models.DomainUser], error) {
+		return store.GetDomainUsers(ctx, "slack.com", models.DomainLevelRegistrable, query)
+	})
+	if len(users) != 2 || users[0].Username != "bob" || users[1].Username != "alice" {
+		t.Errorf("unexpected domain users %+v", users)
+	}
+
+	// most recently used first
+	devices := collect(t, models.Query{Limit: 1}, func(query models.Query) (models.Page[models.DomainDevice], error) {
+		return store.GetDomainDevices(ctx, "app.slack.com", models.DomainLevelHost, query)
+	})
+	if len(devices) != 2 || devices[0].DeviceID != "dev3" || devices[1].DeviceID != "dev1" {
+		t.Errorf("unexpected domain devices %+v", devices)
+	}
+}
+
+func testEnrolledUsers(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	if err := store.EnrollDevice(ctx, "dev1", "c1", "k1"); err != nil {
+		t.Fatalf("failed to enroll device: %v", err)
+	}
+	if err := store.EnrollDevice(ctx, "dev3", "c3", "k3"); err != nil {
+		t.Fatalf("failed to enroll device: %v", err)
+	}
+	if err := store.RevokeDevice(ctx, "dev3"); err != nil {
+		t.Fatalf("failed to revoke device: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query models.Query
+		want  []string
+	}{
+		{"username", models.Query{Limit: 3}, []string{"dev1", "dev2", "dev3", "dev4"}},
+		{"username descending", models.Query{Limit: 1, Descending: true}, []string{"dev4", "dev3", "dev2", "dev1"}},
+		{"last seen", models.Query{Limit: 2, Sort: models.SortLastSeen, Descending: true}, []string{"dev4", "dev3", "dev2", "dev1"}},
+		{"search", models.Query{Limit: 1, Search: "alice"}, []string{"dev1", "dev2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := collect(t, tt.query, func(query models.Query) (models.Page[models.EnrolledUser], error) {
+				return store.GetEnrolledUsers(ctx, query)
+			})
+
+			devices := make([]string, 0, len(users))
+			for _, user := range users {
+				devices = append(devices, user.ID)
+			}
+			if !slices.Equal(devices, tt.want) {
+				t.Errorf("got devices %v, want %v", devices, tt.want)
+			}
+
+			for _, user := range users {
+				if user.Enrolled != (user.ID == "dev1") || user.Revoked != (user.ID == "dev3") {
+					t.Errorf("device %s enrolled %v, revoked %v", user.ID, user.Enrolled, user.Revoked)
+				}
+			}
+		})
+	}
+}
+
+func testEnforcement(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	decisions := []events.EnforcementEvent{
+		{Timestamp: base.Add(10 * time.Minute), User: "alice", Domain: "github.com", Host: "github.com", App: "GitHub", DeviceID: "dev1", Action: models.EnforcementWarn},
+		{Timestamp: base.Add(11 * time.Minute), User: "bob", Domain: "slack.com", Host: "app.slack.com", App: "Slack", DeviceID: "dev3", Action: models.EnforcementBlock},
+		{Timestamp: base.Add(12 * time.Minute), User: "alice", Domain: "gitlab.com", Host: "gitlab.com", App: "GitLab", DeviceID: "dev1", Action: models.EnforcementAllow},
+	}
+	for _, decision := range decisions {
+		if err := store.AddEnforcementEvent(ctx, decision); err != nil {
+			t.Fatalf("failed to add enforcement event: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		action models.EnforcementAction
+		search string
+		want   []string
+	}{
+		{"every action, most recent first", "", "", []string{"GitLab", "Slack", "GitHub"}},
+		{"blocked", models.EnforcementBlock, "", []string{"Slack"}},
+		{"search", "", "alice", []string{"GitLab", "GitHub"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(t, models.Query{Limit: 1, Search: tt.search}, func(query models.Query) (models.Page[events.EnforcementEvent], error) {
+				return store.GetEnforcementEvents(ctx, query, tt.action)
+			})
+
+			apps := make([]string, 0, len(got))
+			for _, event := range got {
+				apps = append(apps, event.App)
+			}
+			if !slices.Equal(apps, tt.want) {
+				t.Errorf("got apps %v, want %v", apps, tt.want)
+			}
+		})
+	}
+
+	// the history of a user holds their logins and warnings, but not the logins that were allowed
+	userEvents := collect(t, models.Query{Limit: 1}, func(query models.Query) (models.Page[models.UserEvent], error) {
+		return store.GetUserEvents(ctx, "alice", query)
+	})
+
+	types := make([]models.UserEventType, 0, len(userEvents))
+	for _, event := range userEvents {
+		types = append(types, event.Type)
+	}
+	want := []models.UserEventType{models.UserEventLogin, models.UserEventLogin, models.UserEventLogin, models.UserEventType(models.EnforcementWarn)}
+	if !slices.Equal(types, want) {
+		t.Errorf("got user events %v, want %v", types, want)
+	}
+}
+
+func testDashboardStats(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+	addLogins(t, store)
+
+	if err := store.StoreHIBPResult(ctx, "h3", 12); err != nil {
+		t.Fatalf("failed to store HIBP result: %v", err)
+	}
+	if err := store.StoreHIBPResult(ctx, "h4", 0); err != nil {
+		t.Fatalf("failed to store HIBP result: %v", err)
+	}
+	block := events.EnforcementEvent{Timestamp: base, User: "bob", Domain: "slack.com", Host: "app.slack.com", App: "Slack", Action: models.EnforcementBlock}
+	if err := store.AddEnforcementEvent(ctx, block); err != nil {
+		t.Fatalf("failed to add enforcement event: %v", err)
+	}
+
+	stats, err := store.GetDashboardStats(ctx)
+	if err != nil {
+		t.Fatalf("failed to get dashboard stats: %v", err)
+	}
+
+	want := models.DashboardStats{
+		TotalUsers:           3,
+		TotalDomains:         4,
+		DuplicatePasswords:   1,
+		CompromisedPasswords: 1,
+		UsersWithoutMFA:      1,
+		BlockedLogins:        1,
+	}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
+
+func testPolicies(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+
+	if _, found, err := store.GetPolicy(ctx); err != nil || found {
+		t.Fatalf("policy found before storing one: %v", err)
+	}
+
+	for want, blocked := range [][]string{{"dropbox.com"}, {"dropbox.com", "wetransfer.com"}} {
+		version, err := store.StorePolicy(ctx, models.Policy{BlockedDomains: blocked, UpdatedBy: "admin", UpdatedAt: base})
+		if err != nil {
+			t.Fatalf("failed to store policy: %v", err)
+		}
+		if version != want+1 {
+			t.Fatalf("policy stored as version %d, want %d", version, want+1)
+		}
+	}
+
+	policy, found, err := store.GetPolicy(ctx)
+	if err != nil || !found {
+		t.Fatalf("failed to get policy: %v", err)
+	}
+	if policy.Version != 2 || !slices.Equal(policy.BlockedDomains, []string{"dropbox.com", "wetransfer.com"}) {
+		t.Errorf("got policy %+v, want the latest version", policy)
+	}
+}
+
+func testAppReviews(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+
+	reviews := []models.AppReview{
+		{App: "Slack", Status: models.AppStatusUnderReview, UpdatedBy: "admin", UpdatedAt: base},
+		{App: "Slack", Status: models.AppStatusProhibited, Owner: "security", UpdatedBy: "admin", UpdatedAt: base.Add(time.Hour)},
+		{App: "GitHub", Status: models.AppStatusSanctioned, UpdatedBy: "admin", UpdatedAt: base},
+	}
+	for _, review := range reviews {
+		if err := store.SetAppReview(ctx, review); err != nil {
+			t.Fatalf("failed to set app review: %v", err)
+		}
+	}
+
+	stored, err := store.GetAppReviews(ctx)
+	if err != nil {
+		t.Fatalf("failed to get app reviews: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("got %d reviews, want 2", len(stored))
+	}
+	if review := stored["Slack"]; review.Status != models.AppStatusProhibited || review.Owner != "security" {
+		t.Errorf("review not replaced: %+v", review)
+	}
+}
+
+func testHIBP(t *testing.T, store storage.Driver) {
+	ctx := context.Background()
+
+	if err := store.StoreHIBPResult(ctx, "h1", 3); err != nil {
+		t.Fatalf("failed to store HIBP result: %v", err)
+	}
+	if result, found, err := store.GetHIBPResult(ctx, "h1"); err != nil || !found || result.BreachCount != 3 {
+		t.Errorf("got HIBP result %+v, %v, %v", result, found, err)
+	}
+	if _, found, _ := store.GetHIBPResult(ctx, "h2"); found {
+		t.Error("HIBP result found for unchecked hash")
+	}
+
+	verdicts := []models.HIBPVerdict{
+		{DeviceID: "dev1", Domain: "github.com", Username: "alice", BreachCount: 3, CheckedAt: base},
+		{DeviceID: "dev1", Domain: "slack.com", Username: "alice", BreachCount: 3, CheckedAt: base},
+		{DeviceID: "dev2", Domain: "gitlab.com", Username: "alice", BreachCount: 1, CheckedAt: base},
+	}
+	for _, verdict := range verdicts {
+		if err := store.AddHIBPVerdict(ctx, verdict); err != nil {
+			t.Fatalf("failed to add HIBP verdict: %v", err)
+		}
+	}
+
+	for i, want := range []int{2, 0} {
+		taken, err := store.TakeHIBPVerdicts(ctx, "dev1")
+		if err != nil {
+			t.Fatalf("failed to take HIBP verdicts: %v", err)
+		}
+		if len(taken) != want {
+			t.Errorf("take %d returned %d verdicts, want %d", i+1, len(taken), want)
+		}
+	}
+
+	if err := store.StoreHIBPRange(ctx, "ABCDE", "0018A45C4D1DEF81644B54AB7F969B88D65:1"); err != nil {
+		t.Fatalf("failed to store HIBP range: %v", err)
+	}
+	if cached, found, err := store.GetHIBPRange(ctx, "ABCDE"); err != nil || !found || cached.Body == "" {
+		t.Fatalf("got HIBP range %+v, %v, %v", cached, found, err)
+	}
+
+	removed, err := store.PruneHIBPRanges(ctx, time.Now().Add(time.Minute), 10)
+	if err != nil || removed != 1 {
+		t.Fatalf("pruned %d ranges, want 1: %v", removed, err)
+	}
+	if _, found, _ := store.GetHIBPRange(ctx, "ABCDE"); found {
+		t.Error("pruned HIBP range still found")
+	}
+}
@@ -0,0 +1,179 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the certificate files are checked for changes at most
+	reloadCheckInterval = 10 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion converts a version such as "1.2" to its crypto/tls constant, defaulting to TLS 1.2
+func ParseVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	tlsVersion, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
+	}
+
+	return tlsVersion, nil
+}
+
+// Reloader serves a certificate and picks up changes to the certificate files without a restart
+type Reloader struct {
+	logger   *logrus.Logger
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	lastCheck   time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they are not a valid pair
+func NewReloader(logger *logrus.Logger, certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.mutex.Lock()
+	r.certificate = &certificate
+	r.modTime = modTime
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// latestModTime returns the most recent modification of either the certificate or key file
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// maybeReload reloads the certificate if the files changed since the last load.
+// Failures keep the current certificate so a half-written renewal does not take the server down.
+func (r *Reloader) maybeReload() {
+	r.mutex.Lock()
+	if time.Since(r.lastCheck) < reloadCheckInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+	loadedModTime := r.modTime
+	r.mutex.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		r.logger.WithError(err).Warn("failed to check certificate for changes")
+		return
+	}
+
+	if !modTime.After(loadedModTime) {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		r.logger.WithError(err).Error("failed to reload certificate, keeping the current one")
+		return
+	}
+
+	r.logger.WithField("certificate", r.certFile).Info("reloaded TLS certificate")
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.maybeReload()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.certificate, nil
+}
+
+// NewServerConfig returns the TLS configuration of the HTTP server.
+// When clientCAFile is set, client certificates signed by it are requested and verified,
+// it is up to the handlers to require one where needed.
+func NewServerConfig(logger *logrus.Logger, certFile, keyFile, minVersion, clientCAFile string) (*tls.Config, error) {
+	tlsVersion, err := ParseVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	reloader, err := NewReloader(logger, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersion,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		caBytes, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", clientCAFile)
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		// the dashboard is used by browsers without a client certificate, so only verify when one is given
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
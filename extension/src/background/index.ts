@@ -2,8 +2,19 @@
  * Background script for the extension
  */
 
-import { LoginData, MessageType } from '../shared/types';
+import { Enforcement, LoginData, MessageType, Policy } from '../shared/types';
 import { loadConfig, sendToBackend } from '../shared/utils';
+import {
+  POLICY_REFRESH_MINUTES,
+  isBlockedDomain,
+  loadPolicy,
+  matchesPolicyDomain,
+  policyMessage,
+  refreshPolicy,
+} from '../shared/policy';
+
+// version of the payload sent to /api/creds/register
+const CREDENTIAL_PAYLOAD_VERSION = 2;
 
 async function sha(mode: string, input: string): Promise<string> {
   const encoder = new TextEncoder();
@@ -14,6 +25,172 @@ async function sha(mode: string, input: string): Promise<string> {
 }
 
 
+const POLICY_ALARM = 'policy-refresh';
+
+// seconds after a submission to ask the backend for the verdict of its background breach check
+const VERDICT_POLL_DELAYS = [5, 20];
+
+interface Verdict {
+  domain: string;
+  username: string;
+  breach_count: number;
+  checked_at: string;
+}
+
+/**
+ * Warn the user that a password was found in a breach
+ */
+const notifyBreach = (domain: string, breachCount: number, policy: Policy | undefined): void => {
+  console.log(`Password for ${domain} was found in HIBP database (${breachCount} breaches)`);
+
+  try {
+    chrome.notifications.create({
+      type: "basic",
+      iconUrl: chrome.runtime.getURL('icons/icon48.svg'),
+      title: "Password Security Warning!",
+      message: policyMessage(policy?.messages.breach, domain,
+        `Your password for ${domain} has been found in ${breachCount} data breach(es). Consider changing it immediately.`),
+      priority: 2,
+    });
+  } catch (notificationError) {
+    console.error('Failed to create HIBP notification:', notificationError);
+  }
+};
+
+/**
+ * Show the verdicts of background breach checks, the backend only returns each of them once
+ */
+const showVerdicts = (verdicts: Verdict[] | undefined, policy: Policy | undefined): void => {
+  if (!Array.isArray(verdicts)) {
+    return;
+  }
+
+  for (const verdict of verdicts) {
+    notifyBreach(verdict.domain, verdict.breach_count, policy);
+  }
+};
+
+/**
+ * Ask the backend for verdicts a few times, verdicts not picked up here arrive with the next submission
+ */
+const scheduleVerdictPolls = (apiUrl: string, policy: Policy | undefined): void => {
+  for (const delay of VERDICT_POLL_DELAYS) {
+    setTimeout(async () => {
+      try {
+        const response = await sendToBackend('/api/creds/verdicts', {}, apiUrl);
+        if (!response.ok) {
+          return;
+        }
+
+        const responseData = await response.json();
+        showVerdicts(responseData.verdicts, policy);
+      } catch (error) {
+        console.error('Failed to retrieve breach verdicts:', error);
+      }
+    }, delay * 1000);
+  }
+};
+
+/**
+ * Warn the user when the password just used is also used on other domains
+ */
+const warnPasswordReuse = async (
+  domain: string,
+  hashedPassword: string,
+  apiUrl: string,
+  policy: Policy | undefined,
+): Promise<void> => {
+  try {
+    const response = await sendToBackend('/api/password/domaincheck', {
+      domain: domain,
+      hash: hashedPassword,
+    }, apiUrl);
+    if (!response.ok) {
+      return;
+    }
+
+    const responseData = await response.json();
+    const otherDomains: string[] = responseData.also_used_on || [];
+    if (otherDomains.length === 0) {
+      return;
+    }
+
+    chrome.notifications.create({
+      type: "basic",
+      iconUrl: chrome.runtime.getURL('icons/icon48.svg'),
+      title: "Password Reuse Warning",
+      message: policyMessage(policy?.messages.reuse, domain,
+        `The password you used on ${domain} is also used on ${otherDomains.join(', ')}. Use a unique password for every account.`),
+      priority: 1,
+    });
+  } catch (error) {
+    console.error('Failed to check password reuse:', error);
+  }
+};
+
+/**
+ * Send a tab to the page explaining why its app is blocked
+ */
+const showBlockedPage = async (tabId: number, app: string, message: string): Promise<void> => {
+  const params = new URLSearchParams({ app: app, message: message });
+  try {
+    await chrome.tabs.update(tabId, { url: chrome.runtime.getURL('blocked.html') + '?' + params.toString() });
+  } catch (error) {
+    console.error('Failed to leave blocked app:', error);
+  }
+};
+
+/**
+ * Leave a domain blocked by the cached policy, before a login on it reaches the backend or the app
+ */
+const leaveBlockedDomain = async (tabId: number, url: string, policy: Policy | undefined): Promise<void> => {
+  let host = url;
+  try {
+    host = new URL(url).hostname;
+  } catch (e) {
+    // not a URL, show as is
+  }
+
+  const message = policyMessage(policy?.messages.blocked, host, `Logging in to ${host} is blocked by your organization.`)
+    .split('{app}').join(host);
+
+  await showBlockedPage(tabId, host, message);
+};
+
+/**
+ * Act on the enforcement verdict of the backend: warnings are shown,
+ * blocked logins are shown and the tab is sent to a page explaining the block.
+ * Domains blocked by the policy never get here, those are left before the login is submitted.
+ */
+const applyEnforcement = async (enforcement: Enforcement | undefined, domain: string, tabId: number | undefined): Promise<void> => {
+  if (!enforcement || enforcement.action === 'allow') {
+    return;
+  }
+
+  const blocked = enforcement.action === 'block';
+  const app = enforcement.app || domain;
+  const message = enforcement.message || `${app} is not allowed by your organization.`;
+
+  try {
+    chrome.notifications.create({
+      type: "basic",
+      iconUrl: chrome.runtime.getURL('icons/icon48.svg'),
+      title: blocked ? "Login Blocked" : "Prohibited Application",
+      message: message,
+      priority: 2,
+      requireInteraction: blocked,
+    });
+  } catch (notificationError) {
+    console.error('Failed to create enforcement notification:', notificationError);
+  }
+
+  if (!blocked || tabId === undefined) {
+    return;
+  }
+
+  await showBlockedPage(tabId, app, message);
+};
+
 /**
  * Handle login detection
  */
@@ -22,6 +199,8 @@ const handleLoginDetected = async (
   apiUrl: string,
   deviceId: string,
   filters: string[],
+  policy: Policy | undefined,
+  tabId: number | undefined,
 ): Promise<void> => {
   try {
     // Complete the login data
@@ -33,6 +212,15 @@ const handleLoginDetected = async (
       capturedTime: new Date().toISOString(),
     };
 
+    if (policy && matchesPolicyDomain(loginData.domain, policy.ignored_domains)) {
+      return;
+    }
+
+    // username filters of the policy take precedence over the ones configured locally
+    if (policy && policy.username_filters.length > 0) {
+      filters = policy.username_filters;
+    }
+
     if (Array.isArray(filters) && filters.length > 0) {
       let found = false;
 
@@ -57,14 +245,22 @@ const handleLoginDetected = async (
       return;
     }
 
-    // calculate the hash of this
-    let hashedPassword = await sha('SHA-512', loginData.password);
+    // the SHA-512 is used to detect password reuse, the SHA-1 only for breach lookups
+    const hashedPassword = await sha('SHA-512', loginData.password);
+    const breachHash = (await sha('SHA-1', loginData.password)).toUpperCase();
 
     // send to backend
     const response = await sendToBackend('/api/creds/register', {
+      version: CREDENTIAL_PAYLOAD_VERSION,
       domain: loginData.domain,
       username: loginData.username,
       hash: hashedPassword,
+      hash_algorithm: 'sha512',
+      breach_hash: {
+        algorithm: 'sha1',
+        prefix: breachHash.substring(0, 5),
+        suffix: breachHash.substring(5),
+      },
       device_id: loginData.deviceId,
       captured_time: loginData.capturedTime,
     }, apiUrl);
@@ -78,32 +274,43 @@ const handleLoginDetected = async (
     // Process the response for HIBP information
     try {
       const responseData = await response.json();
-      
+
       if (responseData.hibp && responseData.hibp.checked && responseData.hibp.breached) {
-        // Password was found in HIBP database - show warning
-        console.log(`Password for ${loginData.domain} was found in HIBP database (${responseData.hibp.breach_count} breaches)`);
-        
-        // Show notification to user
-        try {
-          chrome.notifications.create({
-            type: "basic",
-            iconUrl: chrome.runtime.getURL('icons/icon48.svg'),
-            title: "Password Security Warning!",
-            message: `Your password for ${loginData.domain} has been found in ${responseData.hibp.breach_count} data breach(es). Consider changing it immediately.`,
-            priority: 2,
-          });
-        } catch (notificationError) {
-          console.error('Failed to create HIBP notification:', notificationError);
-        }
+        notifyBreach(loginData.domain, responseData.hibp.breach_count, policy);
+      } else if (responseData.hibp && responseData.hibp.pending) {
+        // the backend checks the password in the background
+        scheduleVerdictPolls(apiUrl, policy);
       }
+
+      showVerdicts(responseData.verdicts, policy);
+
+      await applyEnforcement(responseData.enforcement, loginData.domain, tabId);
     } catch (parseError) {
       console.error('Failed to parse backend response:', parseError);
     }
+
+    await warnPasswordReuse(loginData.domain, hashedPassword, apiUrl, policy);
   } catch (error) {
     console.error('Error handling login detection:', error);
   }
 };
 
+/**
+ * Check the backend for a new policy version, the cached policy stays in effect on failure
+ */
+const updatePolicy = async (): Promise<void> => {
+  try {
+    const config = await loadConfig();
+    if (!config.enabled || !config.api) {
+      return;
+    }
+
+    await refreshPolicy(config.api);
+  } catch (error) {
+    console.error('Failed to refresh policy:', error);
+  }
+};
+
 /**
  * Initialize the background script
  */
@@ -111,6 +318,32 @@ const initialize = async (): Promise<void> => {
   // Load or initialize configuration
   await loadConfig();
 
+  // alarms survive the service worker being suspended, unlike timers
+  chrome.alarms.create(POLICY_ALARM, { periodInMinutes: POLICY_REFRESH_MINUTES });
+  chrome.alarms.onAlarm.addListener((alarm) => {
+    if (alarm.name === POLICY_ALARM) {
+      updatePolicy();
+    }
+  });
+  updatePolicy();
+
+  // leave blocked domains before their login page loads, the content script guards logins that still get there
+  chrome.webNavigation.onBeforeNavigate.addListener(async (details) => {
+    if (details.frameId !== 0) {
+      return;
+    }
+
+    const config = await loadConfig();
+    if (!config.enabled) {
+      return;
+    }
+
+    const policy = await loadPolicy(config.api);
+    if (isBlockedDomain(details.url, policy)) {
+      await leaveBlockedDomain(details.tabId, details.url, policy);
+    }
+  });
+
   // Set up message listener using the recommended pattern for Manifest V3
   // This approach properly handles asynchronous responses in service workers
   chrome.runtime.onMessage.addListener((message, sender, sendResponse) => {
@@ -126,13 +359,27 @@ const initialize = async (): Promise<void> => {
         }
 
         switch (message.type) {
-          case MessageType.LOGIN_DETECTED:
-            await handleLoginDetected(message.data, config.api, config.id, config.filters);
+          case MessageType.LOGIN_DETECTED: {
+            const policy = await loadPolicy(config.api);
+            if (policy && !policy.enabled) {
+              return { success: false, error: 'Disabled by policy' };
+            }
+
+            await handleLoginDetected(message.data, config.api, config.id, config.filters, policy, sender.tab?.id);
             return { success: true };
+          }
 
           case MessageType.GET_DEVICE_ID:
             return { deviceId: config.id };
 
+          case MessageType.BLOCKED_PAGE: {
+            const policy = await loadPolicy(config.api);
+            if (sender.tab?.id !== undefined && sender.tab.url && isBlockedDomain(sender.tab.url, policy)) {
+              await leaveBlockedDomain(sender.tab.id, sender.tab.url, policy);
+            }
+            return { success: true };
+          }
+
           default:
             console.warn('Unknown message type:', message.type);
             return { success: false, error: 'Unknown message type' };
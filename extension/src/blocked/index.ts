@@ -0,0 +1,24 @@
+/**
+ * Page shown instead of an app the backend blocked
+ */
+
+const initialize = (): void => {
+  const params = new URLSearchParams(window.location.search);
+
+  const app = document.getElementById('blocked-app');
+  const message = document.getElementById('blocked-message');
+  if (!app || !message) {
+    console.error('Some DOM elements were not found');
+    return;
+  }
+
+  // textContent keeps the message from injecting markup
+  app.textContent = params.get('app') || 'This application';
+  message.textContent = params.get('message') || 'Logging in to this application is blocked by your organization.';
+};
+
+if (document.readyState === 'loading') {
+  document.addEventListener('DOMContentLoaded', initialize);
+} else {
+  initialize();
+}
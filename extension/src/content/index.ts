@@ -2,8 +2,9 @@
  * Content script for detecting login events
  */
 
-import { LoginData, Message, MessageType } from '../shared/types';
-import { getDomain } from '../shared/utils';
+import { LoginData, Message, MessageType, Policy } from '../shared/types';
+import { getDomain, loadConfig } from '../shared/utils';
+import { isBlockedDomain, loadPolicy } from '../shared/policy';
 
 // Store form data temporarily
 let formData: { [key: string]: string } = {};
@@ -13,6 +14,10 @@ let mfaFields: HTMLInputElement[] = [];
 let hasMFADetected = false;
 let detectedMFAType = '';
 
+// cached policy, used to stop logins on blocked domains before the credentials are sent
+let policy: Policy | undefined;
+let extensionEnabled = true;
+
 /**
  * Determine if an input field is likely a username field
  */
@@ -656,12 +661,69 @@ const hookHistoryChanges = (): void => {
   window.addEventListener('hashchange', () => setTimeout(detectFederatedFromLocation, 0));
 };
 
+/**
+ * Whether logging in on this page is blocked by the cached policy
+ */
+const isBlockedPage = (): boolean => {
+  return extensionEnabled && isBlockedDomain(window.location.href, policy);
+};
+
+/**
+ * Stop a login on a blocked page before the page sees it, and have the background leave the page
+ */
+const guardBlockedLogin = (event: Event): void => {
+  if (!isBlockedPage()) {
+    return;
+  }
+
+  if (event instanceof KeyboardEvent && event.key !== 'Enter') {
+    return;
+  }
+
+  if (event.type === 'click') {
+    const target = event.target as Element | null;
+    if (!target || !target.closest('button, input[type="submit"], input[type="button"], input[type="image"]')) {
+      return;
+    }
+  }
+
+  event.preventDefault();
+  event.stopImmediatePropagation();
+
+  chrome.runtime.sendMessage({ type: MessageType.BLOCKED_PAGE });
+};
+
+/**
+ * Load the cached policy, and keep it current when the background fetches a new version
+ */
+const loadBlockedDomains = async (): Promise<void> => {
+  const config = await loadConfig();
+  extensionEnabled = config.enabled;
+  policy = await loadPolicy(config.api);
+
+  if (isBlockedPage()) {
+    chrome.runtime.sendMessage({ type: MessageType.BLOCKED_PAGE });
+  }
+};
+
 /**
  * Initialize the content script
  */
 const initialize = (): void => {
   //console.log('Content script initialized for:', window.location.href);
 
+  // capture listeners on the window run before any listener of the page, so a blocked login never gets sent
+  window.addEventListener('submit', guardBlockedLogin, true);
+  window.addEventListener('click', guardBlockedLogin, true);
+  window.addEventListener('keydown', guardBlockedLogin, true);
+
+  loadBlockedDomains();
+  chrome.storage.onChanged.addListener((changes, area) => {
+    if (area === 'local' && (changes.policy || changes.config)) {
+      loadBlockedDomains();
+    }
+  });
+
   // Hook navigation changes to detect OAuth/OIDC hash or query params
   hookHistoryChanges();
   // Immediate check for federated indicators on current URL
@@ -3,7 +3,8 @@
  */
 
 import { ExtensionConfig } from '../shared/types';
-import { loadConfig, saveConfig } from '../shared/utils';
+import { clearCredential, getCredential, getFromBackend, loadConfig, saveConfig, signRequest } from '../shared/utils';
+import { loadPolicy } from '../shared/policy';
 
 // DOM elements - will be initialized when DOM is ready
 let enabledToggle: HTMLInputElement;
@@ -16,6 +17,9 @@ let testApiButton: HTMLButtonElement;
 let apiTestResult: HTMLDivElement;
 let statusText: HTMLSpanElement;
 let versionText: HTMLSpanElement;
+let policyText: HTMLSpanElement;
+let compromisedSection: HTMLDivElement;
+let compromisedList: HTMLUListElement;
 let isLocked: boolean;
 
 /**
@@ -52,6 +56,14 @@ const loadAndDisplayConfig = async (): Promise<void> => {
     // Get extension version
     const manifest = chrome.runtime.getManifest();
     versionText.textContent = manifest.version;
+
+    // the policy managed on the backend, as last fetched by the background script
+    const policy = await loadPolicy(config.api);
+    if (policy && policy.version > 0) {
+      policyText.textContent = `v${policy.version}` + (policy.enabled ? '' : ' (capture disabled)');
+    } else {
+      policyText.textContent = 'None';
+    }
   } catch (error) {
     console.error('Error loading configuration:', error);
   }
@@ -103,6 +115,11 @@ const saveConfiguration = async (): Promise<void> => {
       // Save the updated config
       await saveConfig(updatedConfig);
 
+      // a different backend or bootstrap token requires enrolling again
+      if (updatedConfig.api !== currentConfig.api || updatedConfig.token !== currentConfig.token) {
+        await clearCredential();
+      }
+
       // Update status
       statusText.textContent = updatedConfig.enabled ? 'Active' : 'Disabled';
       statusText.style.color = updatedConfig.enabled ? '#4CAF50' : '#F44336';
@@ -147,10 +164,12 @@ const testApiEndpoint = async (): Promise<void> => {
     const controller = new AbortController();
     const timeoutId = setTimeout(() => controller.abort(), 5000);
 
-    const headers = new Headers();
-    if (token != "") {
-      headers.set('Authorization', 'Bearer ' + token);
-    }
+    // enroll with the entered bootstrap token if this device has no credential yet
+    const config = await loadConfig();
+    const credential = await getCredential({ ...config, api: apiUrl, token: token });
+
+    const headers = new Headers(await signRequest(credential, 'GET', healthEndpoint, ''));
+    headers.set('Authorization', 'Bearer ' + credential.token);
 
     const response = await fetch(healthEndpoint, { 
       method: 'GET',
@@ -189,6 +208,37 @@ const showTestResult = (message: string, success: boolean): void => {
   }, 5000);
 };
 
+/**
+ * Show the accounts that use a password found in a breach, so the user knows which ones to fix
+ */
+const loadCompromisedAccounts = async (): Promise<void> => {
+  try {
+    const config = await loadConfig();
+    if (!config.enabled || !config.api) {
+      return;
+    }
+
+    const response = await getFromBackend('/api/password/compromised', config.api);
+    if (!response.ok) {
+      return;
+    }
+
+    const responseData = await response.json();
+    const accounts: { domain: string; username: string; breach_count: number }[] = responseData.accounts || [];
+
+    compromisedList.replaceChildren();
+    for (const account of accounts) {
+      const item = document.createElement('li');
+      item.textContent = `${account.domain} (${account.username}): seen in ${account.breach_count} breach(es)`;
+      compromisedList.appendChild(item);
+    }
+
+    compromisedSection.classList.toggle('hidden', accounts.length === 0);
+  } catch (error) {
+    console.error('Error loading compromised accounts:', error);
+  }
+};
+
 /**
  * Initialize the popup
  */
@@ -204,16 +254,21 @@ const initialize = (): void => {
   apiTestResult = document.getElementById('api-test-result') as HTMLDivElement;
   statusText = document.getElementById('status-text') as HTMLSpanElement;
   versionText = document.getElementById('version-text') as HTMLSpanElement;
+  policyText = document.getElementById('policy-text') as HTMLSpanElement;
+  compromisedSection = document.getElementById('compromised-section') as HTMLDivElement;
+  compromisedList = document.getElementById('compromised-list') as HTMLUListElement;
 
   // Check if all elements were found
   if (!enabledToggle || !enabledToggler || !apiUrlInput || !tokenInput || !deviceIdInput || 
-      !saveButton || !testApiButton || !apiTestResult || !statusText || !versionText) {
+      !saveButton || !testApiButton || !apiTestResult || !statusText || !versionText || !policyText ||
+      !compromisedSection || !compromisedList) {
     console.error('Some DOM elements were not found');
     return;
   }
 
   // Load and display configuration
   loadAndDisplayConfig();
+  loadCompromisedAccounts();
 
   // Set up event listeners
   console.log(saveButton);
@@ -0,0 +1,173 @@
+/**
+ * Policy distributed by the backend, cached so it also applies while the backend is unreachable
+ */
+
+import { Policy } from './types';
+import { getCredential, handleUnauthorized, loadConfig, signRequest, verifyResponse } from './utils';
+
+// minutes between checks for a new policy version
+export const POLICY_REFRESH_MINUTES = 15;
+
+interface CachedPolicy {
+  // backend the policy was received from, a policy of another backend is not applied
+  api: string;
+  policy: Policy;
+}
+
+/**
+ * Load the cached policy of a backend
+ */
+export const loadPolicy = async (apiUrl: string): Promise<Policy | undefined> => {
+  return new Promise((resolve) => {
+    chrome.storage.local.get('policy', (result) => {
+      const cached: CachedPolicy | undefined = result.policy;
+      resolve(cached && cached.api === apiUrl ? cached.policy : undefined);
+    });
+  });
+};
+
+/**
+ * Load the policy signing key pinned in the managed configuration, which users cannot change
+ */
+const loadPolicyKey = async (): Promise<string | undefined> => {
+  return new Promise((resolve) => {
+    chrome.storage.managed.get('policy_key', (result) => {
+      if (chrome.runtime.lastError) {
+        resolve(undefined);
+        return;
+      }
+      resolve(result.policy_key || undefined);
+    });
+  });
+};
+
+const fromBase64 = (value: string): Uint8Array => {
+  return Uint8Array.from(atob(value), (c) => c.charCodeAt(0));
+};
+
+/**
+ * Verify the Ed25519 signature of the backend over the policy version and body
+ */
+const verifyPolicySignature = async (
+  policyKey: string,
+  version: string,
+  signature: string,
+  body: string
+): Promise<boolean> => {
+  const key = await crypto.subtle.importKey('raw', fromBase64(policyKey), { name: 'Ed25519' }, false, ['verify']);
+  return crypto.subtle.verify(
+    { name: 'Ed25519' },
+    key,
+    fromBase64(signature),
+    new TextEncoder().encode(version + '\n' + body)
+  );
+};
+
+const savePolicy = async (apiUrl: string, policy: Policy): Promise<void> => {
+  const cached: CachedPolicy = { api: apiUrl, policy };
+  return new Promise((resolve) => {
+    chrome.storage.local.set({ policy: cached }, resolve);
+  });
+};
+
+/**
+ * Fetch the policy if it changed since the cached version.
+ * Policies without a valid signature of the pinned policy key are rejected, so they cannot be forged,
+ * and versions older than the cached one are rejected so an old policy cannot be replayed.
+ */
+export const refreshPolicy = async (apiUrl: string): Promise<Policy | undefined> => {
+  const url = `${apiUrl}/api/policy`;
+  const config = await loadConfig();
+  const cached = await loadPolicy(apiUrl);
+
+  const policyKey = await loadPolicyKey();
+  if (!policyKey) {
+    throw new Error('No policy_key is pinned in the managed configuration, policies are not applied');
+  }
+
+  const credential = await getCredential(config);
+  const signature = await signRequest(credential, 'GET', url, '');
+  const headers: Record<string, string> = {
+    'Authorization': `Bearer ${credential.token}`,
+    ...signature,
+  };
+  if (cached) {
+    headers['If-None-Match'] = `"${cached.version}"`;
+  }
+
+  // bypass the browser cache, the ETag is handled here
+  const response = await fetch(url, { method: 'GET', headers, cache: 'no-store' });
+
+  if (response.status === 304) {
+    return cached;
+  }
+
+  // our credential is no longer accepted, enroll again on the next request
+  await handleUnauthorized(response);
+
+  if (!response.ok) {
+    throw new Error(`Policy request failed: ${response.status} ${response.statusText}`);
+  }
+
+  const body = await response.text();
+  if (!(await verifyResponse(credential, url, signature, response, body))) {
+    throw new Error('Policy has an invalid signature');
+  }
+
+  const version = response.headers.get('X-Shade-Policy-Version') || '';
+  const policySignature = response.headers.get('X-Shade-Policy-Signature') || '';
+  if (!policySignature || !(await verifyPolicySignature(policyKey, version, policySignature, body))) {
+    throw new Error('Policy is not signed with the pinned policy key');
+  }
+
+  const policy: Policy = JSON.parse(body);
+  if (String(policy.version) !== version) {
+    throw new Error('Policy version does not match its signature');
+  }
+
+  if (cached && policy.version < cached.version) {
+    throw new Error(`Policy version ${policy.version} is older than the applied version ${cached.version}`);
+  }
+
+  await savePolicy(apiUrl, policy);
+
+  if (!cached || cached.version !== policy.version) {
+    console.log('Applied policy version', policy.version);
+  }
+
+  return policy;
+};
+
+/**
+ * Whether the host of a captured domain is one of the policy domains or a subdomain of one.
+ * Blocked domains are checked here before a login is submitted, the backend also blocks apps reviewed as
+ * prohibited and tells us in its response.
+ */
+export const matchesPolicyDomain = (domain: string, policyDomains: string[]): boolean => {
+  let host = domain;
+  try {
+    host = new URL(domain).hostname;
+  } catch (e) {
+    // not an origin, compare as is
+  }
+  host = host.toLowerCase();
+
+  return policyDomains.some((policyDomain) => host === policyDomain || host.endsWith('.' + policyDomain));
+};
+
+/**
+ * Whether the policy blocks logging in on a domain, checked before credentials leave the browser
+ */
+export const isBlockedDomain = (domain: string, policy: Policy | undefined): boolean => {
+  return !!policy && policy.enabled && matchesPolicyDomain(domain, policy.blocked_domains);
+};
+
+/**
+ * The policy message when one is set, with {domain} replaced, or else the built-in text
+ */
+export const policyMessage = (message: string | undefined, domain: string, fallback: string): string => {
+  if (!message) {
+    return fallback;
+  }
+  return message.split('{domain}').join(domain);
+};
@@ -25,6 +25,8 @@ export interface ApiResponse {
 export enum MessageType {
   LOGIN_DETECTED = 'LOGIN_DETECTED',
   GET_DEVICE_ID = 'GET_DEVICE_ID',
+  // the content script stopped a login on a blocked domain, the tab has to leave it
+  BLOCKED_PAGE = 'BLOCKED_PAGE',
 }
 
 /**
@@ -35,6 +37,25 @@ export interface Message {
   data?: any;
 }
 
+/**
+ * What the backend decided to do with a submitted login
+ */
+export interface Enforcement {
+  action: 'allow' | 'warn' | 'block';
+  message?: string;
+  app?: string;
+}
+
+/**
+ * Per-device credential received from the backend on enrollment
+ */
+export interface DeviceCredential {
+  deviceId: string;
+  token: string;
+  // signs requests and responses, never sent to the backend after enrollment
+  signingKey: string;
+}
+
 /**
  * Configuration for the extension
  */
@@ -42,6 +63,7 @@ export interface ExtensionConfig {
   api: string;
   id: string;
   enabled: boolean;
+  // bootstrap token, only used once to enroll this device
   token: string;
   locked: boolean;
   filters: string[];
@@ -57,4 +79,28 @@ export const DEFAULT_CONFIG: ExtensionConfig = {
   token: '',
   locked: false,
   filters: [],
-};
\ No newline at end of file
+};
+
+/**
+ * Warnings of the policy that replace the built-in text, empty when not set
+ */
+export interface PolicyMessages {
+  prohibited: string;
+  blocked: string;
+  breach: string;
+  reuse: string;
+}
+
+/**
+ * Policy managed on the backend dashboard, as returned by /api/policy
+ */
+export interface Policy {
+  version: number;
+  enabled: boolean;
+  username_filters: string[];
+  ignored_domains: string[];
+  prohibited_domains: string[];
+  blocked_domains: string[];
+  messages: PolicyMessages;
+  updated_at: string;
+}
@@ -2,7 +2,7 @@
  * Utility functions for the extension
  */
 
-import { ExtensionConfig, DEFAULT_CONFIG } from './types';
+import { DeviceCredential, ExtensionConfig, DEFAULT_CONFIG } from './types';
 
 /**
  * Generate a unique device ID
@@ -65,6 +65,179 @@ export const saveConfig = async (config: ExtensionConfig): Promise<void> => {
   });
 };
 
+/**
+ * Load the device credential from storage
+ */
+const loadCredential = async (): Promise<DeviceCredential | undefined> => {
+  return new Promise((resolve) => {
+    chrome.storage.local.get('credential', (result) => {
+      resolve(result.credential);
+    });
+  });
+};
+
+/**
+ * Forget the device credential so the next request enrolls again
+ */
+export const clearCredential = async (): Promise<void> => {
+  return new Promise((resolve) => {
+    chrome.storage.local.remove('credential', resolve);
+  });
+};
+
+/**
+ * Forget the device credential when the backend rejected it, so the next request enrolls again.
+ * Other authentication failures such as a stale signature keep it, an enrolled device cannot enroll again
+ * until an administrator resets it.
+ */
+export const handleUnauthorized = async (response: Response): Promise<void> => {
+  if (response.status !== 401) {
+    return;
+  }
+
+  try {
+    const error = await response.clone().json();
+    if (error.message === 'invalid token') {
+      await clearCredential();
+    }
+  } catch (e) {
+    console.error('Failed to parse error response:', e);
+  }
+};
+
+/**
+ * Exchange the bootstrap token for a credential bound to this device
+ */
+const enrollDevice = async (config: ExtensionConfig): Promise<DeviceCredential> => {
+  const response = await fetch(`${config.api}/api/enroll`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Authorization': `Bearer ${config.token}`,
+    },
+    body: JSON.stringify({ device_id: config.id }),
+  });
+
+  // an enrolled device id has to be reset by an administrator before it can enroll again
+  if (response.status === 409) {
+    throw new Error('Enrollment failed: this device is already enrolled, ask an administrator to reset it');
+  }
+
+  if (!response.ok) {
+    throw new Error(`Enrollment failed: ${response.status} ${response.statusText}`);
+  }
+
+  const data = await response.json();
+  const credential: DeviceCredential = {
+    deviceId: data.device_id,
+    token: data.token,
+    signingKey: data.signing_key,
+  };
+
+  await new Promise<void>((resolve) => {
+    chrome.storage.local.set({ credential }, resolve);
+  });
+
+  console.log('Enrolled device', credential.deviceId);
+
+  return credential;
+};
+
+/**
+ * Get the credential of this device, enrolling first if needed
+ */
+export const getCredential = async (config: ExtensionConfig): Promise<DeviceCredential> => {
+  const credential = await loadCredential();
+
+  // the device id changes when the configuration is reset, enroll again in that case.
+  // Credentials from before signing keys were revoked by the backend upgrade and are replaced as well.
+  if (credential && credential.deviceId === config.id && credential.signingKey) {
+    return credential;
+  }
+
+  return enrollDevice(config);
+};
+
+const toHex = (buffer: ArrayBuffer | Uint8Array): string => {
+  return Array.from(new Uint8Array(buffer))
+    .map((b) => b.toString(16).padStart(2, '0'))
+    .join('');
+};
+
+const sha256Hex = async (data: string): Promise<string> => {
+  return toHex(await crypto.subtle.digest('SHA-256', new TextEncoder().encode(data)));
+};
+
+/**
+ * The path and query of a URL as covered by signatures, the query is left out when empty
+ */
+const signedTarget = (url: string): string => {
+  const parsed = new URL(url);
+  return parsed.pathname + parsed.search;
+};
+
+/**
+ * HMAC a payload with the signing key received on enrollment
+ */
+const hmacHex = async (credential: DeviceCredential, payload: string): Promise<string> => {
+  const key = await crypto.subtle.importKey(
+    'raw',
+    new TextEncoder().encode(credential.signingKey),
+    { name: 'HMAC', hash: 'SHA-256' },
+    false,
+    ['sign']
+  );
+  return toHex(await crypto.subtle.sign('HMAC', key, new TextEncoder().encode(payload)));
+};
+
+/**
+ * Sign a request with the device signing key so the backend can detect tampering and replays.
+ * The signing key is never sent along, so a captured credential is not enough to sign requests.
+ */
+export const signRequest = async (
+  credential: DeviceCredential,
+  method: string,
+  url: string,
+  body: string
+): Promise<Record<string, string>> => {
+  const timestamp = Math.floor(Date.now() / 1000).toString();
+  const nonce = toHex(crypto.getRandomValues(new Uint8Array(16)));
+  const payload = [method, signedTarget(url), timestamp, nonce, await sha256Hex(body)].join('\n');
+
+  return {
+    'X-Shade-Timestamp': timestamp,
+    'X-Shade-Nonce': nonce,
+    'X-Shade-Signature': await hmacHex(credential, payload),
+  };
+};
+
+/**
+ * Verify the signature of a response to a signed request.
+ * The backend signs with the same signing key, over the timestamp and nonce of our request.
+ */
+export const verifyResponse = async (
+  credential: DeviceCredential,
+  url: string,
+  requestHeaders: Record<string, string>,
+  response: Response,
+  body: string
+): Promise<boolean> => {
+  const signature = response.headers.get('X-Shade-Signature');
+  if (!signature) {
+    return false;
+  }
+
+  const payload = [
+    'RESPONSE',
+    signedTarget(url),
+    requestHeaders['X-Shade-Timestamp'],
+    requestHeaders['X-Shade-Nonce'],
+    await sha256Hex(body),
+  ].join('\n');
+
+  return signature === await hmacHex(credential, payload);
+};
+
 /**
  * Send data to the backend API
  */
@@ -74,26 +247,57 @@ export const sendToBackend = async (
   apiUrl: string
 ): Promise<Response> => {
   const url = `${apiUrl}${endpoint}`;
+  const config = await loadConfig();
+
+  const headers: Record<string, string> = {
+    'Content-Type': 'application/json',
+  };
+
+  const body = JSON.stringify(data);
 
-  // Load token from config to include Authorization header when available
-  let token = '';
   try {
-    const config = await loadConfig();
-    token = config.token || '';
+    const credential = await getCredential(config);
+    headers['Authorization'] = `Bearer ${credential.token}`;
+    Object.assign(headers, await signRequest(credential, 'POST', url, body));
   } catch (e) {
-    // Ignore config load error; proceed without auth header
+    console.error('Failed to obtain device credential:', e);
   }
 
+  const response = await fetch(url, {
+    method: 'POST',
+    headers,
+    body,
+  });
+
+  // our credential is no longer accepted, enroll again on the next request
+  await handleUnauthorized(response);
+
+  return response;
+};
+
+/**
+ * Fetch data from the backend API
+ */
+export const getFromBackend = async (
+  endpoint: string,
+  apiUrl: string
+): Promise<Response> => {
+  const url = `${apiUrl}${endpoint}`;
+  const config = await loadConfig();
+
+  const credential = await getCredential(config);
   const headers: Record<string, string> = {
-    'Content-Type': 'application/json',
+    'Authorization': `Bearer ${credential.token}`,
+    ...(await signRequest(credential, 'GET', url, '')),
   };
-  if (token) {
-    headers['Authorization'] = `Bearer ${token}`;
-  }
 
-  return fetch(url, {
-    method: 'POST',
+  const response = await fetch(url, {
+    method: 'GET',
     headers,
-    body: JSON.stringify(data),
   });
-};
\ No newline at end of file
+
+  // our credential is no longer accepted, enroll again on the next request
+  await handleUnauthorized(response);
+
+  return response;
+};
@@ -10,6 +10,7 @@ module.exports = (env, argv) => {
       background: './src/background/index.ts',
       content: './src/content/index.ts',
       popup: './src/popup/index.ts',
+      blocked: './src/blocked/index.ts',
     },
     output: {
       path: path.resolve(__dirname, 'dist'),
@@ -43,8 +44,10 @@ module.exports = (env, argv) => {
       new CopyPlugin({
         patterns: [
           { from: 'manifest.json', to: '.' },
+          { from: 'schema.json', to: '.' },
           { from: 'public', to: '.' },
           { from: 'src/popup/popup.html', to: '../popup.html' },
+          { from: 'src/blocked/blocked.html', to: '../blocked.html' },
         ],
       }),
     ],